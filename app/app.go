@@ -3,35 +3,33 @@ package app
 import (
 	"banking-auth/domain"
 	"banking-auth/service"
+	"flag"
 	"fmt"
 	"github.com/barnettt/banking-lib/logger"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
 const contentTypeJson string = "application/json"
 const contentTypeXml string = "application/xml"
+const hmacKeyId string = "hs256"
 
-func StartApp() {
-	if os.Getenv("SERVER_PORT") == "" ||
-		os.Getenv("SERVER_HOST") == "" ||
-		os.Getenv("DB_HOST") == "" ||
-		os.Getenv("DB_PORT") == "" ||
-		os.Getenv("DB_USER") == "" ||
-		os.Getenv("DB_PASSWD") == "" ||
-		os.Getenv("DB_PROTOCOL") == "" ||
-		os.Getenv("DB_NAME") == "" ||
-		os.Getenv("DB_DRIVER_NAME") == "" {
-		logger.Error("Environment variables are undefined ... ")
-		log.Fatal("Environment variables are undefined ... ")
-	}
-	// create db connection pool
-	var dbClient = getDbClient()
+// STORAGE_BACKEND_MEMORY keeps everything in memory, the other backends are named after their sql driver
+const STORAGE_BACKEND_MEMORY string = "memory"
+
+func StartApp(args []string) {
+	config := loadConfig(args)
+	// create db connection pool, or the in memory store
+	storage := getStorage(config)
 	// create a new multiplexer
 	// print("creating mux\n ")
 	logger.Info("creating mux ")
@@ -39,44 +37,355 @@ func StartApp() {
 
 	router := mux.NewRouter()
 	// Wiring app components
-	repo := domain.NewUserRepository(dbClient)
-	handler := UserHandler{service.NewUserService(repo, service.NewTokenService(repo), domain.GetUserRolePermissions())}
+	repo := storage.Auth
+	// the hash algorithm has been validated with the config, argon2id is used when it is not set
+	passwordHasher, _ := domain.NewPasswordHasher(config.Password.HashAlgorithm)
+	keyRing, stopKeyRotation := getKeyRing(config)
+	tokenService := service.NewTokenService(repo, keyRing, config.Issuer(), domain.TokenLifetimes{
+		AccessToken: config.Tokens.AccessTokenLifetime, RefreshToken: config.Tokens.RefreshTokenLifetime})
+	mfaRepository := storage.Mfa
+	roleRepository := storage.Roles
+	rolePermissions, stopRoleRefresh := getRolePermissions(config, roleRepository)
+	handler := UserHandler{service.NewUserService(repo, tokenService, rolePermissions, passwordHasher,
+		keyRing, mfaRepository, storage.LoginAttempts, getLockoutPolicy(config),
+		getPasswordPolicy(config), getAccessPolicies(config), config.Mfa.Required)}
+	mfaHandler := MfaHandler{service.NewMfaService(handler.userService, mfaRepository, config.Mfa.TotpIssuer)}
+	webAuthnHandler := WebAuthnHandler{service.NewWebAuthnService(handler.userService,
+		storage.WebAuthn, getWebAuthnRpId(config), config.Mfa.WebAuthnRpName, getWebAuthnOrigins(config))}
+	userAccountRepository := storage.UserAccounts
+	mailSender := getMailSender(config)
+	userAdminHandler := UserAdminHandler{service.NewUserAccountService(handler.userService, userAccountRepository,
+		mailSender, config.SelfRegistrationEnabled, config.Issuer()+"/customers/register/verify")}
+	passwordHandler := PasswordHandler{service.NewPasswordService(handler.userService, userAccountRepository,
+		mailSender, getPasswordResetUrl(config))}
+	roleHandler := RoleHandler{service.NewRoleService(handler.userService, roleRepository)}
+	keysHandler := KeysHandler{keyRing}
+	oauthHandler := OAuthHandler{service.NewOAuthService(handler.userService, tokenService,
+		storage.Clients, storage.AuthorizationCodes, passwordHasher)}
 
 	// define all the routes
 
 	router.HandleFunc("/customers/login", handler.GetUserByUserName).Methods(http.MethodPost)
+	router.HandleFunc("/customers/login/mfa", mfaHandler.VerifyMfaLogin).Methods(http.MethodPost)
+	router.HandleFunc("/customers/register", userAdminHandler.Register).Methods(http.MethodPost)
+	router.HandleFunc("/customers/register/verify", userAdminHandler.VerifyEmail).Methods(http.MethodGet)
+	router.HandleFunc("/customers/password/change", passwordHandler.ChangePassword).Methods(http.MethodPost)
+	router.HandleFunc("/customers/password/check", passwordHandler.CheckPassword).Methods(http.MethodPost)
+	router.HandleFunc("/customers/password/forgot", passwordHandler.ForgotPassword).Methods(http.MethodPost)
+	router.HandleFunc("/customers/password/reset", passwordHandler.ResetPassword).Methods(http.MethodPost)
+	router.HandleFunc("/customers/webauthn/login/begin", webAuthnHandler.BeginLogin).Methods(http.MethodPost)
+	router.HandleFunc("/customers/webauthn/login/finish", webAuthnHandler.FinishLogin).Methods(http.MethodPost)
+	router.HandleFunc("/customers/webauthn/register/begin", webAuthnHandler.BeginRegistration).Methods(http.MethodPost)
+	router.HandleFunc("/customers/webauthn/register/finish", webAuthnHandler.FinishRegistration).Methods(http.MethodPost)
+	router.HandleFunc("/customers/mfa/totp", mfaHandler.EnrollTotp).Methods(http.MethodPost)
+	router.HandleFunc("/customers/mfa/totp/confirm", mfaHandler.ConfirmTotp).Methods(http.MethodPost)
 	router.HandleFunc("/auth/verify", handler.VerifyRequest).Methods(http.MethodGet)
 	router.HandleFunc("/auth/refresh", handler.Refresh).Methods(http.MethodPost)
+	router.HandleFunc("/auth/logout", handler.Logout).Methods(http.MethodPost)
+	router.HandleFunc("/auth/revoke", handler.Revoke).Methods(http.MethodPost)
+	router.HandleFunc("/admin/users", userAdminHandler.CreateUser).Methods(http.MethodPost)
+	router.HandleFunc("/admin/users", userAdminHandler.GetUsers).Methods(http.MethodGet)
+	router.HandleFunc("/admin/users/{user_name}", userAdminHandler.GetUser).Methods(http.MethodGet)
+	router.HandleFunc("/admin/users/{user_name}", userAdminHandler.UpdateUser).Methods(http.MethodPut)
+	router.HandleFunc("/admin/users/{user_name}", userAdminHandler.DeleteUser).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/users/{user_name}/disable", userAdminHandler.DisableUser).Methods(http.MethodPost)
+	router.HandleFunc("/admin/users/{user_name}/enable", userAdminHandler.EnableUser).Methods(http.MethodPost)
+	router.HandleFunc("/admin/users/{user_name}/sessions", handler.RevokeUserSessions).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/users/{user_name}/lockout", handler.UnlockUser).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/roles", roleHandler.GetRoles).Methods(http.MethodGet)
+	router.HandleFunc("/admin/roles", roleHandler.CreateRole).Methods(http.MethodPost)
+	router.HandleFunc("/admin/roles/refresh", roleHandler.Refresh).Methods(http.MethodPost)
+	router.HandleFunc("/admin/roles/{role}", roleHandler.DeleteRole).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/roles/{role}/permissions/{permission}", roleHandler.GrantPermission).Methods(http.MethodPut)
+	router.HandleFunc("/admin/roles/{role}/permissions/{permission}", roleHandler.RevokePermission).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/roles/{role}/denies/{permission}", roleHandler.DenyPermission).Methods(http.MethodPut)
+	router.HandleFunc("/admin/roles/{role}/denies/{permission}", roleHandler.RemoveDeny).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/roles/{role}/parents/{parent}", roleHandler.AddParentRole).Methods(http.MethodPut)
+	router.HandleFunc("/admin/roles/{role}/parents/{parent}", roleHandler.RemoveParentRole).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/permissions", roleHandler.GetPermissions).Methods(http.MethodGet)
+	router.HandleFunc("/admin/permissions", roleHandler.CreatePermission).Methods(http.MethodPost)
+	router.HandleFunc("/admin/permissions/{permission}", roleHandler.UpdatePermission).Methods(http.MethodPut)
+	router.HandleFunc("/admin/permissions/{permission}", roleHandler.DeletePermission).Methods(http.MethodDelete)
+	router.HandleFunc("/oauth/authorize", oauthHandler.Authorize).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/oauth/token", oauthHandler.Token).Methods(http.MethodPost)
+	router.HandleFunc("/oauth/introspect", oauthHandler.Introspect).Methods(http.MethodPost)
+	router.HandleFunc("/userinfo", oauthHandler.UserInfo).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/.well-known/openid-configuration", oauthHandler.OpenIdConfiguration).Methods(http.MethodGet)
+	router.HandleFunc("/.well-known/jwks.json", keysHandler.GetJwks).Methods(http.MethodGet)
 
-	// log any error to fatal
-	// print("starting listener ..... \n")
-	port := os.Getenv("SERVER_PORT")
-	host := os.Getenv("SERVER_HOST")
-	logger.Info("starting listener ..... on server port : " + port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf("%s:%s", host, port), router))
+	// the client address is resolved first, the rate limiter keys on it
+	clientIpMiddleware := getClientIpMiddleware(config)
+	router.Use(clientIpMiddleware.Middleware)
+	rateLimitMiddleware := getRateLimitMiddleware(config)
+	router.Use(rateLimitMiddleware.Middleware)
 
+	// serve until SIGTERM or SIGINT, then drain the requests in flight
+	serve(config, newServer(config, router), storage, []func(){stopKeyRotation, stopRoleRefresh})
 }
 
 //func getTransactionManager(client *sqlx.DB) db.TxManager {
 //	return db.NewTxManager(client)
 //}
 
-func getDbClient() *sqlx.DB {
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWD")
-	dbName := os.Getenv("DB_NAME")
-	dbProtocol := os.Getenv("DB_PROTOCOL")
-	dbDrivername := os.Getenv("DB_DRIVER_NAME")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
+// loadConfig loads the config or stops the service naming every setting that is missing or invalid
+func loadConfig(args []string) *Config {
+	config, _, err := LoadConfig(os.Args[0], args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		log.Fatal(err)
+	}
+	return config
+}
+
+// getSigningKey loads the PEM private key named by SIGNING_KEY_FILE, tokens fall back to
+// HS256 with SIGNING_SECRET when no key file is configured
+func getSigningKey(config *Config) domain.SigningKey {
+	if config.Signing.KeyFile == "" {
+		logger.Info("SIGNING_KEY_FILE is undefined, signing tokens with HS256")
+		return domain.NewHmacSigningKey(getHmacKeyId(config), []byte(config.Signing.Secret))
+	}
+	return loadSigningKey(config, config.Signing.KeyId, config.Signing.KeyFile)
+}
+
+// getHmacKeyId is the kid of the HS256 secret, a rotated secret needs a new SIGNING_KEY_ID so the
+// tokens signed with the old one can still find it
+func getHmacKeyId(config *Config) string {
+	if config.Signing.KeyId != "" {
+		return config.Signing.KeyId
+	}
+	return hmacKeyId
+}
+
+// splitKeyEntry splits a kid=value entry of the previous keys, an entry without a kid is returned with an empty one
+func splitKeyEntry(entry string) (string, string) {
+	keyId, value, found := strings.Cut(entry, "=")
+	if !found {
+		return "", entry
+	}
+	return keyId, value
+}
 
-	client, err := sqlx.Open(fmt.Sprintf("%s", dbDrivername), fmt.Sprintf("%s:%s@%s(%s:%s)/%s?parseTime=true", user, password, dbProtocol, dbHost, dbPort, dbName))
+func loadSigningKey(config *Config, keyId string, keyFile string) domain.SigningKey {
+	signingKey, err := domain.LoadSigningKey(keyId, getSigningAlgorithm(config), keyFile)
+	if err != nil {
+		logger.Error("Unable to load signing key " + keyFile + " : " + err.Error())
+		log.Fatal(err)
+	}
+	return *signingKey
+}
+
+func getSigningAlgorithm(config *Config) string {
+	if config.Signing.Algorithm == "" {
+		if config.Signing.KeyFile == "" {
+			return jwt.SigningMethodHS256.Alg()
+		}
+		return jwt.SigningMethodRS256.Alg()
+	}
+	return config.Signing.Algorithm
+}
+
+// getKeyRing builds the key ring from the current signing key, the keys it replaced which must keep
+// verifying for the grace period, and optionally a key scheduled to take over signing
+func getKeyRing(config *Config) (*domain.KeyRing, func()) {
+	gracePeriod := config.Signing.RotationGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = config.Tokens.RefreshTokenLifetime
+	}
+	keyRing := domain.NewKeyRing(getSigningKey(config), gracePeriod)
+	// previous keys must keep the kid they signed with, key files without one get their thumbprint
+	for _, entry := range config.Signing.PreviousKeyFiles {
+		keyId, keyFile := splitKeyEntry(entry)
+		keyRing.AddVerificationKey(loadSigningKey(config, keyId, keyFile), time.Now())
+	}
+	for _, entry := range config.Signing.PreviousSecrets {
+		keyId, secret := splitKeyEntry(entry)
+		keyRing.AddVerificationKey(domain.NewHmacSigningKey(keyId, []byte(secret)), time.Now())
+	}
+	if config.Signing.NextKeyFile != "" {
+		// the activation time has been validated with the config
+		activatesAt, _ := time.Parse(time.RFC3339, config.Signing.NextKeyActivatesAt)
+		keyRing.ScheduleRotation(loadSigningKey(config, config.Signing.NextKeyId, config.Signing.NextKeyFile), activatesAt)
+	}
+	if config.Signing.RotationInterval > 0 {
+		return keyRing, keyRing.StartAutoRotation(config.Signing.RotationInterval, getSigningAlgorithm(config))
+	}
+	return keyRing, func() {}
+}
+
+// getRolePermissions loads the roles from the database, ROLE_REFRESH_INTERVAL controls how often they are
+// reloaded so changes made through another instance are picked up, 0 disables the refresh
+func getRolePermissions(config *Config, roleRepository domain.RoleRepository) (*domain.RolePermissions, func()) {
+	rolePermissions, appErr := domain.NewRolePermissions(roleRepository)
+	if appErr != nil {
+		logger.Error("Unable to load roles : " + appErr.Message)
+		// the migrations create and seed the roles tables, an empty set means they were emptied since
+		log.Fatalf("unable to load roles : %s, check the roles tables hold the roles seeded by %s migrate up",
+			appErr.Message, os.Args[0])
+	}
+	if config.Roles.RefreshInterval > 0 {
+		return rolePermissions, rolePermissions.StartAutoRefresh(roleRepository, config.Roles.RefreshInterval)
+	}
+	return rolePermissions, func() {}
+}
+
+// getAccessPolicies loads the attribute based policies Verify applies on top of the role permissions
+// from the json file named by ACCESS_POLICY_FILE, without it only the role permissions apply
+func getAccessPolicies(config *Config) domain.AccessPolicies {
+	if config.Roles.AccessPolicyFile == "" {
+		return nil
+	}
+	policies, err := domain.LoadAccessPolicies(config.Roles.AccessPolicyFile)
+	if err != nil {
+		logger.Error("Unable to load access policies : " + err.Error())
+		log.Fatal(err)
+	}
+	logger.Info(fmt.Sprintf("Loaded %d access policies", len(policies)))
+	return policies
+}
+
+// getWebAuthnRpId is the domain passkeys are bound to, it must be the host of the login page or a parent domain of it
+func getWebAuthnRpId(config *Config) string {
+	if config.Mfa.WebAuthnRpId != "" {
+		return config.Mfa.WebAuthnRpId
+	}
+	return config.Server.Host
+}
+
+// getWebAuthnOrigins lists the origins allowed to run WebAuthn ceremonies, the issuer by default
+func getWebAuthnOrigins(config *Config) []string {
+	if len(config.Mfa.WebAuthnOrigins) == 0 {
+		return []string{config.Issuer()}
+	}
+	allowed := make([]string, 0)
+	for _, origin := range config.Mfa.WebAuthnOrigins {
+		allowed = append(allowed, strings.TrimSuffix(origin, "/"))
+	}
+	return allowed
+}
+
+// getClientIpMiddleware trusts X-Forwarded-For from TRUSTED_PROXIES only, with none the peer address is used
+func getClientIpMiddleware(config *Config) ClientIpMiddleware {
+	// the proxies have been validated with the config
+	trustedProxies, _ := ParseTrustedProxies(config.Server.TrustedProxies)
+	return NewClientIpMiddleware(trustedProxies)
+}
+
+// getRateLimitMiddleware keeps buckets in memory, RATE_LIMITS overrides the default per route limits
+func getRateLimitMiddleware(config *Config) RateLimitMiddleware {
+	routeLimits := DefaultRouteLimits()
+	// the overrides have been validated with the config
+	_ = ParseRouteLimits(routeLimits, config.RateLimits)
+	return NewRateLimitMiddleware(domain.NewInMemoryRateLimiter(routeLimits.longestPeriod()), routeLimits)
+}
+
+// getPasswordResetUrl is the page reset emails link to, usually the front end's reset form
+func getPasswordResetUrl(config *Config) string {
+	if config.Password.ResetUrl != "" {
+		return config.Password.ResetUrl
+	}
+	return config.Issuer() + "/customers/password/reset"
+}
+
+// getMailSender sends through SMTP_HOST when it is set, otherwise mails are only logged
+func getMailSender(config *Config) domain.MailSender {
+	if config.Mail.SmtpHost == "" {
+		logger.Info("SMTP_HOST is undefined, emails will not be sent")
+		return domain.LogMailSender{}
+	}
+	return domain.NewSmtpMailSender(config.Mail.SmtpHost, config.Mail.SmtpPort, config.Mail.SmtpUser,
+		config.Mail.SmtpPassword, config.Mail.From)
+}
+
+func getLockoutPolicy(config *Config) domain.LockoutPolicy {
+	return domain.LockoutPolicy{
+		MaxUserFailures: config.Lockout.MaxUserFailures,
+		MaxIpFailures:   config.Lockout.MaxIpFailures,
+		LockoutDuration: config.Lockout.Duration,
+		BaseDelay:       config.Lockout.BaseDelay,
+		MaxDelay:        config.Lockout.MaxDelay,
+	}
+}
+
+// getPasswordPolicy builds the policy from the config, BREACHED_PASSWORDS_FILE names a sorted SHA-1 hash list
+func getPasswordPolicy(config *Config) domain.PasswordPolicy {
+	policy := domain.PasswordPolicy{
+		MinLength:     config.Password.MinLength,
+		MaxLength:     config.Password.MaxLength,
+		RequireUpper:  config.Password.RequireUpper,
+		RequireLower:  config.Password.RequireLower,
+		RequireDigit:  config.Password.RequireDigit,
+		RequireSymbol: config.Password.RequireSymbol,
+		MaxAge:        config.Password.MaxAge,
+		HistorySize:   config.Password.HistorySize,
+	}
+	// characters outside ascii take several bytes, so the character limit alone does not keep bcrypt working
+	if config.Password.HashAlgorithm == domain.HASH_ALGORITHM_BCRYPT {
+		policy.MaxBytes = domain.BCRYPT_MAX_PASSWORD_BYTES
+	}
+	if config.Password.BreachedFile != "" {
+		hashList, err := domain.NewHashListFile(config.Password.BreachedFile)
+		if err != nil {
+			logger.Error("Unable to open BREACHED_PASSWORDS_FILE : " + err.Error())
+			log.Fatal(err)
+		}
+		policy.BreachedHashes = hashList
+	}
+	return policy
+}
+
+// getStorage opens the storage backend, the in memory store can be seeded with users and clients
+// from the json file named by MEMORY_SEED_FILE
+func getStorage(config *Config) domain.Storage {
+	backend := config.StorageBackend()
+	logger.Info("using storage backend " + backend)
+	if backend != STORAGE_BACKEND_MEMORY {
+		database := domain.NewDatabase(getDbClient(config))
+		checkSchema(config, database)
+		return domain.NewDatabaseStorage(database)
+	}
+	store := domain.NewInMemoryStore()
+	if config.Storage.MemorySeedFile != "" {
+		seed, err := domain.LoadInMemorySeed(config.Storage.MemorySeedFile)
+		if err != nil {
+			logger.Error("Unable to load MEMORY_SEED_FILE : " + err.Error())
+			log.Fatal(err)
+		}
+		store.Seed(seed)
+	}
+	return domain.NewInMemoryStorage(store)
+}
+
+func getDbClient(config *Config) *sqlx.DB {
+	dbDrivername := config.StorageBackend()
+	db := config.Database
+
+	var dataSourceName string
+	switch domain.Dialect(dbDrivername) {
+	case domain.DIALECT_POSTGRES:
+		dataSourceName = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s", db.Host, db.Port, db.User, db.Password, db.Name)
+		if db.SslMode != "" {
+			dataSourceName += " sslmode=" + db.SslMode
+		}
+	case domain.DIALECT_SQLITE:
+		// DB_NAME is the database file, writers wait for each other rather than failing with database is locked
+		dataSourceName = fmt.Sprintf("file:%s?_busy_timeout=5000&_foreign_keys=on", db.Name)
+	default:
+		dataSourceName = fmt.Sprintf("%s:%s@%s(%s:%s)/%s?parseTime=true", db.User, db.Password, db.Protocol, db.Host, db.Port, db.Name)
+	}
+	client, err := sqlx.Open(fmt.Sprintf("%s", dbDrivername), dataSourceName)
 	if err != nil {
 		panic(err)
 	}
 	// See "Important settings" section.
-	client.SetConnMaxLifetime(time.Minute * 3)
-	client.SetMaxOpenConns(10)
-	client.SetMaxIdleConns(10)
+	client.SetConnMaxLifetime(db.ConnMaxLifetime)
+	client.SetMaxOpenConns(db.MaxOpenConns)
+	client.SetMaxIdleConns(db.MaxIdleConns)
+	if domain.Dialect(dbDrivername) == domain.DIALECT_SQLITE {
+		// sqlite allows a single writer, one connection keeps transactions from locking each other out
+		client.SetMaxOpenConns(1)
+	}
 	return client
 }
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"banking-auth/domain"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeEcKeyFile(t *testing.T, name string) string {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), name)
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+// signedBy signs a token the way the key did while it was the signing key
+func signedBy(t *testing.T, key domain.SigningKey) string {
+	token, err := key.Sign(jwt.NewWithClaims(key.Method, jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestKeyRingVerifiesPreviousKeyByItsKid(t *testing.T) {
+	previousKeyFile := writeEcKeyFile(t, "previous.pem")
+	previousKey, err := domain.LoadSigningKey("2026-04", "ES256", previousKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signedBy(t, *previousKey)
+
+	config := &Config{Tokens: TokenConfig{RefreshTokenLifetime: time.Hour}, Signing: SigningConfig{
+		KeyFile: writeEcKeyFile(t, "current.pem"), KeyId: "2026-10", Algorithm: "ES256",
+		PreviousKeyFiles: stringList{"2026-04=" + previousKeyFile}}}
+	keyRing, stop := getKeyRing(config)
+	defer stop()
+	if keyRing.SigningKey().KeyId != "2026-10" {
+		t.Fatalf("signing with %s, want 2026-10", keyRing.SigningKey().KeyId)
+	}
+	if _, err = jwt.Parse(token, keyRing.Keyfunc); err != nil {
+		t.Fatalf("token signed with the previous key was refused: %v", err)
+	}
+}
+
+func TestKeyRingVerifiesPreviousSecretByItsKid(t *testing.T) {
+	previousSecret := "previous signing secret of 32 bytes"
+	token := signedBy(t, domain.NewHmacSigningKey(hmacKeyId, []byte(previousSecret)))
+
+	config := &Config{Tokens: TokenConfig{RefreshTokenLifetime: time.Hour}, Signing: SigningConfig{
+		Secret: "current signing secret of 32 bytes", KeyId: "hs256-2",
+		PreviousSecrets: stringList{hmacKeyId + "=" + previousSecret}}}
+	keyRing, stop := getKeyRing(config)
+	defer stop()
+	if _, err := jwt.Parse(token, keyRing.Keyfunc); err != nil {
+		t.Fatalf("token signed with the previous secret was refused: %v", err)
+	}
+	if _, err := jwt.Parse(signedBy(t, domain.NewHmacSigningKey("", []byte(previousSecret))), keyRing.Keyfunc); err == nil {
+		t.Fatal("token without a kid was accepted")
+	}
+}
@@ -0,0 +1,33 @@
+package app
+
+import "html/template"
+
+// authorizePage is the login and consent form shown by /oauth/authorize, the authorization
+// request is carried through the form in hidden fields
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in</title></head>
+<body>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Request.ClientId}}
+<form method="post" action="/oauth/authorize">
+  <p>{{.ClientName}} is requesting access to your account{{if .Scopes}} with the following permissions{{end}}</p>
+  {{if .Scopes}}<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+  <input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+  <input type="hidden" name="client_id" value="{{.Request.ClientId}}">
+  <input type="hidden" name="redirect_uri" value="{{.Request.RedirectUri}}">
+  <input type="hidden" name="scope" value="{{.Request.Scope}}">
+  <input type="hidden" name="state" value="{{.Request.State}}">
+  <input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+  <input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+  <input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+  <label>User name <input type="text" name="username" autocomplete="username"></label>
+  <label>Password <input type="password" name="password" autocomplete="current-password"></label>
+  <label>One-time code <input type="text" name="otp" autocomplete="one-time-code" inputmode="numeric"></label>
+  <button type="submit" name="consent" value="approve">Allow</button>
+  <button type="submit" name="consent" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIpContextKey struct{}
+
+// ClientIpMiddleware works out the address of the client once per request, X-Forwarded-For is only
+// honoured when the peer is a trusted proxy as anyone else can send the header to dodge the lockout and
+// rate limits. It has to run before the rate limiter
+type ClientIpMiddleware struct {
+	trustedProxies []*net.IPNet
+}
+
+func (middleware *ClientIpMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		address := middleware.resolve(peerIp(request), request.Header.Values("X-Forwarded-For"))
+		next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), clientIpContextKey{}, address)))
+	})
+}
+
+// resolve walks X-Forwarded-For from the right, each trusted proxy vouches for the address to its left,
+// the first address that is not a trusted proxy is the client
+func (middleware *ClientIpMiddleware) resolve(peer string, forwardedFor []string) string {
+	if !middleware.isTrusted(peer) {
+		return peer
+	}
+	hops := make([]string, 0)
+	for _, header := range forwardedFor {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	address := peer
+	for index := len(hops) - 1; index >= 0; index-- {
+		hop := strings.TrimSpace(hops[index])
+		if net.ParseIP(hop) == nil {
+			// a malformed hop was not added by a trusted proxy, the last proxy is as far as we can trust
+			return address
+		}
+		address = hop
+		if !middleware.isTrusted(address) {
+			return address
+		}
+	}
+	return address
+}
+
+func (middleware *ClientIpMiddleware) isTrusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range middleware.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIp is the address ClientIpMiddleware resolved, the connecting peer when the middleware did not run
+func clientIp(request *http.Request) string {
+	if address, ok := request.Context().Value(clientIpContextKey{}).(string); ok {
+		return address
+	}
+	return peerIp(request)
+}
+
+// peerIp is the address of the connecting peer, without the port
+func peerIp(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// ParseTrustedProxies accepts addresses and CIDR ranges such as "10.0.0.1" or "10.0.0.0/8"
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s", proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func NewClientIpMiddleware(trustedProxies []*net.IPNet) ClientIpMiddleware {
+	return ClientIpMiddleware{trustedProxies: trustedProxies}
+}
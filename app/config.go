@@ -0,0 +1,525 @@
+package app
+
+import (
+	"banking-auth/domain"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is everything the service can be configured with. Settings are layered, each layer overriding
+// the one before: the defaults, the yaml file named by -config or CONFIG_FILE, environment variables
+// and command line flags. Every setting has an environment variable, e.g. DB_HOST, the flag is the same
+// name in lower case with dashes, -db-host, and the yaml key is given next to it in registerSettings
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Database DatabaseConfig `yaml:"database"`
+	Tokens   TokenConfig    `yaml:"tokens"`
+	Signing  SigningConfig  `yaml:"signing"`
+	Roles    RoleConfig     `yaml:"roles"`
+	Password PasswordConfig `yaml:"password"`
+	Lockout  LockoutConfig  `yaml:"lockout"`
+	Mail     MailConfig     `yaml:"mail"`
+	Mfa      MfaConfig      `yaml:"mfa"`
+	// RateLimits overrides the default per route limits, see ParseRouteLimits
+	RateLimits              string `yaml:"rate_limits"`
+	SelfRegistrationEnabled bool   `yaml:"self_registration_enabled"`
+}
+
+type ServerConfig struct {
+	Host string `yaml:"host"`
+	Port string `yaml:"port"`
+	// Issuer is the public base url of the service, http://host:port when empty
+	Issuer            string        `yaml:"issuer"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	// ShutdownTimeout is how long requests in flight get to finish once a SIGTERM or SIGINT is received
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// TrustedProxies are the addresses or CIDR ranges of the load balancers allowed to set X-Forwarded-For
+	TrustedProxies stringList `yaml:"trusted_proxies"`
+}
+
+type StorageConfig struct {
+	// Backend is mysql, postgres, sqlite3 or memory, the database driver when empty
+	Backend        string `yaml:"backend"`
+	MemorySeedFile string `yaml:"memory_seed_file"`
+	MigrateOnStart bool   `yaml:"migrate_on_start"`
+}
+
+type DatabaseConfig struct {
+	Driver       string `yaml:"driver"`
+	Host         string `yaml:"host"`
+	Port         string `yaml:"port"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	Protocol     string `yaml:"protocol"`
+	// Name is the database, or the database file for sqlite
+	Name            string        `yaml:"name"`
+	SslMode         string        `yaml:"ssl_mode"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+type TokenConfig struct {
+	AccessTokenLifetime  time.Duration `yaml:"access_token_lifetime"`
+	RefreshTokenLifetime time.Duration `yaml:"refresh_token_lifetime"`
+}
+
+type SigningConfig struct {
+	// KeyFile is a PEM private key, tokens are signed with HS256 and Secret without one
+	KeyFile    string `yaml:"key_file"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+	KeyId      string `yaml:"key_id"`
+	Algorithm  string `yaml:"algorithm"`
+	// PreviousKeyFiles are kid=file entries, a file without a kid is known by its thumbprint
+	PreviousKeyFiles stringList `yaml:"previous_key_files"`
+	// PreviousSecrets are kid=secret entries of replaced HS256 secrets
+	PreviousSecrets    stringList    `yaml:"previous_secrets"`
+	NextKeyFile        string        `yaml:"next_key_file"`
+	NextKeyId          string        `yaml:"next_key_id"`
+	NextKeyActivatesAt string        `yaml:"next_key_activates_at"`
+	RotationInterval   time.Duration `yaml:"rotation_interval"`
+	// RotationGracePeriod is how long replaced keys keep verifying, the refresh token lifetime when 0
+	RotationGracePeriod time.Duration `yaml:"rotation_grace_period"`
+}
+
+type RoleConfig struct {
+	// RefreshInterval is how often the roles are reloaded from the database, 0 disables the refresh
+	RefreshInterval  time.Duration `yaml:"refresh_interval"`
+	AccessPolicyFile string        `yaml:"access_policy_file"`
+}
+
+type PasswordConfig struct {
+	HashAlgorithm string        `yaml:"hash_algorithm"`
+	MinLength     int           `yaml:"min_length"`
+	MaxLength     int           `yaml:"max_length"`
+	RequireUpper  bool          `yaml:"require_upper"`
+	RequireLower  bool          `yaml:"require_lower"`
+	RequireDigit  bool          `yaml:"require_digit"`
+	RequireSymbol bool          `yaml:"require_symbol"`
+	MaxAge        time.Duration `yaml:"max_age"`
+	HistorySize   int           `yaml:"history_size"`
+	BreachedFile  string        `yaml:"breached_passwords_file"`
+	ResetUrl      string        `yaml:"reset_url"`
+}
+
+type LockoutConfig struct {
+	MaxUserFailures int           `yaml:"max_user_failures"`
+	MaxIpFailures   int           `yaml:"max_ip_failures"`
+	Duration        time.Duration `yaml:"duration"`
+	BaseDelay       time.Duration `yaml:"base_delay"`
+	MaxDelay        time.Duration `yaml:"max_delay"`
+}
+
+type MailConfig struct {
+	// SmtpHost enables sending mail, without it mails are only logged
+	SmtpHost         string `yaml:"smtp_host"`
+	SmtpPort         string `yaml:"smtp_port"`
+	SmtpUser         string `yaml:"smtp_user"`
+	SmtpPassword     string `yaml:"smtp_password"`
+	SmtpPasswordFile string `yaml:"smtp_password_file"`
+	From             string `yaml:"from"`
+}
+
+type MfaConfig struct {
+	// Required makes every user enroll a second factor before they are given tokens
+	Required       bool   `yaml:"required"`
+	TotpIssuer     string `yaml:"totp_issuer"`
+	WebAuthnRpId   string `yaml:"webauthn_rp_id"`
+	WebAuthnRpName string `yaml:"webauthn_rp_name"`
+	// WebAuthnOrigins are the origins allowed to run WebAuthn ceremonies, the issuer when empty
+	WebAuthnOrigins stringList `yaml:"webauthn_origins"`
+}
+
+// stringList is a list setting, given as a yaml sequence or as a comma separated env variable or flag
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	items := make(stringList, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*list = items
+	return nil
+}
+
+// configSettings registers each setting as a flag and remembers its yaml key for error messages
+type configSettings struct {
+	flags     *flag.FlagSet
+	yamlPaths map[string]string
+}
+
+func (settings configSettings) register(env string, yamlPath string) string {
+	settings.yamlPaths[env] = yamlPath
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+func (settings configSettings) stringVar(field *string, env string, yamlPath string, defaultValue string, usage string) {
+	settings.flags.StringVar(field, settings.register(env, yamlPath), defaultValue, usage)
+}
+
+func (settings configSettings) intVar(field *int, env string, yamlPath string, defaultValue int, usage string) {
+	settings.flags.IntVar(field, settings.register(env, yamlPath), defaultValue, usage)
+}
+
+func (settings configSettings) boolVar(field *bool, env string, yamlPath string, defaultValue bool, usage string) {
+	settings.flags.BoolVar(field, settings.register(env, yamlPath), defaultValue, usage)
+}
+
+func (settings configSettings) durationVar(field *time.Duration, env string, yamlPath string, defaultValue time.Duration, usage string) {
+	settings.flags.DurationVar(field, settings.register(env, yamlPath), defaultValue, usage)
+}
+
+func (settings configSettings) listVar(field *stringList, env string, yamlPath string, usage string) {
+	settings.flags.Var(field, settings.register(env, yamlPath), usage)
+}
+
+// describe names a setting the way operators may have set it
+func (settings configSettings) describe(env string) string {
+	return env + " (" + settings.yamlPaths[env] + ")"
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// registerSettings binds every setting to the config and sets the defaults
+func (config *Config) registerSettings(flags *flag.FlagSet) configSettings {
+	settings := configSettings{flags: flags, yamlPaths: make(map[string]string)}
+	lockout := domain.NewLockoutPolicy()
+	password := domain.NewPasswordPolicy()
+
+	settings.stringVar(&config.Server.Host, "SERVER_HOST", "server.host", "", "host to listen on")
+	settings.stringVar(&config.Server.Port, "SERVER_PORT", "server.port", "", "port to listen on")
+	settings.stringVar(&config.Server.Issuer, "ISSUER", "server.issuer", "", "public base url of the service")
+	settings.durationVar(&config.Server.ReadTimeout, "SERVER_READ_TIMEOUT", "server.read_timeout", time.Second*10, "longest time to read a request, body included")
+	settings.durationVar(&config.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT", "server.read_header_timeout", time.Second*5, "longest time to read the request headers")
+	settings.durationVar(&config.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT", "server.write_timeout", time.Second*30, "longest time to handle a request and write the response")
+	settings.durationVar(&config.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT", "server.idle_timeout", time.Minute*2, "how long idle keep-alive connections are kept open")
+	settings.intVar(&config.Server.MaxHeaderBytes, "SERVER_MAX_HEADER_BYTES", "server.max_header_bytes", 1<<16, "largest request headers accepted")
+	settings.durationVar(&config.Server.ShutdownTimeout, "SERVER_SHUTDOWN_TIMEOUT", "server.shutdown_timeout", time.Second*30, "how long requests in flight get to finish on shutdown")
+	settings.listVar(&config.Server.TrustedProxies, "TRUSTED_PROXIES", "server.trusted_proxies", "proxies allowed to set X-Forwarded-For")
+
+	settings.stringVar(&config.Storage.Backend, "STORAGE_BACKEND", "storage.backend", "", "mysql, postgres, sqlite3 or memory")
+	settings.stringVar(&config.Storage.MemorySeedFile, "MEMORY_SEED_FILE", "storage.memory_seed_file", "", "json users and clients for the memory backend")
+	settings.boolVar(&config.Storage.MigrateOnStart, "MIGRATE_ON_START", "storage.migrate_on_start", false, "apply pending migrations at startup")
+
+	settings.stringVar(&config.Database.Driver, "DB_DRIVER_NAME", "database.driver", "", "database driver")
+	settings.stringVar(&config.Database.Host, "DB_HOST", "database.host", "", "database host")
+	settings.stringVar(&config.Database.Port, "DB_PORT", "database.port", "", "database port")
+	settings.stringVar(&config.Database.User, "DB_USER", "database.user", "", "database user")
+	settings.stringVar(&config.Database.Password, "DB_PASSWD", "database.password", "", "database password")
+	settings.stringVar(&config.Database.PasswordFile, "DB_PASSWD_FILE", "database.password_file", "", "file holding the database password")
+	settings.stringVar(&config.Database.Protocol, "DB_PROTOCOL", "database.protocol", "tcp", "mysql connection protocol")
+	settings.stringVar(&config.Database.Name, "DB_NAME", "database.name", "", "database name, the database file for sqlite")
+	settings.stringVar(&config.Database.SslMode, "DB_SSL_MODE", "database.ssl_mode", "", "postgres sslmode")
+	settings.intVar(&config.Database.MaxOpenConns, "DB_MAX_OPEN_CONNS", "database.max_open_conns", 10, "connection pool size")
+	settings.intVar(&config.Database.MaxIdleConns, "DB_MAX_IDLE_CONNS", "database.max_idle_conns", 10, "idle connections kept in the pool")
+	settings.durationVar(&config.Database.ConnMaxLifetime, "DB_CONN_MAX_LIFETIME", "database.conn_max_lifetime", time.Minute*3, "how long a connection is reused")
+
+	settings.durationVar(&config.Tokens.AccessTokenLifetime, "ACCESS_TOKEN_LIFETIME", "tokens.access_token_lifetime", domain.DEFAULT_ACCESS_TOKEN_LIFETIME, "access token lifetime")
+	settings.durationVar(&config.Tokens.RefreshTokenLifetime, "REFRESH_TOKEN_LIFETIME", "tokens.refresh_token_lifetime", domain.DEFAULT_REFRESH_TOKEN_LIFETIME, "refresh token lifetime")
+
+	settings.stringVar(&config.Signing.KeyFile, "SIGNING_KEY_FILE", "signing.key_file", "", "PEM private key tokens are signed with")
+	settings.stringVar(&config.Signing.Secret, "SIGNING_SECRET", "signing.secret", "", "HS256 secret tokens are signed with when there is no key file")
+	settings.stringVar(&config.Signing.SecretFile, "SIGNING_SECRET_FILE", "signing.secret_file", "", "file holding the HS256 signing secret")
+	settings.stringVar(&config.Signing.KeyId, "SIGNING_KEY_ID", "signing.key_id", "", "kid of the signing key")
+	settings.stringVar(&config.Signing.Algorithm, "SIGNING_KEY_ALGORITHM", "signing.algorithm", "", "signing algorithm, RS256 with a key file and HS256 without")
+	settings.listVar(&config.Signing.PreviousKeyFiles, "PREVIOUS_SIGNING_KEY_FILES", "signing.previous_key_files", "replaced keys that still verify tokens, as kid=file")
+	settings.listVar(&config.Signing.PreviousSecrets, "PREVIOUS_SIGNING_SECRETS", "signing.previous_secrets", "replaced HS256 secrets that still verify tokens, as kid=secret")
+	settings.stringVar(&config.Signing.NextKeyFile, "NEXT_SIGNING_KEY_FILE", "signing.next_key_file", "", "key scheduled to take over signing")
+	settings.stringVar(&config.Signing.NextKeyId, "NEXT_SIGNING_KEY_ID", "signing.next_key_id", "", "kid of the next signing key")
+	settings.stringVar(&config.Signing.NextKeyActivatesAt, "NEXT_SIGNING_KEY_ACTIVATES_AT", "signing.next_key_activates_at", "", "RFC3339 time the next key takes over")
+	settings.durationVar(&config.Signing.RotationInterval, "KEY_ROTATION_INTERVAL", "signing.rotation_interval", 0, "generate a new signing key every interval, 0 disables")
+	settings.durationVar(&config.Signing.RotationGracePeriod, "KEY_ROTATION_GRACE_PERIOD", "signing.rotation_grace_period", 0, "how long replaced keys verify, the refresh token lifetime when 0")
+
+	settings.durationVar(&config.Roles.RefreshInterval, "ROLE_REFRESH_INTERVAL", "roles.refresh_interval", time.Minute, "how often roles are reloaded, 0 disables")
+	settings.stringVar(&config.Roles.AccessPolicyFile, "ACCESS_POLICY_FILE", "roles.access_policy_file", "", "json attribute based access policies")
+
+	settings.stringVar(&config.Password.HashAlgorithm, "PASSWORD_HASH_ALGORITHM", "password.hash_algorithm", "", "argon2id or bcrypt")
+	settings.intVar(&config.Password.MinLength, "PASSWORD_MIN_LENGTH", "password.min_length", password.MinLength, "shortest password allowed")
+	settings.intVar(&config.Password.MaxLength, "PASSWORD_MAX_LENGTH", "password.max_length", password.MaxLength, "longest password allowed")
+	settings.boolVar(&config.Password.RequireUpper, "PASSWORD_REQUIRE_UPPER", "password.require_upper", password.RequireUpper, "require an upper case letter")
+	settings.boolVar(&config.Password.RequireLower, "PASSWORD_REQUIRE_LOWER", "password.require_lower", password.RequireLower, "require a lower case letter")
+	settings.boolVar(&config.Password.RequireDigit, "PASSWORD_REQUIRE_DIGIT", "password.require_digit", password.RequireDigit, "require a digit")
+	settings.boolVar(&config.Password.RequireSymbol, "PASSWORD_REQUIRE_SYMBOL", "password.require_symbol", password.RequireSymbol, "require a symbol")
+	settings.durationVar(&config.Password.MaxAge, "PASSWORD_MAX_AGE", "password.max_age", password.MaxAge, "age at which passwords must be changed, 0 never")
+	settings.intVar(&config.Password.HistorySize, "PASSWORD_HISTORY_SIZE", "password.history_size", password.HistorySize, "previous passwords that can't be reused")
+	settings.stringVar(&config.Password.BreachedFile, "BREACHED_PASSWORDS_FILE", "password.breached_passwords_file", "", "sorted SHA-1 hash list of breached passwords")
+	settings.stringVar(&config.Password.ResetUrl, "PASSWORD_RESET_URL", "password.reset_url", "", "page password reset emails link to")
+
+	settings.intVar(&config.Lockout.MaxUserFailures, "LOCKOUT_MAX_USER_FAILURES", "lockout.max_user_failures", lockout.MaxUserFailures, "failed logins before a user is locked")
+	settings.intVar(&config.Lockout.MaxIpFailures, "LOCKOUT_MAX_IP_FAILURES", "lockout.max_ip_failures", lockout.MaxIpFailures, "failed logins before an ip is locked")
+	settings.durationVar(&config.Lockout.Duration, "LOCKOUT_DURATION", "lockout.duration", lockout.LockoutDuration, "how long a lock lasts")
+	settings.durationVar(&config.Lockout.BaseDelay, "LOCKOUT_BASE_DELAY", "lockout.base_delay", lockout.BaseDelay, "delay after the first failure")
+	settings.durationVar(&config.Lockout.MaxDelay, "LOCKOUT_MAX_DELAY", "lockout.max_delay", lockout.MaxDelay, "longest delay after a failure")
+
+	settings.stringVar(&config.Mail.SmtpHost, "SMTP_HOST", "mail.smtp_host", "", "smtp server, mails are only logged without one")
+	settings.stringVar(&config.Mail.SmtpPort, "SMTP_PORT", "mail.smtp_port", "587", "smtp port")
+	settings.stringVar(&config.Mail.SmtpUser, "SMTP_USER", "mail.smtp_user", "", "smtp user")
+	settings.stringVar(&config.Mail.SmtpPassword, "SMTP_PASSWORD", "mail.smtp_password", "", "smtp password")
+	settings.stringVar(&config.Mail.SmtpPasswordFile, "SMTP_PASSWORD_FILE", "mail.smtp_password_file", "", "file holding the smtp password")
+	settings.stringVar(&config.Mail.From, "MAIL_FROM", "mail.from", "", "sender of mails")
+
+	settings.boolVar(&config.Mfa.Required, "MFA_REQUIRED", "mfa.required", false, "users must enroll a second factor before they are given tokens")
+	settings.stringVar(&config.Mfa.TotpIssuer, "TOTP_ISSUER", "mfa.totp_issuer", "banking-auth", "issuer shown by authenticator apps")
+	settings.stringVar(&config.Mfa.WebAuthnRpId, "WEBAUTHN_RP_ID", "mfa.webauthn_rp_id", "", "domain passkeys are bound to, the server host when empty")
+	settings.stringVar(&config.Mfa.WebAuthnRpName, "WEBAUTHN_RP_NAME", "mfa.webauthn_rp_name", "banking-auth", "name shown for passkeys")
+	settings.listVar(&config.Mfa.WebAuthnOrigins, "WEBAUTHN_ORIGINS", "mfa.webauthn_origins", "origins allowed to run WebAuthn ceremonies")
+
+	settings.stringVar(&config.RateLimits, "RATE_LIMITS", "rate_limits", "", "per route rate limit overrides")
+	settings.boolVar(&config.SelfRegistrationEnabled, "SELF_REGISTRATION_ENABLED", "self_registration_enabled", false, "allow customers to register")
+	return settings
+}
+
+// LoadConfig builds the config from the layers described on Config, args are the command line
+// arguments without the program name and the arguments left after the flags are returned
+func LoadConfig(name string, args []string) (*Config, []string, error) {
+	// the flags are parsed first to find the config file, they are applied again once the file
+	// and the environment have been read so they take precedence
+	commandLine := flag.NewFlagSet(name, flag.ContinueOnError)
+	(&Config{}).registerSettings(commandLine)
+	configFile := commandLine.String("config", os.Getenv("CONFIG_FILE"), "yaml config file")
+	if err := commandLine.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	config := &Config{}
+	settings := config.registerSettings(flag.NewFlagSet(name, flag.ContinueOnError))
+	if *configFile != "" {
+		if err := config.readFile(*configFile); err != nil {
+			return nil, nil, fmt.Errorf("config file %s : %s", *configFile, err.Error())
+		}
+	}
+	var errs []string
+	settings.flags.VisitAll(func(f *flag.Flag) {
+		if value := os.Getenv(envName(f.Name)); value != "" {
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, settings.describe(envName(f.Name))+" is invalid, got "+value)
+			}
+		}
+	})
+	commandLine.Visit(func(f *flag.Flag) {
+		_ = settings.flags.Set(f.Name, f.Value.String())
+	})
+	errs = append(errs, config.readSecrets(settings)...)
+	errs = append(errs, config.validate(settings)...)
+	if len(errs) > 0 {
+		return nil, nil, errors.New("invalid configuration :\n  " + strings.Join(errs, "\n  "))
+	}
+	return config, commandLine.Args(), nil
+}
+
+// readFile reads the yaml config file, unknown keys are rejected so typos don't go unnoticed
+func (config *Config) readFile(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(config); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// readSecrets loads the secrets given as files, e.g. mounted docker or kubernetes secrets
+func (config *Config) readSecrets(settings configSettings) []string {
+	errs := make([]string, 0)
+	secrets := []struct {
+		value *string
+		file  string
+		env   string
+	}{
+		{&config.Database.Password, config.Database.PasswordFile, "DB_PASSWD"},
+		{&config.Mail.SmtpPassword, config.Mail.SmtpPasswordFile, "SMTP_PASSWORD"},
+		{&config.Signing.Secret, config.Signing.SecretFile, "SIGNING_SECRET"},
+	}
+	for _, secret := range secrets {
+		if secret.file == "" {
+			continue
+		}
+		if *secret.value != "" {
+			errs = append(errs, "set only one of "+settings.describe(secret.env)+" and "+settings.describe(secret.env+"_FILE"))
+			continue
+		}
+		content, err := ioutil.ReadFile(secret.file)
+		if err != nil {
+			errs = append(errs, settings.describe(secret.env+"_FILE")+" can't be read : "+err.Error())
+			continue
+		}
+		*secret.value = strings.TrimRight(string(content), "\r\n")
+	}
+	return errs
+}
+
+// StorageBackend is the configured backend, the database driver when no backend is set
+func (config *Config) StorageBackend() string {
+	backend := config.Storage.Backend
+	if backend == "" {
+		backend = config.Database.Driver
+	}
+	if backend == "sqlite" {
+		return string(domain.DIALECT_SQLITE)
+	}
+	return backend
+}
+
+// Issuer is the public base url of the service used as the iss claim and in the discovery document
+func (config *Config) Issuer() string {
+	if config.Server.Issuer != "" {
+		return strings.TrimSuffix(config.Server.Issuer, "/")
+	}
+	return fmt.Sprintf("http://%s:%s", config.Server.Host, config.Server.Port)
+}
+
+// validate checks each setting on its own and the settings that depend on each other
+func (config *Config) validate(settings configSettings) []string {
+	errs := make([]string, 0)
+	required := func(value string, env string, reason string) {
+		if value == "" {
+			errs = append(errs, settings.describe(env)+" is required"+reason)
+		}
+	}
+	atLeast := func(value int, minimum int, env string) {
+		if value < minimum {
+			errs = append(errs, fmt.Sprintf("%s must be at least %d", settings.describe(env), minimum))
+		}
+	}
+	notNegative := func(value time.Duration, env string) {
+		if value < 0 {
+			errs = append(errs, settings.describe(env)+" must not be negative")
+		}
+	}
+	isPort := func(value string, env string) {
+		if port, err := strconv.Atoi(value); value != "" && (err != nil || port < 1 || port > 65535) {
+			errs = append(errs, settings.describe(env)+" must be a port number, got "+value)
+		}
+	}
+
+	required(config.Server.Host, "SERVER_HOST", "")
+	required(config.Server.Port, "SERVER_PORT", "")
+	isPort(config.Server.Port, "SERVER_PORT")
+	notNegative(config.Server.ReadTimeout, "SERVER_READ_TIMEOUT")
+	notNegative(config.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT")
+	notNegative(config.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT")
+	notNegative(config.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT")
+	atLeast(config.Server.MaxHeaderBytes, 1024, "SERVER_MAX_HEADER_BYTES")
+	if config.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, settings.describe("SERVER_SHUTDOWN_TIMEOUT")+" must be positive")
+	}
+	if _, err := ParseTrustedProxies(config.Server.TrustedProxies); err != nil {
+		errs = append(errs, settings.describe("TRUSTED_PROXIES")+" is invalid : "+err.Error())
+	}
+
+	backend := config.StorageBackend()
+	databaseReason := " for the " + backend + " storage backend"
+	switch domain.Dialect(backend) {
+	case domain.DIALECT_MYSQL, domain.DIALECT_POSTGRES:
+		required(config.Database.Host, "DB_HOST", databaseReason)
+		required(config.Database.Port, "DB_PORT", databaseReason)
+		isPort(config.Database.Port, "DB_PORT")
+		required(config.Database.User, "DB_USER", databaseReason)
+		required(config.Database.Password, "DB_PASSWD", databaseReason)
+		required(config.Database.Name, "DB_NAME", databaseReason)
+		if backend == string(domain.DIALECT_MYSQL) {
+			required(config.Database.Protocol, "DB_PROTOCOL", databaseReason)
+		}
+	case domain.DIALECT_SQLITE:
+		required(config.Database.Name, "DB_NAME", databaseReason)
+	case domain.Dialect(STORAGE_BACKEND_MEMORY):
+	case "":
+		errs = append(errs, settings.describe("STORAGE_BACKEND")+" or "+settings.describe("DB_DRIVER_NAME")+" is required")
+	default:
+		errs = append(errs, settings.describe("STORAGE_BACKEND")+" must be one of mysql, postgres, sqlite3 or memory, got "+backend)
+	}
+	atLeast(config.Database.MaxOpenConns, 1, "DB_MAX_OPEN_CONNS")
+	atLeast(config.Database.MaxIdleConns, 0, "DB_MAX_IDLE_CONNS")
+	notNegative(config.Database.ConnMaxLifetime, "DB_CONN_MAX_LIFETIME")
+
+	if config.Tokens.AccessTokenLifetime <= 0 {
+		errs = append(errs, settings.describe("ACCESS_TOKEN_LIFETIME")+" must be positive")
+	}
+	if config.Tokens.RefreshTokenLifetime <= config.Tokens.AccessTokenLifetime {
+		errs = append(errs, settings.describe("REFRESH_TOKEN_LIFETIME")+" must be longer than "+
+			settings.describe("ACCESS_TOKEN_LIFETIME"))
+	}
+
+	if config.Signing.KeyFile == "" {
+		required(config.Signing.Secret, "SIGNING_SECRET", " when "+settings.describe("SIGNING_KEY_FILE")+" is not set")
+		// HS256 keys shorter than the hash are easy to brute force from a single token
+		if config.Signing.Secret != "" && len(config.Signing.Secret) < 32 {
+			errs = append(errs, settings.describe("SIGNING_SECRET")+" must be at least 32 bytes")
+		}
+	}
+	for _, entry := range config.Signing.PreviousSecrets {
+		keyId, secret, found := strings.Cut(entry, "=")
+		if !found || keyId == "" || len(secret) < 32 {
+			errs = append(errs, settings.describe("PREVIOUS_SIGNING_SECRETS")+
+				" entries must be kid=secret with secrets of at least 32 bytes")
+			break
+		}
+		if config.Signing.KeyFile == "" && keyId == getHmacKeyId(config) {
+			errs = append(errs, settings.describe("PREVIOUS_SIGNING_SECRETS")+" must not reuse the kid of "+
+				settings.describe("SIGNING_SECRET")+", give the new secret its own "+settings.describe("SIGNING_KEY_ID"))
+			break
+		}
+	}
+	if config.Signing.NextKeyFile != "" {
+		if _, err := time.Parse(time.RFC3339, config.Signing.NextKeyActivatesAt); err != nil {
+			errs = append(errs, settings.describe("NEXT_SIGNING_KEY_ACTIVATES_AT")+" must be an RFC3339 time when "+
+				settings.describe("NEXT_SIGNING_KEY_FILE")+" is set")
+		}
+	}
+	notNegative(config.Signing.RotationInterval, "KEY_ROTATION_INTERVAL")
+	notNegative(config.Signing.RotationGracePeriod, "KEY_ROTATION_GRACE_PERIOD")
+	notNegative(config.Roles.RefreshInterval, "ROLE_REFRESH_INTERVAL")
+
+	if _, err := domain.NewPasswordHasher(config.Password.HashAlgorithm); err != nil {
+		errs = append(errs, settings.describe("PASSWORD_HASH_ALGORITHM")+" must be argon2id or bcrypt, got "+
+			config.Password.HashAlgorithm)
+	}
+	atLeast(config.Password.MinLength, 1, "PASSWORD_MIN_LENGTH")
+	atLeast(config.Password.MaxLength, config.Password.MinLength, "PASSWORD_MAX_LENGTH")
+	atLeast(config.Password.HistorySize, 0, "PASSWORD_HISTORY_SIZE")
+	notNegative(config.Password.MaxAge, "PASSWORD_MAX_AGE")
+
+	atLeast(config.Lockout.MaxUserFailures, 1, "LOCKOUT_MAX_USER_FAILURES")
+	atLeast(config.Lockout.MaxIpFailures, 1, "LOCKOUT_MAX_IP_FAILURES")
+	notNegative(config.Lockout.Duration, "LOCKOUT_DURATION")
+	notNegative(config.Lockout.BaseDelay, "LOCKOUT_BASE_DELAY")
+	if config.Lockout.MaxDelay < config.Lockout.BaseDelay {
+		errs = append(errs, settings.describe("LOCKOUT_MAX_DELAY")+" must not be shorter than "+settings.describe("LOCKOUT_BASE_DELAY"))
+	}
+
+	if config.Mail.SmtpHost != "" {
+		isPort(config.Mail.SmtpPort, "SMTP_PORT")
+		required(config.Mail.From, "MAIL_FROM", " when "+settings.describe("SMTP_HOST")+" is set")
+	}
+	if err := ParseRouteLimits(DefaultRouteLimits(), config.RateLimits); err != nil {
+		errs = append(errs, settings.describe("RATE_LIMITS")+" is invalid : "+err.Error())
+	}
+	return errs
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"banking-auth/domain"
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const hashSecretUsage = "usage : banking-auth hash-secret [argon2id | bcrypt] < secret"
+
+// HashSecret runs the hash-secret subcommand, it reads a client secret from stdin and prints the hash
+// to store in oauth_clients.client_secret, client secrets are never accepted in plain text
+func HashSecret(args []string) {
+	algorithm := ""
+	if len(args) > 0 {
+		algorithm = args[0]
+	}
+	hasher, err := domain.NewPasswordHasher(algorithm)
+	if err != nil {
+		log.Fatal(hashSecretUsage)
+	}
+	secret, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	secret = strings.TrimRight(secret, "\r\n")
+	if secret == "" {
+		log.Fatal(hashSecretUsage)
+	}
+	hash, err := hasher.Hash(secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(hash)
+}
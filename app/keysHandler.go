@@ -0,0 +1,29 @@
+package app
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"github.com/barnettt/banking-lib/logger"
+	"net/http"
+)
+
+type KeysHandler struct {
+	keyRing *domain.KeyRing
+}
+
+// GetJwks publishes the public verification keys so downstream services can validate tokens locally
+func (keysHandler *KeysHandler) GetJwks(writer http.ResponseWriter, request *http.Request) {
+	jwks := dto.JwkSet{Keys: []dto.Jwk{}}
+	for _, key := range keysHandler.keyRing.VerificationKeys() {
+		if !key.IsAsymmetric() {
+			continue
+		}
+		jwk, err := key.Jwk()
+		if err != nil {
+			logger.Error("Unable to publish key " + key.KeyId + " : " + err.Error())
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+	writeResponse(writer, http.StatusOK, jwks, contentTypeJson)
+}
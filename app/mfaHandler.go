@@ -0,0 +1,66 @@
+package app
+
+import (
+	"banking-auth/dto"
+	"banking-auth/service"
+	"github.com/barnettt/banking-lib/exceptions"
+	"io"
+	"net/http"
+)
+
+type MfaHandler struct {
+	mfaService service.DefaultMfaService
+}
+
+func (mfaHandler *MfaHandler) EnrollTotp(writer http.ResponseWriter, request *http.Request) {
+	// the body is only needed to replace a confirmed enrollment
+	var enrollRequest dto.MfaEnrollRequest
+	if err := decodeRequest(request, false, &enrollRequest); err != nil && err != io.EOF {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	enrollRequest.ClientIp = clientIp(request)
+	response, appErr := mfaHandler.mfaService.EnrollTotp(bearerToken(request), enrollRequest)
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+func (mfaHandler *MfaHandler) ConfirmTotp(writer http.ResponseWriter, request *http.Request) {
+	var codeRequest dto.MfaCodeRequest
+	if err := decodeRequest(request, false, &codeRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	response, appErr := mfaHandler.mfaService.ConfirmTotp(bearerToken(request), codeRequest)
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+func (mfaHandler *MfaHandler) VerifyMfaLogin(writer http.ResponseWriter, request *http.Request) {
+	contType := request.Header.Get("Content-Type")
+	var contentType bool
+	if contType == contentTypeXml {
+		contentType = true
+	}
+
+	var mfaRequest dto.MfaLoginRequest
+	if err := decodeRequest(request, contentType, &mfaRequest); err != nil {
+		returnResponse(writer, exceptions.NewPayloadParseError(err.Error()), contentType, dto.LoginResponse{})
+		return
+	}
+	mfaRequest.ClientIp = clientIp(request)
+	response, appErr := mfaHandler.mfaService.VerifyMfaLogin(mfaRequest)
+	if appErr != nil {
+		returnResponse(writer, appErr, contentType, dto.LoginResponse{})
+		return
+	}
+	returnResponse(writer, nil, contentType, *response)
+}
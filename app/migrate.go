@@ -0,0 +1,133 @@
+package app
+
+import (
+	"banking-auth/domain"
+	"flag"
+	"fmt"
+	"github.com/barnettt/banking-lib/logger"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = "usage : banking-auth migrate [flags] [up | down [steps] | status]"
+
+// Migrate runs the migrate subcommand against the database configured for the service
+//
+//	up              applies every pending migration, the default
+//	down [steps]    rolls back the last steps migrations, 1 by default
+//	status          lists the migrations and whether they have been applied
+func Migrate(args []string) {
+	config, args, err := LoadConfig(os.Args[0]+" migrate", args)
+	if err == flag.ErrHelp {
+		fmt.Println(migrateUsage)
+		return
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		log.Fatal(err)
+	}
+	if config.StorageBackend() == STORAGE_BACKEND_MEMORY {
+		log.Fatal("the memory storage backend has no schema to migrate")
+	}
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	migrator := getMigrator(domain.NewDatabase(getDbClient(config)))
+	switch command {
+	case "up":
+		applied, err := migrator.Up()
+		printMigrations("applied", applied)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatal(migrateUsage)
+			}
+		}
+		rolledBack, err := migrator.Down(steps)
+		printMigrations("rolled back", rolledBack)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		printMigrationStatus(migrator)
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
+
+func printMigrations(action string, migrations []domain.Migration) {
+	for _, migration := range migrations {
+		fmt.Printf("%s %04d %s\n", action, migration.Version, migration.Name)
+	}
+}
+
+func printMigrationStatus(migrator *domain.Migrator) {
+	applied, err := migrator.Applied()
+	if err != nil {
+		log.Fatal(err)
+	}
+	appliedAt := make(map[int64]int64, len(applied))
+	for _, row := range applied {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+	for _, migration := range migrator.Migrations() {
+		status := "pending"
+		if at, ok := appliedAt[migration.Version]; ok {
+			status = "applied " + time.Unix(at, 0).UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("%04d %-30s %s\n", migration.Version, migration.Name, status)
+	}
+	if err = migrator.Verify(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkSchema applies pending migrations at startup when MIGRATE_ON_START is true, otherwise the pending
+// migrations are logged and the service refuses to start until they are applied. Applied migrations that
+// don't match the binary stop the service too
+func checkSchema(config *Config, database *domain.Database) {
+	migrator := getMigrator(database)
+	if config.Storage.MigrateOnStart {
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			logger.Info(fmt.Sprintf("Applied migration %04d %s", migration.Version, migration.Name))
+		}
+		if err != nil {
+			logger.Error("Unable to migrate the schema : " + err.Error())
+			log.Fatal(err)
+		}
+		return
+	}
+	pending, err := migrator.Pending()
+	if err != nil {
+		logger.Error("Unable to check the schema : " + err.Error())
+		log.Fatal(err)
+	}
+	for _, migration := range pending {
+		logger.Error(fmt.Sprintf("Migration %04d %s has not been applied", migration.Version, migration.Name))
+	}
+	// the code expects the latest schema, running against an older one fails in confusing ways later
+	if len(pending) > 0 {
+		log.Fatalf("the schema is behind by %d migrations, run %s migrate up or set MIGRATE_ON_START", len(pending), os.Args[0])
+	}
+}
+
+func getMigrator(database *domain.Database) *domain.Migrator {
+	migrator, err := domain.NewMigrator(database)
+	if err != nil {
+		logger.Error("Unable to load migrations : " + err.Error())
+		log.Fatal(err)
+	}
+	return migrator
+}
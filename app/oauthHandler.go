@@ -0,0 +1,189 @@
+package app
+
+import (
+	"banking-auth/dto"
+	"banking-auth/service"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type OAuthHandler struct {
+	oauthService service.DefaultOAuthService
+}
+
+func (oauthHandler *OAuthHandler) Introspect(writer http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil {
+		writeOAuthError(writer, exceptions.NewValidationError(dto.OAUTH_INVALID_REQUEST))
+		return
+	}
+	clientId, clientSecret := clientCredentials(request)
+	response, appErr := oauthHandler.oauthService.Introspect(clientId, clientSecret, request.PostForm.Get("token"))
+	if appErr != nil {
+		writeOAuthError(writer, appErr)
+		return
+	}
+	writer.Header().Add("Cache-Control", "no-store")
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+// Token is the RFC 6749 token endpoint, grants are dispatched on grant_type
+func (oauthHandler *OAuthHandler) Token(writer http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil {
+		writeOAuthError(writer, exceptions.NewValidationError(dto.OAUTH_INVALID_REQUEST))
+		return
+	}
+	clientId, clientSecret := clientCredentials(request)
+	var response *dto.TokenResponse
+	var appErr *exceptions.AppError
+	switch request.PostForm.Get("grant_type") {
+	case dto.GRANT_TYPE_CLIENT_CREDENTIALS:
+		response, appErr = oauthHandler.oauthService.ClientCredentialsToken(clientId, clientSecret,
+			request.PostForm.Get("scope"))
+	case dto.GRANT_TYPE_AUTHORIZATION_CODE:
+		response, appErr = oauthHandler.oauthService.AuthorizationCodeToken(clientId, clientSecret,
+			request.PostForm.Get("code"), request.PostForm.Get("redirect_uri"), request.PostForm.Get("code_verifier"))
+	case dto.GRANT_TYPE_REFRESH_TOKEN:
+		response, appErr = oauthHandler.oauthService.RefreshTokenGrant(clientId, clientSecret,
+			request.PostForm.Get("refresh_token"), request.PostForm.Get("scope"))
+	default:
+		appErr = exceptions.NewValidationError(dto.OAUTH_UNSUPPORTED_GRANT_TYPE)
+	}
+	if appErr != nil {
+		writeOAuthError(writer, appErr)
+		return
+	}
+	writer.Header().Add("Cache-Control", "no-store")
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+type authorizePageData struct {
+	Request    dto.AuthorizeRequest
+	ClientName string
+	Scopes     []string
+	Error      string
+}
+
+// Authorize starts the authorization code flow, GET shows the login and consent form and
+// POST checks the credentials and redirects back to the client with the code
+func (oauthHandler *OAuthHandler) Authorize(writer http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil {
+		writeAuthorizePage(writer, http.StatusBadRequest, authorizePageData{Error: "Invalid authorization request"})
+		return
+	}
+	authorizeRequest := getAuthorizeRequest(request)
+	client, appErr := oauthHandler.oauthService.FindAuthorizeClient(authorizeRequest.ClientId, authorizeRequest.RedirectUri)
+	if appErr != nil {
+		// never redirect to a uri which has not been registered for the client
+		writeAuthorizePage(writer, http.StatusBadRequest, authorizePageData{Error: "Unknown client or redirect uri"})
+		return
+	}
+	if appErr = oauthHandler.oauthService.ValidateAuthorizeRequest(*client, authorizeRequest); appErr != nil {
+		redirectWithParams(writer, request, authorizeRequest.RedirectUri,
+			map[string]string{"error": appErr.Message, "state": authorizeRequest.State})
+		return
+	}
+	pageData := authorizePageData{Request: authorizeRequest, ClientName: client.Name,
+		Scopes: strings.Fields(authorizeRequest.Scope)}
+	if pageData.ClientName == "" {
+		pageData.ClientName = client.ClientId
+	}
+	if request.Method == http.MethodGet {
+		writeAuthorizePage(writer, http.StatusOK, pageData)
+		return
+	}
+	if request.PostForm.Get("consent") != "approve" {
+		redirectWithParams(writer, request, authorizeRequest.RedirectUri,
+			map[string]string{"error": dto.OAUTH_ACCESS_DENIED, "state": authorizeRequest.State})
+		return
+	}
+	code, appErr := oauthHandler.oauthService.Authorize(authorizeRequest, request.PostForm.Get("username"),
+		request.PostForm.Get("password"), request.PostForm.Get("otp"), clientIp(request))
+	if appErr != nil {
+		if appErr.Code == http.StatusInternalServerError {
+			redirectWithParams(writer, request, authorizeRequest.RedirectUri,
+				map[string]string{"error": dto.OAUTH_SERVER_ERROR, "state": authorizeRequest.State})
+			return
+		}
+		pageData.Error = "Invalid user name, password or one-time code"
+		writeAuthorizePage(writer, http.StatusUnauthorized, pageData)
+		return
+	}
+	redirectWithParams(writer, request, authorizeRequest.RedirectUri,
+		map[string]string{"code": code, "state": authorizeRequest.State})
+}
+
+func getAuthorizeRequest(request *http.Request) dto.AuthorizeRequest {
+	return dto.AuthorizeRequest{
+		ResponseType:        request.Form.Get("response_type"),
+		ClientId:            request.Form.Get("client_id"),
+		RedirectUri:         request.Form.Get("redirect_uri"),
+		Scope:               request.Form.Get("scope"),
+		State:               request.Form.Get("state"),
+		CodeChallenge:       request.Form.Get("code_challenge"),
+		CodeChallengeMethod: request.Form.Get("code_challenge_method"),
+		Nonce:               request.Form.Get("nonce"),
+	}
+}
+
+func writeAuthorizePage(writer http.ResponseWriter, code int, data authorizePageData) {
+	writer.Header().Add("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Add("Cache-Control", "no-store")
+	writer.Header().Add("X-Frame-Options", "DENY")
+	writer.WriteHeader(code)
+	if err := authorizePage.Execute(writer, data); err != nil {
+		logger.Error("Error rendering authorize page : " + err.Error())
+	}
+}
+
+// redirectWithParams adds the params to the redirect uri's query, empty values are left out
+func redirectWithParams(writer http.ResponseWriter, request *http.Request, redirectUri string, params map[string]string) {
+	location, err := url.Parse(redirectUri)
+	if err != nil {
+		writeAuthorizePage(writer, http.StatusBadRequest, authorizePageData{Error: "Invalid redirect uri"})
+		return
+	}
+	query := location.Query()
+	for k, v := range params {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	location.RawQuery = query.Encode()
+	http.Redirect(writer, request, location.String(), http.StatusFound)
+}
+
+func (oauthHandler *OAuthHandler) UserInfo(writer http.ResponseWriter, request *http.Request) {
+	userInfo, appErr := oauthHandler.oauthService.UserInfo(bearerToken(request))
+	if appErr != nil {
+		writer.Header().Add("WWW-Authenticate", `Bearer error="`+appErr.Message+`"`)
+		writeResponse(writer, appErr.Code, dto.OAuthError{Error: appErr.Message}, contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, userInfo, contentTypeJson)
+}
+
+func (oauthHandler *OAuthHandler) OpenIdConfiguration(writer http.ResponseWriter, request *http.Request) {
+	writeResponse(writer, http.StatusOK, oauthHandler.oauthService.OpenIdConfiguration(), contentTypeJson)
+}
+
+// clientCredentials supports both client_secret_basic and client_secret_post authentication
+func clientCredentials(request *http.Request) (string, string) {
+	if clientId, clientSecret, ok := request.BasicAuth(); ok {
+		return clientId, clientSecret
+	}
+	return request.PostForm.Get("client_id"), request.PostForm.Get("client_secret")
+}
+
+func writeOAuthError(writer http.ResponseWriter, appErr *exceptions.AppError) {
+	if appErr.Code == http.StatusUnauthorized {
+		writer.Header().Add("WWW-Authenticate", `Basic realm="banking-auth"`)
+	}
+	code := appErr.Code
+	if code == http.StatusUnprocessableEntity {
+		code = http.StatusBadRequest
+	}
+	writeResponse(writer, code, dto.OAuthError{Error: appErr.Message}, contentTypeJson)
+}
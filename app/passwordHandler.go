@@ -0,0 +1,86 @@
+package app
+
+import (
+	"banking-auth/dto"
+	"banking-auth/service"
+	"github.com/barnettt/banking-lib/exceptions"
+	"net/http"
+)
+
+type PasswordHandler struct {
+	passwordService service.DefaultPasswordService
+}
+
+func (passwordHandler *PasswordHandler) ChangePassword(writer http.ResponseWriter, request *http.Request) {
+	var changeRequest dto.ChangePasswordRequest
+	if err := decodeRequest(request, false, &changeRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	violations, appErr := passwordHandler.passwordService.ChangePassword(bearerToken(request), changeRequest, clientIp(request))
+	if len(violations) > 0 {
+		writePasswordPolicyError(writer, violations)
+		return
+	}
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (passwordHandler *PasswordHandler) ForgotPassword(writer http.ResponseWriter, request *http.Request) {
+	var forgotRequest dto.ForgotPasswordRequest
+	if err := decodeRequest(request, false, &forgotRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	if appErr := passwordHandler.passwordService.ForgotPassword(forgotRequest); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+func (passwordHandler *PasswordHandler) ResetPassword(writer http.ResponseWriter, request *http.Request) {
+	var resetRequest dto.ResetPasswordRequest
+	if err := decodeRequest(request, false, &resetRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	violations, appErr := passwordHandler.passwordService.ResetPassword(resetRequest)
+	if len(violations) > 0 {
+		writePasswordPolicyError(writer, violations)
+		return
+	}
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (passwordHandler *PasswordHandler) CheckPassword(writer http.ResponseWriter, request *http.Request) {
+	var checkRequest dto.PasswordCheckRequest
+	if err := decodeRequest(request, false, &checkRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	response, appErr := passwordHandler.passwordService.CheckPassword(bearerToken(request), checkRequest)
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+// writePasswordPolicyError refuses a password that breaks the policy with every broken rule, so front
+// ends can show them next to the field the same way as the password check endpoint
+func writePasswordPolicyError(writer http.ResponseWriter, violations []dto.PasswordRuleViolation) {
+	response := dto.PasswordPolicyErrorResponse{Message: "password does not meet the password policy", Violations: violations}
+	writeResponse(writer, http.StatusUnprocessableEntity, response, contentTypeJson)
+}
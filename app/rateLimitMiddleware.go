@@ -0,0 +1,224 @@
+package app
+
+import (
+	"banking-auth/domain"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"github.com/gorilla/mux"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RATE_LIMIT_BY_IP        string = "ip"
+	RATE_LIMIT_BY_USER_NAME string = "username"
+	RATE_LIMIT_BY_CLIENT_ID string = "client_id"
+	// routes without their own limits use the limits configured for this route
+	RATE_LIMIT_DEFAULT_ROUTE string = "*"
+)
+
+// at most this much of the body is buffered to find the user name, anything larger is not a login request
+const maxRateLimitBody int64 = 1 << 20
+
+// RouteLimits maps a mux path template to the limits applied per key type
+type RouteLimits map[string]map[string]domain.RateLimit
+
+// RateLimitMiddleware applies token bucket limits to every route, each limit of a route is
+// checked against its own bucket so a request must be within all of them
+type RateLimitMiddleware struct {
+	limiter     domain.RateLimiter
+	routeLimits RouteLimits
+}
+
+func (middleware *RateLimitMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		route := RATE_LIMIT_DEFAULT_ROUTE
+		if currentRoute := mux.CurrentRoute(request); currentRoute != nil {
+			if template, err := currentRoute.GetPathTemplate(); err == nil {
+				if _, ok := middleware.routeLimits[template]; ok {
+					route = template
+				}
+			}
+		}
+		limits := middleware.routeLimits[route]
+		keyTypes := make([]string, 0, len(limits))
+		for keyType := range limits {
+			keyTypes = append(keyTypes, keyType)
+		}
+		sort.Strings(keyTypes)
+		for _, keyType := range keyTypes {
+			key := rateLimitKey(request, keyType)
+			if key == "" {
+				continue
+			}
+			allowed, retryAfter, err := middleware.limiter.Allow(route+"|"+keyType+":"+key, limits[keyType])
+			if err != nil {
+				// fail open, an unavailable limiter store must not take the auth service down with it
+				logger.Error("Rate limiter error : " + err.Error())
+				continue
+			}
+			if !allowed {
+				logger.Info(fmt.Sprintf("Rate limit exceeded on %s for %s %s", route, keyType, key))
+				writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				appErr := exceptions.AppError{Code: http.StatusTooManyRequests, Message: "too many requests"}
+				writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+				return
+			}
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func rateLimitKey(request *http.Request, keyType string) string {
+	switch keyType {
+	case RATE_LIMIT_BY_IP:
+		return clientIp(request)
+	case RATE_LIMIT_BY_USER_NAME:
+		return requestUserName(request)
+	case RATE_LIMIT_BY_CLIENT_ID:
+		_ = request.ParseForm()
+		if clientId, _ := clientCredentials(request); clientId != "" {
+			return clientId
+		}
+		return request.Form.Get("client_id")
+	}
+	return ""
+}
+
+// requestUserName finds the user name in a login form or in a json or xml login body, the body is
+// put back so the handler can still decode it
+func requestUserName(request *http.Request) string {
+	if strings.HasPrefix(request.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		_ = request.ParseForm()
+		return request.PostForm.Get("username")
+	}
+	if request.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxRateLimitBody))
+	// the rest of a larger body follows what was read, the handler still sees all of it
+	request.Body = readCloser{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+	if err != nil {
+		return ""
+	}
+	var login struct {
+		UserName string
+	}
+	if request.Header.Get("Content-Type") == contentTypeXml {
+		_ = xml.Unmarshal(body, &login)
+	} else {
+		_ = json.Unmarshal(body, &login)
+	}
+	return login.UserName
+}
+
+// readCloser reads the replayed body but closes the original one
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// DefaultRouteLimits are generous for token verification, which every api call makes, and
+// tight for the routes that check passwords or one-time codes
+func DefaultRouteLimits() RouteLimits {
+	perMinute := func(requests int) domain.RateLimit {
+		return domain.RateLimit{Requests: requests, Period: time.Minute}
+	}
+	return RouteLimits{
+		RATE_LIMIT_DEFAULT_ROUTE: {RATE_LIMIT_BY_IP: perMinute(120)},
+		"/customers/login": {
+			RATE_LIMIT_BY_IP:        perMinute(30),
+			RATE_LIMIT_BY_USER_NAME: perMinute(10),
+		},
+		"/customers/password/change":       {RATE_LIMIT_BY_IP: perMinute(10)},
+		"/customers/password/check":        {RATE_LIMIT_BY_IP: perMinute(60)},
+		"/customers/password/forgot":       {RATE_LIMIT_BY_IP: perMinute(5)},
+		"/customers/password/reset":        {RATE_LIMIT_BY_IP: perMinute(10)},
+		"/customers/register":              {RATE_LIMIT_BY_IP: perMinute(10)},
+		"/customers/login/mfa":             {RATE_LIMIT_BY_IP: perMinute(30)},
+		"/customers/webauthn/login/begin":  {RATE_LIMIT_BY_IP: perMinute(30)},
+		"/customers/webauthn/login/finish": {RATE_LIMIT_BY_IP: perMinute(30)},
+		"/auth/verify":                     {RATE_LIMIT_BY_IP: perMinute(600)},
+		"/auth/refresh":                    {RATE_LIMIT_BY_IP: perMinute(60)},
+		"/oauth/authorize": {
+			RATE_LIMIT_BY_IP:        perMinute(60),
+			RATE_LIMIT_BY_USER_NAME: perMinute(10),
+		},
+		"/oauth/token": {
+			RATE_LIMIT_BY_IP:        perMinute(120),
+			RATE_LIMIT_BY_CLIENT_ID: perMinute(120),
+		},
+		"/oauth/introspect": {RATE_LIMIT_BY_CLIENT_ID: perMinute(600)},
+	}
+}
+
+// ParseRouteLimits applies overrides in the form "<route>:<key type>=<requests>/<period>" separated by
+// commas, e.g. "/auth/verify:ip=1000/1m,*:ip=200/1m". A limit of 0 requests removes the limit
+func ParseRouteLimits(routeLimits RouteLimits, overrides string) error {
+	for _, override := range strings.Split(overrides, ",") {
+		override = strings.TrimSpace(override)
+		if override == "" {
+			continue
+		}
+		target, value := splitLast(override, "=")
+		route, keyType := splitLast(target, ":")
+		requestsText, periodText := splitLast(value, "/")
+		if route == "" || value == "" {
+			return fmt.Errorf("invalid rate limit %s", override)
+		}
+		if keyType != RATE_LIMIT_BY_IP && keyType != RATE_LIMIT_BY_USER_NAME && keyType != RATE_LIMIT_BY_CLIENT_ID {
+			return fmt.Errorf("invalid rate limit key type %s", keyType)
+		}
+		requests, err := strconv.Atoi(requestsText)
+		if err != nil || requests < 0 {
+			return fmt.Errorf("invalid rate limit requests %s", requestsText)
+		}
+		period, err := time.ParseDuration(periodText)
+		if err != nil || period <= 0 {
+			return fmt.Errorf("invalid rate limit period %s", periodText)
+		}
+		if routeLimits[route] == nil {
+			routeLimits[route] = make(map[string]domain.RateLimit)
+		}
+		if requests == 0 {
+			delete(routeLimits[route], keyType)
+			continue
+		}
+		routeLimits[route][keyType] = domain.RateLimit{Requests: requests, Period: period}
+	}
+	return nil
+}
+
+// longestPeriod is how long an idle bucket has to be kept before it has certainly refilled
+func (routeLimits RouteLimits) longestPeriod() time.Duration {
+	longest := time.Minute
+	for _, limits := range routeLimits {
+		for _, limit := range limits {
+			if limit.Period > longest {
+				longest = limit.Period
+			}
+		}
+	}
+	return longest
+}
+
+func splitLast(value string, separator string) (string, string) {
+	index := strings.LastIndex(value, separator)
+	if index < 0 {
+		return "", ""
+	}
+	return value[:index], value[index+len(separator):]
+}
+
+func NewRateLimitMiddleware(limiter domain.RateLimiter, routeLimits RouteLimits) RateLimitMiddleware {
+	return RateLimitMiddleware{limiter: limiter, routeLimits: routeLimits}
+}
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"banking-auth/service"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type RoleHandler struct {
+	roleService service.DefaultRoleService
+}
+
+func (roleHandler *RoleHandler) GetRoles(writer http.ResponseWriter, request *http.Request) {
+	response, appErr := roleHandler.roleService.GetRoles(bearerToken(request))
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+func (roleHandler *RoleHandler) CreateRole(writer http.ResponseWriter, request *http.Request) {
+	var roleRequest dto.RoleRequest
+	if err := decodeRequest(request, false, &roleRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	if appErr := roleHandler.roleService.CreateRole(bearerToken(request), roleRequest); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+func (roleHandler *RoleHandler) DeleteRole(writer http.ResponseWriter, request *http.Request) {
+	if appErr := roleHandler.roleService.DeleteRole(bearerToken(request), mux.Vars(request)["role"]); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (roleHandler *RoleHandler) GetPermissions(writer http.ResponseWriter, request *http.Request) {
+	response, appErr := roleHandler.roleService.GetPermissions(bearerToken(request))
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+func (roleHandler *RoleHandler) CreatePermission(writer http.ResponseWriter, request *http.Request) {
+	var permissionRequest dto.PermissionRequest
+	if err := decodeRequest(request, false, &permissionRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	if appErr := roleHandler.roleService.CreatePermission(bearerToken(request), permissionRequest); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+func (roleHandler *RoleHandler) UpdatePermission(writer http.ResponseWriter, request *http.Request) {
+	var permissionRequest dto.PermissionRequest
+	if err := decodeRequest(request, false, &permissionRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	appErr := roleHandler.roleService.UpdatePermission(bearerToken(request), mux.Vars(request)["permission"], permissionRequest)
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (roleHandler *RoleHandler) DeletePermission(writer http.ResponseWriter, request *http.Request) {
+	appErr := roleHandler.roleService.DeletePermission(bearerToken(request), mux.Vars(request)["permission"])
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (roleHandler *RoleHandler) GrantPermission(writer http.ResponseWriter, request *http.Request) {
+	roleHandler.updatePermission(writer, request, roleHandler.roleService.GrantPermission, domain.PERMISSION_EFFECT_ALLOW)
+}
+
+func (roleHandler *RoleHandler) RevokePermission(writer http.ResponseWriter, request *http.Request) {
+	roleHandler.updatePermission(writer, request, roleHandler.roleService.RevokePermission, domain.PERMISSION_EFFECT_ALLOW)
+}
+
+func (roleHandler *RoleHandler) DenyPermission(writer http.ResponseWriter, request *http.Request) {
+	roleHandler.updatePermission(writer, request, roleHandler.roleService.GrantPermission, domain.PERMISSION_EFFECT_DENY)
+}
+
+func (roleHandler *RoleHandler) RemoveDeny(writer http.ResponseWriter, request *http.Request) {
+	roleHandler.updatePermission(writer, request, roleHandler.roleService.RevokePermission, domain.PERMISSION_EFFECT_DENY)
+}
+
+func (roleHandler *RoleHandler) AddParentRole(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	if appErr := roleHandler.roleService.AddParentRole(bearerToken(request), vars["role"], vars["parent"]); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (roleHandler *RoleHandler) RemoveParentRole(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	if appErr := roleHandler.roleService.RemoveParentRole(bearerToken(request), vars["role"], vars["parent"]); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (roleHandler *RoleHandler) updatePermission(writer http.ResponseWriter, request *http.Request,
+	update func(string, string, string, string) *exceptions.AppError, effect string) {
+	vars := mux.Vars(request)
+	if appErr := update(bearerToken(request), vars["role"], vars["permission"], effect); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (roleHandler *RoleHandler) Refresh(writer http.ResponseWriter, request *http.Request) {
+	if appErr := roleHandler.roleService.Refresh(bearerToken(request)); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"banking-auth/domain"
+	"context"
+	"fmt"
+	"github.com/barnettt/banking-lib/logger"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func newServer(config *Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf("%s:%s", config.Server.Host, config.Server.Port),
+		Handler:           handler,
+		ReadTimeout:       config.Server.ReadTimeout,
+		ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+		WriteTimeout:      config.Server.WriteTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
+		MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+	}
+}
+
+// serve runs the server until SIGTERM or SIGINT. The listener is closed first so no new logins start,
+// the requests in flight then get SERVER_SHUTDOWN_TIMEOUT to finish before the background jobs are
+// stopped and the storage, e.g. the database pool, is closed
+func serve(config *Config, server *http.Server, storage domain.Storage, stopJobs []func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("starting listener ..... on server port : " + config.Server.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		// the listener could not be opened, nothing is in flight
+		logger.Error("Unable to start listener : " + err.Error())
+		_ = storage.Close()
+		log.Fatal(err)
+	case received := <-signals:
+		logger.Info("received " + received.String() + ", shutting down")
+	}
+	// a second signal stops the service right away
+	signal.Stop(signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("Requests still in flight after " + config.Server.ShutdownTimeout.String() + " : " + err.Error())
+		_ = server.Close()
+	}
+	for _, stop := range stopJobs {
+		stop()
+	}
+	if err := storage.Close(); err != nil {
+		logger.Error("Unable to close storage : " + err.Error())
+	}
+	logger.Info("shutdown complete")
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"banking-auth/dto"
+	"banking-auth/service"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type UserAdminHandler struct {
+	userService service.DefaultUserService
+}
+
+func (userAdminHandler *UserAdminHandler) CreateUser(writer http.ResponseWriter, request *http.Request) {
+	var createRequest dto.CreateUserRequest
+	if err := decodeRequest(request, false, &createRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	response, violations, appErr := userAdminHandler.userService.CreateUser(bearerToken(request), createRequest)
+	if len(violations) > 0 {
+		writePasswordPolicyError(writer, violations)
+		return
+	}
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusCreated, response, contentTypeJson)
+}
+
+func (userAdminHandler *UserAdminHandler) GetUsers(writer http.ResponseWriter, request *http.Request) {
+	response, appErr := userAdminHandler.userService.GetUsers(bearerToken(request))
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+func (userAdminHandler *UserAdminHandler) GetUser(writer http.ResponseWriter, request *http.Request) {
+	response, appErr := userAdminHandler.userService.GetUser(bearerToken(request), mux.Vars(request)["user_name"])
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+func (userAdminHandler *UserAdminHandler) UpdateUser(writer http.ResponseWriter, request *http.Request) {
+	var updateRequest dto.UpdateUserRequest
+	if err := decodeRequest(request, false, &updateRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	response, appErr := userAdminHandler.userService.UpdateUser(bearerToken(request), mux.Vars(request)["user_name"],
+		updateRequest)
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+func (userAdminHandler *UserAdminHandler) DisableUser(writer http.ResponseWriter, request *http.Request) {
+	userAdminHandler.setUserEnabled(writer, request, false)
+}
+
+func (userAdminHandler *UserAdminHandler) EnableUser(writer http.ResponseWriter, request *http.Request) {
+	userAdminHandler.setUserEnabled(writer, request, true)
+}
+
+func (userAdminHandler *UserAdminHandler) setUserEnabled(writer http.ResponseWriter, request *http.Request, enabled bool) {
+	appErr := userAdminHandler.userService.SetUserEnabled(bearerToken(request), mux.Vars(request)["user_name"], enabled)
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (userAdminHandler *UserAdminHandler) DeleteUser(writer http.ResponseWriter, request *http.Request) {
+	if appErr := userAdminHandler.userService.DeleteUser(bearerToken(request), mux.Vars(request)["user_name"]); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (userAdminHandler *UserAdminHandler) Register(writer http.ResponseWriter, request *http.Request) {
+	var registerRequest dto.CreateUserRequest
+	if err := decodeRequest(request, false, &registerRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	violations, appErr := userAdminHandler.userService.Register(registerRequest)
+	if len(violations) > 0 {
+		writePasswordPolicyError(writer, violations)
+		return
+	}
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+func (userAdminHandler *UserAdminHandler) VerifyEmail(writer http.ResponseWriter, request *http.Request) {
+	if appErr := userAdminHandler.userService.VerifyEmail(request.URL.Query().Get("token")); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -7,7 +7,9 @@ import (
 	"encoding/xml"
 	"github.com/barnettt/banking-lib/exceptions"
 	"github.com/barnettt/banking-lib/logger"
+	"github.com/gorilla/mux"
 	"net/http"
+	"strings"
 )
 
 type UserHandler struct {
@@ -27,7 +29,9 @@ func (userHandler *UserHandler) GetUserByUserName(writer http.ResponseWriter, re
 		appErr := exceptions.NewPayloadParseError(err.Error())
 		returnResponse(writer, appErr, contentType,
 			dto.LoginResponse{})
+		return
 	}
+	userReq.ClientIp = clientIp(request)
 	response, anErr := userHandler.userService.GetUserByUserName(*userReq)
 	if anErr != nil {
 		returnResponse(writer, anErr, contentType,
@@ -78,6 +82,7 @@ func (userHandler *UserHandler) Refresh(writer http.ResponseWriter, request *htt
 		appErr := exceptions.NewPayloadParseError(err.Error())
 		returnResponse(writer, appErr, contentType,
 			dto.LoginResponse{})
+		return
 	}
 	response, anErr := userHandler.userService.RefreshToken(*refreshReq)
 	if anErr != nil {
@@ -88,6 +93,79 @@ func (userHandler *UserHandler) Refresh(writer http.ResponseWriter, request *htt
 	returnResponse(writer, nil, contentType, *response)
 }
 
+func (userHandler *UserHandler) Logout(writer http.ResponseWriter, request *http.Request) {
+	contType := request.Header.Get("Content-Type")
+	var contentType bool
+	if contType == contentTypeXml {
+		contentType = true
+	}
+
+	var logoutRequest dto.LogoutRequest
+	if err := decodeRequest(request, contentType, &logoutRequest); err != nil {
+		returnResponse(writer, exceptions.NewPayloadParseError(err.Error()), contentType, dto.LoginResponse{})
+		return
+	}
+	if appErr := userHandler.userService.Logout(logoutRequest); appErr != nil {
+		returnResponse(writer, appErr, contentType, dto.LoginResponse{})
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// Revoke implements the RFC 7009 revocation endpoint, the token is posted form encoded and
+// the response is 200 whether or not the token was valid
+func (userHandler *UserHandler) Revoke(writer http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil || request.PostForm.Get("token") == "" {
+		writeResponse(writer, http.StatusBadRequest, map[string]string{"error": "invalid_request"}, contentTypeJson)
+		return
+	}
+	if appErr := userHandler.userService.RevokeToken(request.PostForm.Get("token")); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+func (userHandler *UserHandler) RevokeUserSessions(writer http.ResponseWriter, request *http.Request) {
+	userName := mux.Vars(request)["user_name"]
+	if appErr := userHandler.userService.RevokeUserSessions(bearerToken(request), userName); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (userHandler *UserHandler) UnlockUser(writer http.ResponseWriter, request *http.Request) {
+	userName := mux.Vars(request)["user_name"]
+	if appErr := userHandler.userService.UnlockUser(bearerToken(request), userName); appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// bearerToken returns the token from an "Authorization: Bearer <token>" header
+func bearerToken(request *http.Request) string {
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
+
+func decodeRequest(request *http.Request, contentType bool, target interface{}) error {
+	var err error
+	if contentType {
+		err = xml.NewDecoder(request.Body).Decode(target)
+	} else {
+		err = json.NewDecoder(request.Body).Decode(target)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	return err
+}
+
 func getUserRequest(request *http.Request, contentType bool) (*dto.UserRequest, error) {
 	var userRequest *dto.UserRequest
 	var err error
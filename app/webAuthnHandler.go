@@ -0,0 +1,74 @@
+package app
+
+import (
+	"banking-auth/dto"
+	"banking-auth/service"
+	"github.com/barnettt/banking-lib/exceptions"
+	"io"
+	"net/http"
+)
+
+type WebAuthnHandler struct {
+	webAuthnService service.DefaultWebAuthnService
+}
+
+func (webAuthnHandler *WebAuthnHandler) BeginRegistration(writer http.ResponseWriter, request *http.Request) {
+	// an empty body is refused by the service, which asks for the password or a one-time code
+	var registrationRequest dto.WebAuthnRegistrationRequest
+	if err := decodeRequest(request, false, &registrationRequest); err != nil && err != io.EOF {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	registrationRequest.ClientIp = clientIp(request)
+	response, appErr := webAuthnHandler.webAuthnService.BeginRegistration(bearerToken(request), registrationRequest)
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+func (webAuthnHandler *WebAuthnHandler) FinishRegistration(writer http.ResponseWriter, request *http.Request) {
+	var credential dto.RegistrationCredential
+	if err := decodeRequest(request, false, &credential); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	response, appErr := webAuthnHandler.webAuthnService.FinishRegistration(bearerToken(request), credential)
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusCreated, response, contentTypeJson)
+}
+
+func (webAuthnHandler *WebAuthnHandler) BeginLogin(writer http.ResponseWriter, request *http.Request) {
+	var loginRequest dto.WebAuthnLoginRequest
+	if err := decodeRequest(request, false, &loginRequest); err != nil {
+		appErr := exceptions.NewPayloadParseError(err.Error())
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	response, appErr := webAuthnHandler.webAuthnService.BeginLogin(loginRequest)
+	if appErr != nil {
+		writeResponse(writer, appErr.Code, appErr.AsMessage(), contentTypeJson)
+		return
+	}
+	writeResponse(writer, http.StatusOK, response, contentTypeJson)
+}
+
+func (webAuthnHandler *WebAuthnHandler) FinishLogin(writer http.ResponseWriter, request *http.Request) {
+	var credential dto.AssertionCredential
+	if err := decodeRequest(request, false, &credential); err != nil {
+		returnResponse(writer, exceptions.NewPayloadParseError(err.Error()), false, dto.LoginResponse{})
+		return
+	}
+	response, appErr := webAuthnHandler.webAuthnService.FinishLogin(credential)
+	if appErr != nil {
+		returnResponse(writer, appErr, false, dto.LoginResponse{})
+		return
+	}
+	returnResponse(writer, nil, false, *response)
+}
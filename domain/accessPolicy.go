@@ -0,0 +1,156 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// AccessPolicy narrows what a role's permissions allow. When a role grants an operation every policy
+// matching the role and operation must also have its condition met, e.g. tellers may call
+// NewTransaction only for amounts under a limit during branch hours
+type AccessPolicy struct {
+	Name string `json:"name"`
+	// Roles and Operations select the requests the policy applies to, empty means all, operations may be wildcards
+	Roles      []string `json:"roles"`
+	Operations []string `json:"operations"`
+	Condition  string   `json:"condition"`
+	// Timezone is the location time.* values are taken in, the server's when empty
+	Timezone  string `json:"timezone"`
+	location  *time.Location
+	condition *PolicyExpression
+}
+
+func (policy AccessPolicy) appliesTo(role string, operation string) bool {
+	if len(policy.Roles) > 0 && !contains(policy.Roles, role) {
+		return false
+	}
+	if len(policy.Operations) == 0 {
+		return true
+	}
+	for _, pattern := range policy.Operations {
+		if MatchPermission(pattern, operation) {
+			return true
+		}
+	}
+	return false
+}
+
+type AccessPolicies []AccessPolicy
+
+// Allows checks the policies applying to the role and operation, the name of the first policy
+// whose condition is not met is returned. Conditions that can't be decided count as not met
+func (policies AccessPolicies) Allows(role string, operation string, claims AccessTokenClaims,
+	params map[string]string, now time.Time) (bool, string, error) {
+	operation = strings.TrimSpace(operation)
+	for _, policy := range policies {
+		if !policy.appliesTo(role, operation) {
+			continue
+		}
+		values := policyValues(role, operation, claims, params, now.In(policy.location))
+		met, err := policy.condition.Evaluate(values)
+		if err != nil {
+			return false, policy.Name, err
+		}
+		if !met {
+			return false, policy.Name, nil
+		}
+	}
+	return true, "", nil
+}
+
+// policyValues exposes the request to conditions as role, operation, claims.*, params.* and time.*
+func policyValues(role string, operation string, claims AccessTokenClaims, params map[string]string,
+	now time.Time) PolicyValues {
+	return func(name string) interface{} {
+		switch name {
+		case "role":
+			return role
+		case "operation":
+			return operation
+		case "claims.user_name":
+			return claims.UserName
+		case "claims.customer_id":
+			return claims.CustomerId
+		case "claims.roles":
+			return stringList(claims.RoleNames())
+		case "claims.accounts":
+			return stringList(claims.Accounts)
+		case "claims.client_id":
+			return claims.ClientId
+		case "claims.scope":
+			return stringList(strings.Fields(claims.Scope))
+		case "time.hour":
+			return float64(now.Hour())
+		case "time.minute":
+			return float64(now.Minute())
+		case "time.clock":
+			return now.Format("15:04")
+		case "time.weekday":
+			return now.Weekday().String()[:3]
+		case "time.date":
+			return now.Format("2006-01-02")
+		}
+		if strings.HasPrefix(name, "params.") {
+			// the token is never exposed to conditions
+			if key := strings.TrimPrefix(name, "params."); key != "token" {
+				if value, ok := params[key]; ok {
+					return value
+				}
+			}
+		}
+		return nil
+	}
+}
+
+func stringList(values []string) []interface{} {
+	list := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		list = append(list, value)
+	}
+	return list
+}
+
+// NewAccessPolicy compiles the policy's condition so mistakes are found when the policies are loaded
+func NewAccessPolicy(policy AccessPolicy) (AccessPolicy, error) {
+	if policy.Name == "" {
+		return policy, fmt.Errorf("access policy needs a name")
+	}
+	condition, err := CompilePolicyExpression(policy.Condition)
+	if err != nil {
+		return policy, fmt.Errorf("access policy %s : %s", policy.Name, err.Error())
+	}
+	policy.condition = condition
+	policy.location = time.Local
+	if policy.Timezone != "" {
+		if policy.location, err = time.LoadLocation(policy.Timezone); err != nil {
+			return policy, fmt.Errorf("access policy %s : %s", policy.Name, err.Error())
+		}
+	}
+	return policy, nil
+}
+
+// LoadAccessPolicies reads a json file holding {"policies": [...]}
+func LoadAccessPolicies(file string) (AccessPolicies, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var policyFile struct {
+		Policies []AccessPolicy `json:"policies"`
+	}
+	if err = json.Unmarshal(content, &policyFile); err != nil {
+		return nil, err
+	}
+	policies := make(AccessPolicies, 0, len(policyFile.Policies))
+	for _, policy := range policyFile.Policies {
+		compiled, err := NewAccessPolicy(policy)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, compiled)
+	}
+	return policies, nil
+}
@@ -0,0 +1,29 @@
+package domain
+
+import (
+	"github.com/barnettt/banking-lib/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	AUDIT_REFRESH_TOKEN_REUSE           string = "refresh_token_reuse"
+	AUDIT_WEBAUTHN_CLONED_AUTHENTICATOR string = "webauthn_cloned_authenticator"
+	AUDIT_LOGIN_LOCKED                  string = "login_locked"
+	AUDIT_LOGIN_REJECTED_WHILE_LOCKED   string = "login_rejected_while_locked"
+	AUDIT_LOGIN_UNLOCKED                string = "login_unlocked"
+)
+
+// AuditEvent records a security relevant event for the fraud and security teams, events are
+// written to the service log with an audit_event field so they can be filtered and alerted on
+type AuditEvent struct {
+	Type     string
+	UserName string
+	Detail   string
+}
+
+func RaiseAuditEvent(event AuditEvent) {
+	logger.Info("audit event",
+		zap.String("audit_event", event.Type),
+		zap.String("user_name", event.UserName),
+		zap.String("detail", event.Detail))
+}
@@ -1,21 +1,31 @@
 package domain
 
 import (
-	"banking-auth/dto"
 	"database/sql"
 	"github.com/barnettt/banking-lib/exceptions"
 	"github.com/barnettt/banking-lib/logger"
-	"github.com/jmoiron/sqlx"
 	"strconv"
+	"time"
 )
 
 type AuthRepository interface {
-	FindUser(userRequest dto.UserRequest) (*User, *exceptions.AppError)
+	FindUser(userName string) (*User, *exceptions.AppError)
+	// UpdatePassword replaces the hash of the same password, ChangePassword sets a new password
+	UpdatePassword(userName string, passwordHash string) *exceptions.AppError
+	ChangePassword(userName string, passwordHash string, historySize int) *exceptions.AppError
+	FindPasswordHistory(userName string, limit int) ([]string, *exceptions.AppError)
 	GenerateAndStoreRefreshToken(token *AuthToken) (string, *exceptions.AppError)
-	DoesRefreshTokenExist(refreshToken string) *exceptions.AppError
+	FindRefreshToken(refreshToken string) (*RefreshTokenRecord, *exceptions.AppError)
+	MarkRefreshTokenUsed(refreshToken string) (bool, *exceptions.AppError)
+	RevokeRefreshTokenFamily(tokenFamily string) *exceptions.AppError
+	RevokeUserRefreshTokens(userName string) *exceptions.AppError
+	RevokeAccessToken(jti string, userName string, expiresAt int64) *exceptions.AppError
+	RevokeUserSessions(userName string, expiresAt int64) *exceptions.AppError
+	// IsAccessTokenRevoked checks the jti and user wide revocations, issuedAt is in milliseconds
+	IsAccessTokenRevoked(jti string, userName string, issuedAt int64) (bool, *exceptions.AppError)
 }
 type AuthRepositoryDB struct {
-	client *sqlx.DB
+	client *Database
 }
 
 func (repository AuthRepositoryDB) GenerateAndStoreRefreshToken(token *AuthToken) (string, *exceptions.AppError) {
@@ -27,8 +37,8 @@ func (repository AuthRepositoryDB) GenerateAndStoreRefreshToken(token *AuthToken
 	}
 
 	// 2 store the refresh token
-	insertQuery := "INSERT INTO refresh_token_store (refresh_token) VALUES (?)"
-	_, err := repository.client.Exec(insertQuery, refreshToken)
+	insertQuery := "INSERT INTO refresh_token_store (refresh_token, token_family, user_name) VALUES (?, ?, ?)"
+	_, err := repository.client.Exec(insertQuery, refreshToken, token.TokenFamily(), token.UserName())
 	if err != nil {
 		logger.Error(err.Error())
 		appErr = exceptions.NewDatabaseError("Error while storing refresh token")
@@ -37,17 +47,21 @@ func (repository AuthRepositoryDB) GenerateAndStoreRefreshToken(token *AuthToken
 	return refreshToken, nil
 }
 
-func (repository AuthRepositoryDB) FindUser(userRequest dto.UserRequest) (*User, *exceptions.AppError) {
-	customerQuery := "SELECT username, password, role, u.customer_id, GROUP_CONCAT(a.account_id) as account_numbers " +
+func (repository AuthRepositoryDB) FindUser(userName string) (*User, *exceptions.AppError) {
+	// every selected column is grouped on, postgres refuses a query that doesn't
+	customerQuery := "SELECT u.username, u.password, u.customer_id, u.status, u.password_changed_at, " +
+		repository.client.Dialect.GroupConcat("a.account_id") + " as account_numbers " +
 		"FROM USERS u  " +
 		"LEFT JOIN Accounts a ON a.customer_id = u.customer_id  " +
-		"where username = ? and password = ?  group by u.customer_id"
+		"where username = ?  group by u.username, u.password, u.customer_id, u.status, u.password_changed_at"
 
 	var user User
 	var accounts sql.NullString
 	var customerId sql.NullString
-	err := repository.client.QueryRow(customerQuery, userRequest.UserName, userRequest.Password).Scan(
-		&user.UserName, &user.Password, &user.Role, &customerId, &accounts)
+	var status sql.NullString
+	var passwordChangedAt sql.NullInt64
+	err := repository.client.QueryRow(customerQuery, userName).Scan(
+		&user.UserName, &user.Password, &customerId, &status, &passwordChangedAt, &accounts)
 	if err == sql.ErrNoRows {
 		anErr := exceptions.NewJwtError("invalid user credentials  user")
 		return nil, anErr
@@ -58,6 +72,18 @@ func (repository AuthRepositoryDB) FindUser(userRequest dto.UserRequest) (*User,
 		return nil, anErr
 	}
 	user.AccountNumbers = accounts.String
+	user.Status = status.String
+	user.PasswordChangedAt = passwordChangedAt.Int64
+	userRoles, err := findUserRoles(repository.client, user.UserName)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, exceptions.NewDatabaseError("unable to retrieve user")
+	}
+	user.Roles = userRoles[user.UserName]
+	if !customerId.Valid {
+		// staff users are not linked to a customer
+		return &user, nil
+	}
 	user.CustomerId, err = strconv.Atoi(customerId.String)
 	if err != nil {
 		logger.Error(err.Error())
@@ -67,22 +93,174 @@ func (repository AuthRepositoryDB) FindUser(userRequest dto.UserRequest) (*User,
 	return &user, nil
 }
 
-func (repository AuthRepositoryDB) DoesRefreshTokenExist(refreshToken string) *exceptions.AppError {
-	selectQuery := "SELECT refresh_token FROM refresh_token_store where refresh_token = ?"
-	var token string
-	err := repository.client.Get(&token, selectQuery, refreshToken)
+func (repository AuthRepositoryDB) UpdatePassword(userName string, passwordHash string) *exceptions.AppError {
+	updateQuery := "UPDATE USERS SET password = ? WHERE username = ?"
+	_, err := repository.client.Exec(updateQuery, passwordHash, userName)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while updating user password")
+	}
+	return nil
+}
+
+// ChangePassword stores the new hash, restarts the password's age and keeps the last historySize hashes
+func (repository AuthRepositoryDB) ChangePassword(userName string, passwordHash string, historySize int) *exceptions.AppError {
+	tx, err := repository.client.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while updating user password")
+	}
+	now := time.Now()
+	_, err = tx.Exec("UPDATE USERS SET password = ?, password_changed_at = ? WHERE username = ?", passwordHash,
+		now.Unix(), userName)
+	if err == nil && historySize > 0 {
+		// history is kept in milliseconds, in seconds two changes in the same second could trim the wrong hash
+		_, err = tx.Exec("INSERT INTO password_history (user_name, password_hash, created_at) VALUES (?, ?, ?)",
+			userName, passwordHash, now.UnixMilli())
+		var keepFrom int64
+		if err == nil {
+			err = tx.Get(&keepFrom, "SELECT MIN(created_at) FROM (SELECT created_at FROM password_history "+
+				"WHERE user_name = ? ORDER BY created_at DESC LIMIT ?) recent", userName, historySize)
+		}
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM password_history WHERE user_name = ? AND created_at < ?", userName, keepFrom)
+		}
+	} else if err == nil {
+		_, err = tx.Exec("DELETE FROM password_history WHERE user_name = ?", userName)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		_ = tx.Rollback()
+		return exceptions.NewDatabaseError("Error while updating user password")
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while updating user password")
+	}
+	return nil
+}
+
+func (repository AuthRepositoryDB) FindPasswordHistory(userName string, limit int) ([]string, *exceptions.AppError) {
+	hashes := make([]string, 0)
+	selectQuery := "SELECT password_hash FROM password_history WHERE user_name = ? ORDER BY created_at DESC LIMIT ?"
+	if err := repository.client.Select(&hashes, selectQuery, userName, limit); err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return hashes, nil
+}
+
+func (repository AuthRepositoryDB) FindRefreshToken(refreshToken string) (*RefreshTokenRecord, *exceptions.AppError) {
+	selectQuery := "SELECT refresh_token, token_family, user_name, used, revoked FROM refresh_token_store where refresh_token = ?"
+	var record RefreshTokenRecord
+	err := repository.client.Get(&record, selectQuery, refreshToken)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
 
-			return exceptions.NewJwtError("refresh token not registered")
+			return nil, exceptions.NewJwtError("refresh token not registered")
 		}
 		logger.Error("Unexpected database error" + err.Error())
-		return exceptions.NewDatabaseError("Unexpected database error")
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return &record, nil
+}
+
+// MarkRefreshTokenUsed consumes the refresh token, false means it had already been used,
+// the used = 0 guard makes concurrent refreshes with the same token race safely
+func (repository AuthRepositoryDB) MarkRefreshTokenUsed(refreshToken string) (bool, *exceptions.AppError) {
+	updateQuery := "UPDATE refresh_token_store SET used = 1 WHERE refresh_token = ? AND used = 0"
+	result, err := repository.client.Exec(updateQuery, refreshToken)
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return false, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return false, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return rows == 1, nil
+}
+
+func (repository AuthRepositoryDB) RevokeRefreshTokenFamily(tokenFamily string) *exceptions.AppError {
+	updateQuery := "UPDATE refresh_token_store SET revoked = 1 WHERE token_family = ?"
+	_, err := repository.client.Exec(updateQuery, tokenFamily)
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return exceptions.NewDatabaseError("Error while revoking refresh tokens")
+	}
+	return nil
+}
+
+func (repository AuthRepositoryDB) RevokeUserRefreshTokens(userName string) *exceptions.AppError {
+	updateQuery := "UPDATE refresh_token_store SET revoked = 1 WHERE user_name = ?"
+	_, err := repository.client.Exec(updateQuery, userName)
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return exceptions.NewDatabaseError("Error while revoking refresh tokens")
 	}
 	return nil
 }
 
-func NewUserRepository(client *sqlx.DB) AuthRepositoryDB {
+func (repository AuthRepositoryDB) RevokeAccessToken(jti string, userName string, expiresAt int64) *exceptions.AppError {
+	insertQuery := "INSERT INTO revoked_tokens (jti, user_name, revoked_at, expires_at) VALUES (?, ?, ?, ?)"
+	_, err := repository.client.Exec(insertQuery, jti, userName, time.Now().UnixMilli(), expiresAt)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while revoking access token")
+	}
+	repository.deleteExpiredRevocations()
+	return nil
+}
+
+// RevokeUserSessions revokes every refresh token of the user and records a user wide revocation
+// (a row without a jti) which rejects every access token issued to the user up to now, it is kept
+// until expiresAt when the last of those tokens has expired
+func (repository AuthRepositoryDB) RevokeUserSessions(userName string, expiresAt int64) *exceptions.AppError {
+	tx, err := repository.client.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while revoking user sessions")
+	}
+	now := time.Now()
+	_, err = tx.Exec("UPDATE refresh_token_store SET revoked = 1 WHERE user_name = ?", userName)
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO revoked_tokens (jti, user_name, revoked_at, expires_at) VALUES (NULL, ?, ?, ?)",
+			userName, now.UnixMilli(), expiresAt)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		_ = tx.Rollback()
+		return exceptions.NewDatabaseError("Error while revoking user sessions")
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while revoking user sessions")
+	}
+	return nil
+}
+
+func (repository AuthRepositoryDB) IsAccessTokenRevoked(jti string, userName string, issuedAt int64) (bool, *exceptions.AppError) {
+	selectQuery := "SELECT COUNT(*) FROM revoked_tokens " +
+		"WHERE jti = ? OR (jti IS NULL AND user_name = ? AND revoked_at >= ?)"
+	var count int
+	err := repository.client.Get(&count, selectQuery, jti, userName, issuedAt)
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return false, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return count > 0, nil
+}
+
+// deleteExpiredRevocations drops revocations for tokens that can no longer verify anyway
+func (repository AuthRepositoryDB) deleteExpiredRevocations() {
+	_, err := repository.client.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now().Unix())
+	if err != nil {
+		logger.Error("Error while deleting expired revocations : " + err.Error())
+	}
+}
+
+func NewUserRepository(client *Database) AuthRepositoryDB {
 	return AuthRepositoryDB{client}
 }
@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"time"
+)
+
+const AUTHORIZATION_CODE_DURATION time.Duration = time.Minute
+
+// AuthorizationCode is a row of authorization_codes, only the sha256 of the code is stored
+// so a database read does not leak usable codes
+type AuthorizationCode struct {
+	CodeHash      string `db:"code_hash"`
+	ClientId      string `db:"client_id"`
+	UserName      string `db:"user_name"`
+	RedirectUri   string `db:"redirect_uri"`
+	Scope         string `db:"scope"`
+	CodeChallenge string `db:"code_challenge"`
+	Nonce         string `db:"nonce"`
+	AuthTime      int64  `db:"auth_time"`
+	ExpiresAt     int64  `db:"expires_at"`
+	Used          bool   `db:"used"`
+}
+
+func (code AuthorizationCode) HasExpired() bool {
+	return time.Now().Unix() > code.ExpiresAt
+}
+
+// IsVerifierValid checks the PKCE S256 code_verifier against the stored code_challenge
+func (code AuthorizationCode) IsVerifierValid(codeVerifier string) bool {
+	if codeVerifier == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == code.CodeChallenge
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type AuthorizationCodeRepository interface {
+	SaveAuthorizationCode(code AuthorizationCode) *exceptions.AppError
+	// ConsumeAuthorizationCode returns the code and marks it used, a code can only be consumed once
+	ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, *exceptions.AppError)
+}
+
+type AuthorizationCodeRepositoryDB struct {
+	client *Database
+}
+
+func (repository AuthorizationCodeRepositoryDB) SaveAuthorizationCode(code AuthorizationCode) *exceptions.AppError {
+	insertQuery := "INSERT INTO authorization_codes " +
+		"(code_hash, client_id, user_name, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at, used) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0)"
+	_, err := repository.client.Exec(insertQuery, code.CodeHash, code.ClientId, code.UserName, code.RedirectUri,
+		code.Scope, code.CodeChallenge, code.Nonce, code.AuthTime, code.ExpiresAt)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while storing authorization code")
+	}
+	// codes are short lived, drop the ones nobody redeemed
+	if _, err = repository.client.Exec("DELETE FROM authorization_codes WHERE expires_at < ?",
+		time.Now().Add(-time.Hour).Unix()); err != nil {
+		logger.Error("Error while deleting expired authorization codes : " + err.Error())
+	}
+	return nil
+}
+
+func (repository AuthorizationCodeRepositoryDB) ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, *exceptions.AppError) {
+	selectQuery := "SELECT code_hash, client_id, user_name, redirect_uri, scope, code_challenge, nonce, auth_time, " +
+		"expires_at, used " +
+		"FROM authorization_codes WHERE code_hash = ?"
+	var code AuthorizationCode
+	err := repository.client.Get(&code, selectQuery, codeHash)
+	if err == sql.ErrNoRows {
+		return nil, exceptions.NewValidationError("authorization code not found")
+	}
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	result, err := repository.client.Exec("UPDATE authorization_codes SET used = 1 WHERE code_hash = ? AND used = 0", codeHash)
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != 1 {
+		return nil, exceptions.NewValidationError("authorization code already used")
+	}
+	return &code, nil
+}
+
+func NewAuthorizationCodeRepository(client *Database) AuthorizationCodeRepositoryDB {
+	return AuthorizationCodeRepositoryDB{client}
+}
@@ -1,36 +1,82 @@
 package domain
 
 import (
-	"banking-auth/dto"
 	"github.com/barnettt/banking-lib/exceptions"
 	"github.com/barnettt/banking-lib/logger"
 	"github.com/golang-jwt/jwt"
 	"time"
 )
 
-const TOKEN_DURATION time.Duration = time.Hour
-const REFRESH_TOKEN_TIME time.Duration = time.Hour * 24 * 30
+const DEFAULT_ACCESS_TOKEN_LIFETIME time.Duration = time.Hour
+const DEFAULT_REFRESH_TOKEN_LIFETIME time.Duration = time.Hour * 24 * 30
+
+// TokenLifetimes are how long the access and refresh tokens of user logins are valid for
+type TokenLifetimes struct {
+	AccessToken  time.Duration
+	RefreshToken time.Duration
+}
 
 type AuthToken struct {
 	token        *jwt.Token
 	refreshToken *jwt.Token
+	signingKey   SigningKey
+	tokenFamily  string
+	lifetimes    TokenLifetimes
 }
 
-func NewAccessTokenFromRefreshToken(refreshToken string) (string, *exceptions.AppError) {
-	token, err := jwt.ParseWithClaims(refreshToken, &RefreshTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(dto.SECRET_WORD), nil
-	})
+// NewAuthTokenFromRefreshToken verifies the refresh token and builds the next token pair in the same token family
+func NewAuthTokenFromRefreshToken(refreshToken string, keyRing *KeyRing, lifetimes TokenLifetimes) (*AuthToken, *exceptions.AppError) {
+	token, err := jwt.ParseWithClaims(refreshToken, &RefreshTokenClaims{}, keyRing.Keyfunc)
 	if err != nil {
-		return "", exceptions.NewUnauthorisedError("invalid or expired refresh token")
+		return nil, exceptions.NewUnauthorisedError("invalid or expired refresh token")
 	}
 	refreshTokenClaims := token.Claims.(*RefreshTokenClaims)
-	accessTokenClaims := refreshTokenClaims.RefreshAccessTokenClaims()
-	authToken := NewAuthToken(accessTokenClaims)
-	return authToken.NewAccessToken()
+	accessTokenClaims := refreshTokenClaims.RefreshAccessTokenClaims(lifetimes.AccessToken)
+	authToken := NewAuthToken(accessTokenClaims, keyRing.SigningKey(), lifetimes)
+	if refreshTokenClaims.TokenFamily != "" {
+		authToken.tokenFamily = refreshTokenClaims.TokenFamily
+	}
+	return &authToken, nil
+}
+
+func (authToken AuthToken) UserName() string {
+	return authToken.token.Claims.(*AccessTokenClaims).UserName
+}
+
+func (authToken AuthToken) Roles() []string {
+	return authToken.token.Claims.(*AccessTokenClaims).RoleNames()
+}
+
+func (authToken AuthToken) Scope() string {
+	return authToken.token.Claims.(*AccessTokenClaims).Scope
+}
+
+// SetScope changes the scope of the access token, and of the refresh token issued with it
+func (authToken AuthToken) SetScope(scope string) {
+	authToken.token.Claims.(*AccessTokenClaims).Scope = scope
+}
+
+// SetUser replaces the user details of the access token, and of the refresh token issued with it
+func (authToken AuthToken) SetUser(roles []string, customerId string, accounts []string) {
+	claims := authToken.token.Claims.(*AccessTokenClaims)
+	claims.Roles = roles
+	// the single role of old tokens would otherwise still be read
+	claims.Role = ""
+	claims.CustomerId = customerId
+	claims.Accounts = accounts
+}
+
+// ClientId is the OAuth client the token was issued to, empty for first party logins
+func (authToken AuthToken) ClientId() string {
+	return authToken.token.Claims.(*AccessTokenClaims).ClientId
+}
+
+func (authToken AuthToken) TokenFamily() string {
+	return authToken.tokenFamily
 }
 
 func (authToken AuthToken) NewAccessToken() (string, *exceptions.AppError) {
-	token, err := authToken.token.SignedString([]byte(dto.SECRET_WORD))
+	token, err := authToken.signingKey.Sign(authToken.token)
 	if err != nil {
 		logger.Error(err.Error())
 		return "", exceptions.NewJwtError("Error while attempting to sign access token")
@@ -41,9 +87,9 @@ func (authToken AuthToken) NewAccessToken() (string, *exceptions.AppError) {
 func (authToken AuthToken) NewRefreshToken() (string, *exceptions.AppError) {
 	// get the claims fpr the customer from the existing claim
 	claims := authToken.token.Claims.(*AccessTokenClaims)
-	refreshTokenClaims := claims.refreshTokenClaims()
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims)
-	token, err := refreshToken.SignedString([]byte(dto.SECRET_WORD))
+	refreshTokenClaims := claims.refreshTokenClaims(authToken.tokenFamily, authToken.lifetimes.RefreshToken)
+	refreshToken := jwt.NewWithClaims(authToken.signingKey.Method, refreshTokenClaims)
+	token, err := authToken.signingKey.Sign(refreshToken)
 	authToken.refreshToken = refreshToken
 	if err != nil {
 		logger.Error(err.Error())
@@ -53,9 +99,54 @@ func (authToken AuthToken) NewRefreshToken() (string, *exceptions.AppError) {
 
 }
 
-func NewAuthToken(claims AccessTokenClaims) AuthToken {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims)
+// NewMfaChallengeToken issues a challenge or enrollment token, the claims are returned so the
+// challenge can be recorded and only ever exchanged once
+func NewMfaChallengeToken(tokenType string, userName string, scope string, signingKey SigningKey) (string, *MfaChallengeClaims, *exceptions.AppError) {
+	claims := MfaChallengeClaims{
+		TokenType: tokenType,
+		Scope:     scope,
+		StandardClaims: jwt.StandardClaims{
+			Id:        NewTokenId(),
+			Subject:   userName,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(MFA_CHALLENGE_DURATION).Unix(),
+		},
+	}
+	token, err := signingKey.Sign(jwt.NewWithClaims(signingKey.Method, claims))
+	if err != nil {
+		logger.Error(err.Error())
+		return "", nil, exceptions.NewJwtError("Error while attempting to sign mfa challenge token")
+	}
+	return token, &claims, nil
+}
+
+// ParseMfaChallengeToken checks the signature, expiry and type of a challenge or enrollment token,
+// the subject is the user name and the scope is the one asked for at login
+func ParseMfaChallengeToken(challengeToken string, tokenType string, keyRing *KeyRing) (*MfaChallengeClaims, *exceptions.AppError) {
+	claims := &MfaChallengeClaims{}
+	token, err := jwt.ParseWithClaims(challengeToken, claims, keyRing.Keyfunc)
+	if err != nil || !token.Valid || claims.TokenType != tokenType || claims.Subject == "" {
+		return nil, exceptions.NewUnauthorisedError("invalid or expired mfa challenge")
+	}
+	return claims, nil
+}
+
+func NewIdToken(claims IdTokenClaims, signingKey SigningKey) (string, *exceptions.AppError) {
+	token, err := signingKey.Sign(jwt.NewWithClaims(signingKey.Method, claims))
+	if err != nil {
+		logger.Error(err.Error())
+		return "", exceptions.NewJwtError("Error while attempting to sign id token")
+	}
+	return token, nil
+}
+
+// NewAuthToken signs the access token claims as they are, the lifetimes set the expiry of the refresh token
+func NewAuthToken(claims AccessTokenClaims, signingKey SigningKey, lifetimes TokenLifetimes) AuthToken {
+	token := jwt.NewWithClaims(signingKey.Method, &claims)
 	return AuthToken{
-		token: token,
+		token:       token,
+		signingKey:  signingKey,
+		tokenFamily: NewTokenId(),
+		lifetimes:   lifetimes,
 	}
 }
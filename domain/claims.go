@@ -9,31 +9,101 @@ import (
 	"time"
 )
 
+const MACHINE_TOKEN_TYPE string = "client"
+
 type AccessTokenClaims struct {
-	TokenType      string   `json:"token_type"`
-	UserName       string   `json:"userName"`
-	CustomerId     string   `json:"customer_id"`
-	Role           string   `json:"role"`
+	TokenType  string   `json:"token_type"`
+	UserName   string   `json:"userName"`
+	CustomerId string   `json:"customer_id"`
+	Roles      []string `json:"roles"`
+	// Role is only read from tokens issued before users could have several roles
+	Role           string   `json:"role,omitempty"`
 	Accounts       []string `json:"accounts"`
-	StandardClaims jwt.StandardClaims
+	ClientId       string   `json:"client_id,omitempty"`
+	Scope          string   `json:"scope,omitempty"`
+	IssuedAtMillis int64    `json:"iat_ms,omitempty"`
+	// embedded so exp, iat and jti are top level where standard validators look for them
+	jwt.StandardClaims
 }
 type RefreshTokenClaims struct {
-	TokenType      string   `json:"token_type"`
-	Name           string   `json:"userName"`
-	CId            string   `json:"customer_id"`
-	Role           string   `json:"role"`
-	Accounts       []string `json:"accounts"`
-	StandardClaims jwt.StandardClaims
+	TokenType   string   `json:"token_type"`
+	TokenFamily string   `json:"token_family"`
+	Name        string   `json:"userName"`
+	CId         string   `json:"customer_id"`
+	Roles       []string `json:"roles"`
+	Role        string   `json:"role,omitempty"`
+	Accounts    []string `json:"accounts"`
+	ClientId    string   `json:"client_id,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	jwt.StandardClaims
+}
+
+// legacyStandardClaims reads the standard claims of access and refresh tokens issued before they were
+// embedded, which nested them under StandardClaims. Those tokens are still accepted until they expire
+type legacyStandardClaims struct {
+	StandardClaims *jwt.StandardClaims `json:"StandardClaims"`
+}
+
+func (claims *AccessTokenClaims) UnmarshalJSON(data []byte) error {
+	type accessTokenClaims AccessTokenClaims
+	if err := json.Unmarshal(data, (*accessTokenClaims)(claims)); err != nil {
+		return err
+	}
+	return unmarshalLegacyStandardClaims(data, &claims.StandardClaims)
+}
+
+func (claims *RefreshTokenClaims) UnmarshalJSON(data []byte) error {
+	type refreshTokenClaims RefreshTokenClaims
+	if err := json.Unmarshal(data, (*refreshTokenClaims)(claims)); err != nil {
+		return err
+	}
+	return unmarshalLegacyStandardClaims(data, &claims.StandardClaims)
+}
+
+func unmarshalLegacyStandardClaims(data []byte, standardClaims *jwt.StandardClaims) error {
+	var legacy legacyStandardClaims
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.StandardClaims != nil && standardClaims.ExpiresAt == 0 {
+		*standardClaims = *legacy.StandardClaims
+	}
+	return nil
+}
+
+const MFA_CHALLENGE_TOKEN_TYPE string = "mfa_challenge"
+
+// MFA_ENROLLMENT_TOKEN_TYPE is issued instead of the tokens when mfa is enforced and the user has
+// no second factor yet, it only lets the user enroll one
+const MFA_ENROLLMENT_TOKEN_TYPE string = "mfa_enrollment"
+const MFA_CHALLENGE_DURATION time.Duration = time.Minute * 5
+
+// MfaChallengeClaims identify a user who has passed the password check and still has to present
+// or enroll a second factor, the token cannot be used as an access token
+type MfaChallengeClaims struct {
+	TokenType string `json:"token_type"`
+	// the scope asked for at login, granted once the second factor is verified
+	Scope string `json:"scope,omitempty"`
+	jwt.StandardClaims
+}
+
+// IdTokenClaims are the OpenID Connect id token claims, the standard claims are embedded so iss,
+// sub, aud, exp and iat are top level as the specification requires
+type IdTokenClaims struct {
+	jwt.StandardClaims
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	PreferredUserName string `json:"preferred_username,omitempty"`
+	CustomerId        string `json:"customer_id,omitempty"`
 }
 
 func HasTokenExpired(claimDate time.Time) (bool, *exceptions.AppError) {
 	return validateClaimDate(claimDate)
 }
 
+// validateClaimDate treats the token as expired from its exp onwards, there is no grace period
 func validateClaimDate(claimDate time.Time) (bool, *exceptions.AppError) {
-	hour := int64(time.Hour.Minutes())
-	remaining := int64(time.Now().Sub(claimDate).Minutes())
-	if remaining > hour {
+	if !time.Now().Before(claimDate) {
 		return true, exceptions.NewJwtError("Token has expired")
 	} else {
 		return false, nil
@@ -55,34 +125,55 @@ func (claims AccessTokenClaims) Valid() error {
 	return nil
 }
 
-func (claims AccessTokenClaims) refreshTokenClaims() RefreshTokenClaims {
-	var date = time.Now().Add(REFRESH_TOKEN_TIME).Unix() // keep refresh token alive for 1 month
+func (claims AccessTokenClaims) refreshTokenClaims(tokenFamily string, lifetime time.Duration) RefreshTokenClaims {
+	var date = time.Now().Add(lifetime).Unix()
 	return RefreshTokenClaims{
-		TokenType: "refresh",
-		Name:      claims.UserName,
-		CId:       claims.CustomerId,
-		Role:      claims.Role,
-		Accounts:  nil,
+		TokenType:   "refresh",
+		TokenFamily: tokenFamily,
+		Name:        claims.UserName,
+		CId:         claims.CustomerId,
+		Roles:       claims.Roles,
+		Role:        claims.Role,
+		Accounts:    nil,
+		ClientId:    claims.ClientId,
+		Scope:       claims.Scope,
 		StandardClaims: jwt.StandardClaims{
+			// unique per token so each rotation produces a distinct refresh token
+			Id:        NewTokenId(),
 			ExpiresAt: date,
 		},
 	}
 }
 
-func (claims RefreshTokenClaims) RefreshAccessTokenClaims() AccessTokenClaims {
-	var date = time.Now().Add(TOKEN_DURATION).Unix()
+func (claims RefreshTokenClaims) RefreshAccessTokenClaims(lifetime time.Duration) AccessTokenClaims {
+	now := time.Now()
 	return AccessTokenClaims{
-		TokenType:  "access",
-		UserName:   claims.Name,
-		CustomerId: claims.CId,
-		Role:       claims.Role,
-		Accounts:   claims.Accounts,
+		TokenType:      "access",
+		UserName:       claims.Name,
+		CustomerId:     claims.CId,
+		Roles:          claims.Roles,
+		Role:           claims.Role,
+		Accounts:       claims.Accounts,
+		ClientId:       claims.ClientId,
+		Scope:          claims.Scope,
+		IssuedAtMillis: now.UnixMilli(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: date,
+			Id:        NewTokenId(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(lifetime).Unix(),
 		},
 	}
 }
 
+// IssuedAt is when the token was issued in milliseconds, revocations are checked against it. Tokens
+// issued before iat_ms was added only have iat in whole seconds
+func (claims AccessTokenClaims) IssuedAt() int64 {
+	if claims.IssuedAtMillis != 0 {
+		return claims.IssuedAtMillis
+	}
+	return claims.StandardClaims.IssuedAt * 1000
+}
+
 func ConvertJwtClaimsToUserClaims(claimsMap jwt.MapClaims) (*AccessTokenClaims, *exceptions.AppError) {
 	// create a claims map from the jwt token by marshalling the json
 	claims := make([]byte, 0)
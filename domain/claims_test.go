@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"encoding/json"
+	"github.com/golang-jwt/jwt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenClaimsStandardClaimsAreTopLevel(t *testing.T) {
+	expiresAt := time.Now().Add(-time.Minute).Unix()
+	claims := AccessTokenClaims{UserName: "2001", StandardClaims: jwt.StandardClaims{Id: "jti", ExpiresAt: expiresAt}}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claimsMap jwt.MapClaims
+	if err = json.Unmarshal(data, &claimsMap); err != nil {
+		t.Fatal(err)
+	}
+	if _, nested := claimsMap["StandardClaims"]; nested || claimsMap["jti"] != "jti" {
+		t.Fatalf("standard claims are not top level: %s", data)
+	}
+	// a standard validator only knows the token expired when exp is top level
+	if claimsMap.VerifyExpiresAt(time.Now().Unix(), true) {
+		t.Fatal("expired token passed a standard exp check")
+	}
+}
+
+func TestClaimsReadLegacyNestedStandardClaims(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	legacy := []byte(`{"token_type":"refresh","userName":"2001","StandardClaims":{"jti":"jti","exp":` +
+		strconv.FormatInt(expiresAt, 10) + `}}`)
+
+	var accessClaims AccessTokenClaims
+	if err := json.Unmarshal(legacy, &accessClaims); err != nil {
+		t.Fatal(err)
+	}
+	var refreshClaims RefreshTokenClaims
+	if err := json.Unmarshal(legacy, &refreshClaims); err != nil {
+		t.Fatal(err)
+	}
+	for _, standardClaims := range []jwt.StandardClaims{accessClaims.StandardClaims, refreshClaims.StandardClaims} {
+		if standardClaims.Id != "jti" || standardClaims.ExpiresAt != expiresAt {
+			t.Fatalf("legacy standard claims were not read: %+v", standardClaims)
+		}
+	}
+	if accessClaims.UserName != "2001" || refreshClaims.Name != "2001" {
+		t.Fatal("claims next to the legacy standard claims were not read")
+	}
+}
@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"database/sql"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"strings"
+	"time"
+)
+
+// Client is an OAuth client registered in oauth_clients, the secret is stored hashed
+// with the same PasswordHasher used for user passwords
+type Client struct {
+	ClientId   string `db:"client_id"`
+	SecretHash string `db:"client_secret"`
+	Name       string `db:"client_name"`
+	// space separated list of scopes the client may be granted
+	AllowedScopes string `db:"allowed_scopes"`
+	// access token lifetime in seconds, zero uses the lifetime of user access tokens
+	TokenLifetime int64 `db:"token_lifetime"`
+	// space separated list of redirect uris registered for the authorization code flow
+	RedirectUris string `db:"redirect_uris"`
+}
+
+// IsPublic reports whether the client has no secret, public clients such as mobile
+// apps can only use the authorization code flow with PKCE
+func (client Client) IsPublic() bool {
+	return client.SecretHash == ""
+}
+
+// IsRedirectUriAllowed requires an exact match with a registered redirect uri
+func (client Client) IsRedirectUriAllowed(redirectUri string) bool {
+	return redirectUri != "" && contains(strings.Fields(client.RedirectUris), redirectUri)
+}
+
+func (client Client) Scopes() []string {
+	return strings.Fields(client.AllowedScopes)
+}
+
+// GrantScopes returns the requested scopes when the client may have all of them, an empty
+// request grants every allowed scope
+func (client Client) GrantScopes(requested []string) ([]string, bool) {
+	allowed := client.Scopes()
+	if len(requested) == 0 {
+		return allowed, true
+	}
+	for _, scope := range requested {
+		if !contains(allowed, scope) {
+			return nil, false
+		}
+	}
+	return requested, true
+}
+
+func (client Client) AccessTokenDuration(defaultLifetime time.Duration) time.Duration {
+	if client.TokenLifetime <= 0 {
+		return defaultLifetime
+	}
+	return time.Duration(client.TokenLifetime) * time.Second
+}
+
+type ClientRepository interface {
+	FindClient(clientId string) (*Client, *exceptions.AppError)
+}
+
+type ClientRepositoryDB struct {
+	client *Database
+}
+
+func (repository ClientRepositoryDB) FindClient(clientId string) (*Client, *exceptions.AppError) {
+	selectQuery := "SELECT client_id, client_secret, client_name, allowed_scopes, token_lifetime, redirect_uris " +
+		"FROM oauth_clients WHERE client_id = ?"
+	var client Client
+	err := repository.client.Get(&client, selectQuery, clientId)
+	if err == sql.ErrNoRows {
+		return nil, exceptions.NewUnauthorisedError("client not registered")
+	}
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return &client, nil
+}
+
+func NewClientRepository(client *Database) ClientRepositoryDB {
+	return ClientRepositoryDB{client}
+}
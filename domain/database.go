@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	DIALECT_MYSQL    Dialect = "mysql"
+	DIALECT_POSTGRES Dialect = "postgres"
+	DIALECT_SQLITE   Dialect = "sqlite3"
+)
+
+// Dialect is the flavour of SQL a backend speaks, named after its database/sql driver
+type Dialect string
+
+// GroupConcat aggregates the column into a comma separated string
+func (dialect Dialect) GroupConcat(column string) string {
+	if dialect == DIALECT_POSTGRES {
+		return "string_agg(CAST(" + column + " AS TEXT), ',')"
+	}
+	return "GROUP_CONCAT(" + column + ")"
+}
+
+// Database is the connection pool of one of the SQL backends. Repositories write their queries
+// with ? placeholders, they are rebound to the placeholders of the backend's driver on the way through
+type Database struct {
+	*sqlx.DB
+	Dialect Dialect
+}
+
+func (database *Database) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return database.DB.Exec(database.Rebind(query), args...)
+}
+
+func (database *Database) Get(dest interface{}, query string, args ...interface{}) error {
+	return database.DB.Get(dest, database.Rebind(query), args...)
+}
+
+func (database *Database) Select(dest interface{}, query string, args ...interface{}) error {
+	return database.DB.Select(dest, database.Rebind(query), args...)
+}
+
+func (database *Database) QueryRow(query string, args ...interface{}) *sql.Row {
+	return database.DB.QueryRow(database.Rebind(query), args...)
+}
+
+func (database *Database) Beginx() (*Transaction, error) {
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{tx}, nil
+}
+
+// Transaction rebinds queries like Database
+type Transaction struct {
+	*sqlx.Tx
+}
+
+func (tx *Transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.Rebind(query), args...)
+}
+
+func (tx *Transaction) Get(dest interface{}, query string, args ...interface{}) error {
+	return tx.Tx.Get(dest, tx.Rebind(query), args...)
+}
+
+func (tx *Transaction) Select(dest interface{}, query string, args ...interface{}) error {
+	return tx.Tx.Select(dest, tx.Rebind(query), args...)
+}
+
+func NewDatabase(client *sqlx.DB) *Database {
+	return &Database{DB: client, Dialect: Dialect(client.DriverName())}
+}
@@ -0,0 +1,345 @@
+package domain
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InMemoryStore implements every repository on maps so the whole service can run locally without a
+// database. Nothing survives a restart and nothing is shared between instances, it is meant for
+// development and demos only
+type InMemoryStore struct {
+	mutex sync.Mutex
+	users map[string]*inMemoryUser
+	// accounts maps customer ids to their account numbers
+	accounts        map[int64][]string
+	passwordHistory map[string][]string
+	refreshTokens   map[string]*RefreshTokenRecord
+	revocations     []inMemoryRevocation
+	// userTokens holds the email verification and password reset tokens by kind and hash
+	userTokens         map[string]map[string]*inMemoryUserToken
+	roles              map[string]Role
+	permissions        map[string]Permission
+	grants             map[inMemoryGrant]bool
+	parents            map[inMemoryParent]bool
+	totp               map[string]*TotpEnrollment
+	recoveryCodes      map[string]map[string]bool
+	mfaChallenges      map[string]*inMemoryUserToken
+	challenges         map[string]WebAuthnChallenge
+	credentials        map[string]*WebAuthnCredential
+	loginAttempts      map[string]*LoginAttempts
+	clients            map[string]Client
+	authorizationCodes map[string]*AuthorizationCode
+}
+
+type inMemoryUser struct {
+	account           UserAccount
+	password          string
+	passwordChangedAt int64
+}
+
+type inMemoryRevocation struct {
+	// jti is empty for a user wide revocation, revokedAt is in milliseconds and expiresAt in seconds
+	jti       string
+	userName  string
+	revokedAt int64
+	expiresAt int64
+}
+
+type inMemoryUserToken struct {
+	userName  string
+	expiresAt int64
+	used      bool
+}
+
+type inMemoryGrant struct {
+	role       string
+	permission string
+	effect     string
+}
+
+type inMemoryParent struct {
+	role   string
+	parent string
+}
+
+const (
+	inMemoryVerificationTokens  = "email_verification"
+	inMemoryPasswordResetTokens = "password_reset"
+)
+
+func (store *InMemoryStore) FindUser(userName string) (*User, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	stored, ok := store.users[userName]
+	if !ok {
+		return nil, exceptions.NewJwtError("invalid user credentials  user")
+	}
+	user := User{UserName: userName, Password: stored.password, Roles: copyStrings(stored.account.Roles),
+		CreatedDate: stored.account.CreatedDate.String, Status: stored.account.Status,
+		PasswordChangedAt: stored.passwordChangedAt}
+	if stored.account.CustomerId.Valid {
+		user.CustomerId = int(stored.account.CustomerId.Int64)
+		user.AccountNumbers = strings.Join(store.accounts[stored.account.CustomerId.Int64], ",")
+	}
+	return &user, nil
+}
+
+func (store *InMemoryStore) UpdatePassword(userName string, passwordHash string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if user, ok := store.users[userName]; ok {
+		user.password = passwordHash
+	}
+	return nil
+}
+
+func (store *InMemoryStore) ChangePassword(userName string, passwordHash string, historySize int) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if user, ok := store.users[userName]; ok {
+		user.password = passwordHash
+		user.passwordChangedAt = time.Now().Unix()
+	}
+	if historySize <= 0 {
+		delete(store.passwordHistory, userName)
+		return nil
+	}
+	history := append([]string{passwordHash}, store.passwordHistory[userName]...)
+	if len(history) > historySize {
+		history = history[:historySize]
+	}
+	store.passwordHistory[userName] = history
+	return nil
+}
+
+func (store *InMemoryStore) FindPasswordHistory(userName string, limit int) ([]string, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	history := store.passwordHistory[userName]
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return copyStrings(history), nil
+}
+
+func (store *InMemoryStore) GenerateAndStoreRefreshToken(token *AuthToken) (string, *exceptions.AppError) {
+	refreshToken, appErr := token.NewRefreshToken()
+	if appErr != nil {
+		return "", appErr
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.refreshTokens[refreshToken] = &RefreshTokenRecord{RefreshToken: refreshToken,
+		TokenFamily: token.TokenFamily(), UserName: token.UserName()}
+	return refreshToken, nil
+}
+
+func (store *InMemoryStore) FindRefreshToken(refreshToken string) (*RefreshTokenRecord, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	record, ok := store.refreshTokens[refreshToken]
+	if !ok {
+		return nil, exceptions.NewJwtError("refresh token not registered")
+	}
+	found := *record
+	return &found, nil
+}
+
+func (store *InMemoryStore) MarkRefreshTokenUsed(refreshToken string) (bool, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	record, ok := store.refreshTokens[refreshToken]
+	if !ok || record.Used {
+		return false, nil
+	}
+	record.Used = true
+	return true, nil
+}
+
+func (store *InMemoryStore) RevokeRefreshTokenFamily(tokenFamily string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, record := range store.refreshTokens {
+		if record.TokenFamily == tokenFamily {
+			record.Revoked = true
+		}
+	}
+	return nil
+}
+
+func (store *InMemoryStore) RevokeUserRefreshTokens(userName string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.revokeUserRefreshTokens(userName)
+	return nil
+}
+
+func (store *InMemoryStore) revokeUserRefreshTokens(userName string) {
+	for _, record := range store.refreshTokens {
+		if record.UserName == userName {
+			record.Revoked = true
+		}
+	}
+}
+
+func (store *InMemoryStore) RevokeAccessToken(jti string, userName string, expiresAt int64) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now().Unix()
+	// drop revocations for tokens that can no longer verify anyway
+	revocations := make([]inMemoryRevocation, 0, len(store.revocations)+1)
+	for _, revocation := range store.revocations {
+		if revocation.expiresAt >= now {
+			revocations = append(revocations, revocation)
+		}
+	}
+	store.revocations = append(revocations, inMemoryRevocation{jti: jti, userName: userName,
+		revokedAt: time.Now().UnixMilli(), expiresAt: expiresAt})
+	return nil
+}
+
+func (store *InMemoryStore) RevokeUserSessions(userName string, expiresAt int64) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.revokeUserRefreshTokens(userName)
+	store.revocations = append(store.revocations, inMemoryRevocation{userName: userName, revokedAt: time.Now().UnixMilli(),
+		expiresAt: expiresAt})
+	return nil
+}
+
+func (store *InMemoryStore) IsAccessTokenRevoked(jti string, userName string, issuedAt int64) (bool, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, revocation := range store.revocations {
+		if revocation.jti != "" && revocation.jti == jti {
+			return true, nil
+		}
+		if revocation.jti == "" && revocation.userName == userName && revocation.revokedAt >= issuedAt {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// InMemorySeed is the content of a seed file, passwords may be plain text as they are verified like
+// legacy plaintext rows. Plain text client secrets are hashed as the seed is loaded
+type InMemorySeed struct {
+	Users []struct {
+		UserName   string   `json:"username"`
+		Password   string   `json:"password"`
+		Email      string   `json:"email"`
+		Roles      []string `json:"roles"`
+		CustomerId *int64   `json:"customer_id"`
+		Accounts   []string `json:"accounts"`
+		Status     string   `json:"status"`
+	} `json:"users"`
+	Clients []struct {
+		ClientId      string `json:"client_id"`
+		ClientSecret  string `json:"client_secret"`
+		Name          string `json:"client_name"`
+		AllowedScopes string `json:"allowed_scopes"`
+		TokenLifetime int64  `json:"token_lifetime"`
+		RedirectUris  string `json:"redirect_uris"`
+	} `json:"clients"`
+}
+
+// Seed adds the users, their customers' accounts and the OAuth clients of the seed
+func (store *InMemoryStore) Seed(seed InMemorySeed) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now()
+	for _, user := range seed.Users {
+		account := UserAccount{UserName: user.UserName, Roles: copyStrings(user.Roles), Status: user.Status,
+			Email:       sql.NullString{String: user.Email, Valid: user.Email != ""},
+			CreatedDate: sql.NullString{String: now.Format("2006-01-02 15:04:05"), Valid: true}}
+		if account.Status == "" {
+			account.Status = USER_STATUS_ACTIVE
+		}
+		if user.CustomerId != nil {
+			account.CustomerId = sql.NullInt64{Int64: *user.CustomerId, Valid: true}
+			store.accounts[*user.CustomerId] = append(store.accounts[*user.CustomerId], user.Accounts...)
+		}
+		store.users[user.UserName] = &inMemoryUser{account: account, password: user.Password,
+			passwordChangedAt: now.Unix()}
+	}
+	hasher, _ := NewPasswordHasher(HASH_ALGORITHM_ARGON2ID)
+	for _, client := range seed.Clients {
+		secretHash := client.ClientSecret
+		if secretHash != "" && !hasher.Supports(secretHash) {
+			var err error
+			if secretHash, err = hasher.Hash(client.ClientSecret); err != nil {
+				logger.Error("Error hashing the secret of client " + client.ClientId + " : " + err.Error())
+				continue
+			}
+		}
+		store.clients[client.ClientId] = Client{ClientId: client.ClientId, SecretHash: secretHash,
+			Name: client.Name, AllowedScopes: client.AllowedScopes, TokenLifetime: client.TokenLifetime,
+			RedirectUris: client.RedirectUris}
+	}
+}
+
+// LoadInMemorySeed reads a json seed file, see InMemorySeed
+func LoadInMemorySeed(file string) (InMemorySeed, error) {
+	var seed InMemorySeed
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return seed, err
+	}
+	err = json.Unmarshal(content, &seed)
+	return seed, err
+}
+
+func copyStrings(values []string) []string {
+	copied := make([]string, len(values))
+	copy(copied, values)
+	return copied
+}
+
+// NewInMemoryStore returns a store holding the built in roles and permissions and no users
+func NewInMemoryStore() *InMemoryStore {
+	store := &InMemoryStore{
+		users:           make(map[string]*inMemoryUser),
+		accounts:        make(map[int64][]string),
+		passwordHistory: make(map[string][]string),
+		refreshTokens:   make(map[string]*RefreshTokenRecord),
+		userTokens: map[string]map[string]*inMemoryUserToken{
+			inMemoryVerificationTokens:  make(map[string]*inMemoryUserToken),
+			inMemoryPasswordResetTokens: make(map[string]*inMemoryUserToken),
+		},
+		roles:              make(map[string]Role),
+		permissions:        make(map[string]Permission),
+		grants:             make(map[inMemoryGrant]bool),
+		parents:            make(map[inMemoryParent]bool),
+		totp:               make(map[string]*TotpEnrollment),
+		recoveryCodes:      make(map[string]map[string]bool),
+		mfaChallenges:      make(map[string]*inMemoryUserToken),
+		challenges:         make(map[string]WebAuthnChallenge),
+		credentials:        make(map[string]*WebAuthnCredential),
+		loginAttempts:      make(map[string]*LoginAttempts),
+		clients:            make(map[string]Client),
+		authorizationCodes: make(map[string]*AuthorizationCode),
+	}
+	for operation, scope := range GetOperationScopes() {
+		store.permissions[operation] = Permission{Name: operation, Scope: sql.NullString{String: scope, Valid: true}}
+	}
+	for role, definition := range GetUserRolePermissions() {
+		store.roles[role] = Role{Name: role}
+		for _, parent := range definition.Parents {
+			store.parents[inMemoryParent{role, parent}] = true
+		}
+		for _, permission := range definition.Allow {
+			store.grants[inMemoryGrant{role, permission, PERMISSION_EFFECT_ALLOW}] = true
+		}
+		for _, permission := range definition.Deny {
+			store.grants[inMemoryGrant{role, permission, PERMISSION_EFFECT_DENY}] = true
+		}
+	}
+	return store
+}
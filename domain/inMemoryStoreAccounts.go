@@ -0,0 +1,302 @@
+package domain
+
+import (
+	"database/sql"
+	"github.com/barnettt/banking-lib/exceptions"
+	"sort"
+	"time"
+)
+
+func (store *InMemoryStore) FindUserAccount(userName string) (*UserAccount, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	user, ok := store.users[userName]
+	if !ok {
+		return nil, nil
+	}
+	account := user.account
+	account.Roles = copyStrings(user.account.Roles)
+	return &account, nil
+}
+
+func (store *InMemoryStore) FindUserAccounts() ([]UserAccount, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	accounts := make([]UserAccount, 0, len(store.users))
+	for _, user := range store.users {
+		account := user.account
+		account.Roles = copyStrings(user.account.Roles)
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].UserName < accounts[j].UserName
+	})
+	return accounts, nil
+}
+
+func (store *InMemoryStore) CreateUser(account UserAccount, passwordHash string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.users[account.UserName]; ok {
+		return exceptions.NewDatabaseError("Error while creating user")
+	}
+	now := time.Now()
+	account.Roles = copyStrings(account.Roles)
+	account.CreatedDate = sql.NullString{String: now.Format("2006-01-02 15:04:05"), Valid: true}
+	store.users[account.UserName] = &inMemoryUser{account: account, password: passwordHash,
+		passwordChangedAt: now.Unix()}
+	return nil
+}
+
+func (store *InMemoryStore) UpdateUser(account UserAccount) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if user, ok := store.users[account.UserName]; ok {
+		user.account.Email = account.Email
+		user.account.Roles = copyStrings(account.Roles)
+		user.account.CustomerId = account.CustomerId
+	}
+	return nil
+}
+
+func (store *InMemoryStore) SetUserStatus(userName string, status string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if user, ok := store.users[userName]; ok {
+		user.account.Status = status
+	}
+	return nil
+}
+
+func (store *InMemoryStore) DeleteUser(userName string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.recoveryCodes, userName)
+	delete(store.totp, userName)
+	for credentialId, credential := range store.credentials {
+		if credential.UserName == userName {
+			delete(store.credentials, credentialId)
+		}
+	}
+	for _, tokens := range store.userTokens {
+		for tokenHash, token := range tokens {
+			if token.userName == userName {
+				delete(tokens, tokenHash)
+			}
+		}
+	}
+	delete(store.users, userName)
+	return nil
+}
+
+func (store *InMemoryStore) SaveVerificationToken(tokenHash string, userName string, expiresAt int64) *exceptions.AppError {
+	return store.saveUserToken(inMemoryVerificationTokens, tokenHash, userName, expiresAt)
+}
+
+func (store *InMemoryStore) ConsumeVerificationToken(tokenHash string) (string, *exceptions.AppError) {
+	return store.consumeUserToken(inMemoryVerificationTokens, tokenHash, "invalid or expired verification token")
+}
+
+func (store *InMemoryStore) SavePasswordResetToken(tokenHash string, userName string, expiresAt int64) *exceptions.AppError {
+	return store.saveUserToken(inMemoryPasswordResetTokens, tokenHash, userName, expiresAt)
+}
+
+func (store *InMemoryStore) FindPasswordResetToken(tokenHash string) (string, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	token, ok := store.userTokens[inMemoryPasswordResetTokens][tokenHash]
+	if !ok || token.used || time.Now().Unix() > token.expiresAt {
+		return "", exceptions.NewValidationError("invalid or expired password reset token")
+	}
+	return token.userName, nil
+}
+
+func (store *InMemoryStore) ConsumePasswordResetToken(tokenHash string) (string, *exceptions.AppError) {
+	return store.consumeUserToken(inMemoryPasswordResetTokens, tokenHash, "invalid or expired password reset token")
+}
+
+func (store *InMemoryStore) InvalidatePasswordResetTokens(userName string) *exceptions.AppError {
+	store.invalidateUserTokens(inMemoryPasswordResetTokens, userName)
+	return nil
+}
+
+func (store *InMemoryStore) saveUserToken(kind string, tokenHash string, userName string, expiresAt int64) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.userTokens[kind][tokenHash] = &inMemoryUserToken{userName: userName, expiresAt: expiresAt}
+	return nil
+}
+
+func (store *InMemoryStore) consumeUserToken(kind string, tokenHash string, invalidMessage string) (string, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	token, ok := store.userTokens[kind][tokenHash]
+	if !ok || token.used || time.Now().Unix() > token.expiresAt {
+		return "", exceptions.NewValidationError(invalidMessage)
+	}
+	token.used = true
+	return token.userName, nil
+}
+
+func (store *InMemoryStore) invalidateUserTokens(kind string, userName string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, token := range store.userTokens[kind] {
+		if token.userName == userName {
+			token.used = true
+		}
+	}
+}
+
+func (store *InMemoryStore) FindRoleDefinitions() (map[string]RoleDefinition, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	definitions := make(map[string]RoleDefinition, len(store.roles))
+	for role := range store.roles {
+		definitions[role] = RoleDefinition{Parents: make([]string, 0), Allow: make([]string, 0), Deny: make([]string, 0)}
+	}
+	for grant := range store.grants {
+		definition, ok := definitions[grant.role]
+		if !ok {
+			continue
+		}
+		if grant.effect == PERMISSION_EFFECT_DENY {
+			definition.Deny = append(definition.Deny, grant.permission)
+		} else {
+			definition.Allow = append(definition.Allow, grant.permission)
+		}
+		definitions[grant.role] = definition
+	}
+	for parent := range store.parents {
+		if definition, ok := definitions[parent.role]; ok {
+			definition.Parents = append(definition.Parents, parent.parent)
+			definitions[parent.role] = definition
+		}
+	}
+	return definitions, nil
+}
+
+func (store *InMemoryStore) FindRoles() ([]Role, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	roles := make([]Role, 0, len(store.roles))
+	for _, role := range store.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+	return roles, nil
+}
+
+func (store *InMemoryStore) CreateRole(role Role) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.roles[role.Name]; ok {
+		return exceptions.NewDatabaseError("Error while creating role")
+	}
+	store.roles[role.Name] = role
+	return nil
+}
+
+func (store *InMemoryStore) DeleteRole(roleName string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, user := range store.users {
+		if contains(user.account.Roles, roleName) {
+			return exceptions.NewValidationError("role " + roleName + " is still assigned to users")
+		}
+	}
+	for grant := range store.grants {
+		if grant.role == roleName {
+			delete(store.grants, grant)
+		}
+	}
+	for parent := range store.parents {
+		if parent.role == roleName || parent.parent == roleName {
+			delete(store.parents, parent)
+		}
+	}
+	delete(store.roles, roleName)
+	return nil
+}
+
+func (store *InMemoryStore) FindPermissions() ([]Permission, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	permissions := make([]Permission, 0, len(store.permissions))
+	for _, permission := range store.permissions {
+		permissions = append(permissions, permission)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].Name < permissions[j].Name
+	})
+	return permissions, nil
+}
+
+func (store *InMemoryStore) CreatePermission(permission Permission) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.permissions[permission.Name]; ok {
+		return exceptions.NewDatabaseError("Error while creating permission")
+	}
+	store.permissions[permission.Name] = permission
+	return nil
+}
+
+func (store *InMemoryStore) UpdatePermission(permission Permission) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.permissions[permission.Name]; ok {
+		store.permissions[permission.Name] = permission
+	}
+	return nil
+}
+
+func (store *InMemoryStore) DeletePermission(permissionName string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for grant := range store.grants {
+		if grant.permission == permissionName {
+			delete(store.grants, grant)
+		}
+	}
+	delete(store.permissions, permissionName)
+	return nil
+}
+
+func (store *InMemoryStore) GrantPermission(roleName string, permissionName string, effect string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	grant := inMemoryGrant{roleName, permissionName, effect}
+	if store.grants[grant] {
+		return exceptions.NewDatabaseError("Error while granting permission")
+	}
+	store.grants[grant] = true
+	return nil
+}
+
+func (store *InMemoryStore) RevokePermission(roleName string, permissionName string, effect string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.grants, inMemoryGrant{roleName, permissionName, effect})
+	return nil
+}
+
+func (store *InMemoryStore) AddParentRole(roleName string, parentRoleName string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	parent := inMemoryParent{roleName, parentRoleName}
+	if store.parents[parent] {
+		return exceptions.NewDatabaseError("Error while adding parent role")
+	}
+	store.parents[parent] = true
+	return nil
+}
+
+func (store *InMemoryStore) RemoveParentRole(roleName string, parentRoleName string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.parents, inMemoryParent{roleName, parentRoleName})
+	return nil
+}
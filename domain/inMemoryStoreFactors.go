@@ -0,0 +1,255 @@
+package domain
+
+import (
+	"github.com/barnettt/banking-lib/exceptions"
+	"time"
+)
+
+func (store *InMemoryStore) FindTotp(userName string) (*TotpEnrollment, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	enrollment, ok := store.totp[userName]
+	if !ok {
+		return nil, nil
+	}
+	found := *enrollment
+	return &found, nil
+}
+
+func (store *InMemoryStore) SaveTotp(enrollment TotpEnrollment) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.totp[enrollment.UserName] = &TotpEnrollment{UserName: enrollment.UserName, Secret: enrollment.Secret}
+	return nil
+}
+
+func (store *InMemoryStore) SavePendingTotp(userName string, secret string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if enrollment, ok := store.totp[userName]; ok {
+		enrollment.PendingSecret = secret
+	}
+	return nil
+}
+
+func (store *InMemoryStore) ConfirmTotp(userName string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if enrollment, ok := store.totp[userName]; ok {
+		if enrollment.PendingSecret != "" {
+			enrollment.Secret = enrollment.PendingSecret
+			enrollment.PendingSecret = ""
+		}
+		enrollment.Confirmed = true
+	}
+	return nil
+}
+
+func (store *InMemoryStore) UseTotpStep(userName string, step int64) (bool, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	enrollment, ok := store.totp[userName]
+	if !ok || enrollment.LastUsedStep >= step {
+		return false, nil
+	}
+	enrollment.LastUsedStep = step
+	return true, nil
+}
+
+func (store *InMemoryStore) SaveRecoveryCodes(userName string, codeHashes []string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	// the value records whether the code has been used
+	codes := make(map[string]bool, len(codeHashes))
+	for _, codeHash := range codeHashes {
+		codes[codeHash] = false
+	}
+	store.recoveryCodes[userName] = codes
+	return nil
+}
+
+func (store *InMemoryStore) UseRecoveryCode(userName string, codeHash string) (bool, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	used, ok := store.recoveryCodes[userName][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	store.recoveryCodes[userName][codeHash] = true
+	return true, nil
+}
+
+func (store *InMemoryStore) SaveMfaChallenge(jti string, userName string, expiresAt int64) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now().Unix()
+	for stored, challenge := range store.mfaChallenges {
+		if challenge.expiresAt < now {
+			delete(store.mfaChallenges, stored)
+		}
+	}
+	store.mfaChallenges[jti] = &inMemoryUserToken{userName: userName, expiresAt: expiresAt}
+	return nil
+}
+
+func (store *InMemoryStore) ConsumeMfaChallenge(jti string) (bool, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	challenge, ok := store.mfaChallenges[jti]
+	if !ok || challenge.used || challenge.expiresAt < time.Now().Unix() {
+		return false, nil
+	}
+	challenge.used = true
+	return true, nil
+}
+
+func (store *InMemoryStore) SaveChallenge(challenge WebAuthnChallenge) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for stored, outstanding := range store.challenges {
+		if outstanding.HasExpired() {
+			delete(store.challenges, stored)
+		}
+	}
+	store.challenges[challenge.Challenge] = challenge
+	return nil
+}
+
+func (store *InMemoryStore) ConsumeChallenge(challenge string) (*WebAuthnChallenge, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	stored, ok := store.challenges[challenge]
+	if !ok {
+		return nil, exceptions.NewUnauthorisedError("unknown webauthn challenge")
+	}
+	delete(store.challenges, challenge)
+	return &stored, nil
+}
+
+func (store *InMemoryStore) FindCredentials(userName string) ([]WebAuthnCredential, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	credentials := make([]WebAuthnCredential, 0)
+	for _, credential := range store.credentials {
+		if credential.UserName == userName {
+			credentials = append(credentials, *credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (store *InMemoryStore) FindCredential(credentialId string) (*WebAuthnCredential, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	credential, ok := store.credentials[credentialId]
+	if !ok {
+		return nil, exceptions.NewUnauthorisedError("unknown webauthn credential")
+	}
+	found := *credential
+	return &found, nil
+}
+
+func (store *InMemoryStore) SaveCredential(credential WebAuthnCredential) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.credentials[credential.CredentialId]; ok {
+		return exceptions.NewDatabaseError("Error while storing webauthn credential")
+	}
+	store.credentials[credential.CredentialId] = &credential
+	return nil
+}
+
+func (store *InMemoryStore) UpdateSignCount(credentialId string, signCount int64) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if credential, ok := store.credentials[credentialId]; ok {
+		credential.SignCount = signCount
+	}
+	return nil
+}
+
+func (store *InMemoryStore) FindLoginAttempts(attemptKey string) (*LoginAttempts, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	attempts, ok := store.loginAttempts[attemptKey]
+	if !ok {
+		return nil, nil
+	}
+	found := *attempts
+	return &found, nil
+}
+
+func (store *InMemoryStore) RecordLoginFailure(attemptKey string, failedAt int64, windowStart int64) (*LoginAttempts, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	attempts, ok := store.loginAttempts[attemptKey]
+	if !ok {
+		attempts = &LoginAttempts{AttemptKey: attemptKey}
+		store.loginAttempts[attemptKey] = attempts
+	}
+	if attempts.LastFailureAt < windowStart {
+		attempts.Failures = 1
+	} else {
+		attempts.Failures++
+	}
+	attempts.LastFailureAt = failedAt
+	found := *attempts
+	return &found, nil
+}
+
+func (store *InMemoryStore) LockLogin(attemptKey string, lockedUntil int64) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if attempts, ok := store.loginAttempts[attemptKey]; ok {
+		attempts.Failures = 0
+		attempts.LockedUntil = lockedUntil
+	}
+	return nil
+}
+
+func (store *InMemoryStore) ResetLoginAttempts(attemptKey string) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.loginAttempts, attemptKey)
+	return nil
+}
+
+func (store *InMemoryStore) FindClient(clientId string) (*Client, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	client, ok := store.clients[clientId]
+	if !ok {
+		return nil, exceptions.NewUnauthorisedError("client not registered")
+	}
+	return &client, nil
+}
+
+func (store *InMemoryStore) SaveAuthorizationCode(code AuthorizationCode) *exceptions.AppError {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	// codes are short lived, drop the ones nobody redeemed
+	expiredBefore := time.Now().Add(-time.Hour).Unix()
+	for codeHash, stored := range store.authorizationCodes {
+		if stored.ExpiresAt < expiredBefore {
+			delete(store.authorizationCodes, codeHash)
+		}
+	}
+	code.Used = false
+	store.authorizationCodes[code.CodeHash] = &code
+	return nil
+}
+
+func (store *InMemoryStore) ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, *exceptions.AppError) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	code, ok := store.authorizationCodes[codeHash]
+	if !ok {
+		return nil, exceptions.NewValidationError("authorization code not found")
+	}
+	if code.Used {
+		return nil, exceptions.NewValidationError("authorization code already used")
+	}
+	found := *code
+	code.Used = true
+	return &found, nil
+}
@@ -0,0 +1,159 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/barnettt/banking-lib/logger"
+	"github.com/golang-jwt/jwt"
+	"sync"
+	"time"
+)
+
+type ringKey struct {
+	key         SigningKey
+	activatesAt time.Time
+	// zero until the key is replaced as the signing key
+	retiredAt time.Time
+}
+
+// KeyRing holds one current signing key and every key that may still verify tokens.
+// A replaced signing key keeps verifying for the grace period so rotating keys never
+// invalidates the access and refresh tokens already handed out.
+type KeyRing struct {
+	mutex       sync.RWMutex
+	keys        map[string]*ringKey
+	currentId   string
+	gracePeriod time.Duration
+}
+
+// SigningKey returns the current signing key, promoting any scheduled key whose activation time has passed
+func (keyRing *KeyRing) SigningKey() SigningKey {
+	keyRing.mutex.Lock()
+	defer keyRing.mutex.Unlock()
+	keyRing.promoteScheduledKeys(time.Now())
+	return keyRing.keys[keyRing.currentId].key
+}
+
+// Keyfunc resolves the verification key from the token's kid header. No key is registered under the
+// empty id, so tokens issued before kids existed, which were signed with the public built in secret, are refused
+func (keyRing *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	keyRing.mutex.RLock()
+	entry, ok := keyRing.keys[kid]
+	expired := ok && keyRing.isExpired(entry, time.Now())
+	keyRing.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+	if expired {
+		return nil, fmt.Errorf("key %s is past its grace period", kid)
+	}
+	return entry.key.Keyfunc(token)
+}
+
+// VerificationKeys lists every key that can currently verify a token, including
+// scheduled keys so they are published before they start signing
+func (keyRing *KeyRing) VerificationKeys() []SigningKey {
+	keyRing.mutex.Lock()
+	defer keyRing.mutex.Unlock()
+	now := time.Now()
+	keyRing.promoteScheduledKeys(now)
+	keyRing.pruneExpiredKeys(now)
+	keys := make([]SigningKey, 0, len(keyRing.keys))
+	for _, entry := range keyRing.keys {
+		keys = append(keys, entry.key)
+	}
+	return keys
+}
+
+// AddVerificationKey registers a key that only verifies, retiredAt starts its grace period
+func (keyRing *KeyRing) AddVerificationKey(key SigningKey, retiredAt time.Time) {
+	keyRing.mutex.Lock()
+	defer keyRing.mutex.Unlock()
+	keyRing.keys[key.KeyId] = &ringKey{key: key, retiredAt: retiredAt}
+}
+
+// Rotate makes the key the signing key immediately
+func (keyRing *KeyRing) Rotate(key SigningKey) {
+	keyRing.ScheduleRotation(key, time.Now())
+}
+
+// ScheduleRotation adds the key as a verification key now and makes it the signing key at activatesAt
+func (keyRing *KeyRing) ScheduleRotation(key SigningKey, activatesAt time.Time) {
+	keyRing.mutex.Lock()
+	defer keyRing.mutex.Unlock()
+	keyRing.keys[key.KeyId] = &ringKey{key: key, activatesAt: activatesAt}
+	keyRing.promoteScheduledKeys(time.Now())
+}
+
+// StartAutoRotation generates a new key every interval. Generated keys only live in this
+// process so automatic rotation suits single instance deployments, multiple instances
+// should schedule rotations of shared key files instead. Calling the returned func stops the rotation.
+func (keyRing *KeyRing) StartAutoRotation(interval time.Duration, algorithm string) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				key, err := GenerateSigningKey(algorithm)
+				if err != nil {
+					logger.Error("Unable to generate signing key : " + err.Error())
+					continue
+				}
+				keyRing.Rotate(*key)
+				logger.Info("Rotated signing key, new key id : " + key.KeyId)
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// promoteScheduledKeys must be called holding the write lock
+func (keyRing *KeyRing) promoteScheduledKeys(now time.Time) {
+	newestId := keyRing.currentId
+	for id, entry := range keyRing.keys {
+		if !entry.retiredAt.IsZero() || entry.activatesAt.After(now) {
+			continue
+		}
+		if entry.activatesAt.After(keyRing.keys[newestId].activatesAt) {
+			newestId = id
+		}
+	}
+	if newestId == keyRing.currentId {
+		return
+	}
+	// the replaced key starts its grace period from the moment the new key took over
+	retiredAt := keyRing.keys[newestId].activatesAt
+	for id, entry := range keyRing.keys {
+		if id != newestId && entry.retiredAt.IsZero() && !entry.activatesAt.After(now) {
+			entry.retiredAt = retiredAt
+		}
+	}
+	keyRing.currentId = newestId
+}
+
+// pruneExpiredKeys must be called holding the write lock
+func (keyRing *KeyRing) pruneExpiredKeys(now time.Time) {
+	for id, entry := range keyRing.keys {
+		if keyRing.isExpired(entry, now) {
+			delete(keyRing.keys, id)
+		}
+	}
+}
+
+func (keyRing *KeyRing) isExpired(entry *ringKey, now time.Time) bool {
+	return !entry.retiredAt.IsZero() && now.After(entry.retiredAt.Add(keyRing.gracePeriod))
+}
+
+func NewKeyRing(signingKey SigningKey, gracePeriod time.Duration) *KeyRing {
+	return &KeyRing{
+		keys:        map[string]*ringKey{signingKey.KeyId: {key: signingKey}},
+		currentId:   signingKey.KeyId,
+		gracePeriod: gracePeriod,
+	}
+}
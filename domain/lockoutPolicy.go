@@ -0,0 +1,57 @@
+package domain
+
+import "time"
+
+// LockoutPolicy throttles password guessing. Every failure makes the next attempt wait longer,
+// doubling from BaseDelay up to MaxDelay, and a run of failures within LockoutDuration of each
+// other locks the user name or client ip for LockoutDuration
+type LockoutPolicy struct {
+	MaxUserFailures int
+	// a single ip legitimately serves many users, an office or a carrier nat, so it gets a higher limit
+	MaxIpFailures   int
+	LockoutDuration time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+}
+
+// Delay is how long to wait after the given number of consecutive failures
+func (policy LockoutPolicy) Delay(failures int) time.Duration {
+	if failures <= 0 || policy.BaseDelay <= 0 {
+		return 0
+	}
+	delay := policy.BaseDelay
+	for i := 1; i < failures && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		return policy.MaxDelay
+	}
+	return delay
+}
+
+// RetryAt is the earliest time another login attempt is accepted for the key
+func (policy LockoutPolicy) RetryAt(attempts LoginAttempts) time.Time {
+	retryAt := time.Unix(attempts.LastFailureAt, 0).Add(policy.Delay(attempts.Failures))
+	if lockedUntil := time.Unix(attempts.LockedUntil, 0); lockedUntil.After(retryAt) {
+		return lockedUntil
+	}
+	return retryAt
+}
+
+func UserAttemptKey(userName string) string {
+	return "user:" + userName
+}
+
+func IpAttemptKey(clientIp string) string {
+	return "ip:" + clientIp
+}
+
+func NewLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		MaxUserFailures: 5,
+		MaxIpFailures:   20,
+		LockoutDuration: time.Minute * 15,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Second * 30,
+	}
+}
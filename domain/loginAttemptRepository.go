@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"database/sql"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+)
+
+// LoginAttempts counts the recent failed logins for an attempt key, which is either a user name
+// or a client ip, see UserAttemptKey and IpAttemptKey
+type LoginAttempts struct {
+	AttemptKey    string `db:"attempt_key"`
+	Failures      int    `db:"failures"`
+	LastFailureAt int64  `db:"last_failure_at"`
+	LockedUntil   int64  `db:"locked_until"`
+}
+
+type LoginAttemptRepository interface {
+	// FindLoginAttempts returns nil when the key has no recorded failures
+	FindLoginAttempts(attemptKey string) (*LoginAttempts, *exceptions.AppError)
+	// RecordLoginFailure adds a failure, restarting the count when the previous failure was before windowStart
+	RecordLoginFailure(attemptKey string, failedAt int64, windowStart int64) (*LoginAttempts, *exceptions.AppError)
+	LockLogin(attemptKey string, lockedUntil int64) *exceptions.AppError
+	ResetLoginAttempts(attemptKey string) *exceptions.AppError
+}
+
+type LoginAttemptRepositoryDB struct {
+	client *Database
+}
+
+func (repository LoginAttemptRepositoryDB) FindLoginAttempts(attemptKey string) (*LoginAttempts, *exceptions.AppError) {
+	selectQuery := "SELECT attempt_key, failures, last_failure_at, locked_until FROM login_attempts WHERE attempt_key = ?"
+	var attempts LoginAttempts
+	err := repository.client.Get(&attempts, selectQuery, attemptKey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return &attempts, nil
+}
+
+func (repository LoginAttemptRepositoryDB) RecordLoginFailure(attemptKey string, failedAt int64, windowStart int64) (*LoginAttempts, *exceptions.AppError) {
+	updateQuery := "UPDATE login_attempts SET failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END, " +
+		"last_failure_at = ? WHERE attempt_key = ?"
+	result, err := repository.client.Exec(updateQuery, windowStart, failedAt, attemptKey)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, exceptions.NewDatabaseError("Error while recording failed login")
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		insertQuery := "INSERT INTO login_attempts (attempt_key, failures, last_failure_at, locked_until) VALUES (?, 1, ?, 0)"
+		if _, err = repository.client.Exec(insertQuery, attemptKey, failedAt); err != nil {
+			logger.Error(err.Error())
+			return nil, exceptions.NewDatabaseError("Error while recording failed login")
+		}
+	}
+	return repository.FindLoginAttempts(attemptKey)
+}
+
+// LockLogin locks the key and restarts its failure count, so once the lock expires a fresh run of failures is needed to lock it again
+func (repository LoginAttemptRepositoryDB) LockLogin(attemptKey string, lockedUntil int64) *exceptions.AppError {
+	_, err := repository.client.Exec("UPDATE login_attempts SET failures = 0, locked_until = ? WHERE attempt_key = ?",
+		lockedUntil, attemptKey)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while locking login")
+	}
+	return nil
+}
+
+func (repository LoginAttemptRepositoryDB) ResetLoginAttempts(attemptKey string) *exceptions.AppError {
+	if _, err := repository.client.Exec("DELETE FROM login_attempts WHERE attempt_key = ?", attemptKey); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while resetting login attempts")
+	}
+	return nil
+}
+
+func NewLoginAttemptRepository(client *Database) LoginAttemptRepositoryDB {
+	return LoginAttemptRepositoryDB{client}
+}
@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/barnettt/banking-lib/logger"
+	"net/smtp"
+	"strings"
+)
+
+// MailSender delivers the emails the service sends to users, such as verification and reset links
+type MailSender interface {
+	Send(to string, subject string, body string) error
+}
+
+type SmtpMailSender struct {
+	address string
+	auth    smtp.Auth
+	from    string
+}
+
+func (sender SmtpMailSender) Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+	message := "From: " + sender.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+	return smtp.SendMail(sender.address, sender.auth, sender.from, []string{to}, []byte(message))
+}
+
+// LogMailSender only logs that a mail would have been sent, the body is left out because it
+// carries single use tokens. It stands in when no smtp server is configured
+type LogMailSender struct{}
+
+func (sender LogMailSender) Send(to string, subject string, body string) error {
+	logger.Info("smtp is not configured, mail to " + to + " not sent : " + subject)
+	return nil
+}
+
+// NewSmtpMailSender authenticates with PLAIN auth when a user is given, net/smtp refuses it over unencrypted connections
+func NewSmtpMailSender(host string, port string, user string, password string, from string) SmtpMailSender {
+	var auth smtp.Auth
+	if user != "" {
+		auth = smtp.PlainAuth("", user, password, host)
+	}
+	return SmtpMailSender{address: host + ":" + port, auth: auth, from: from}
+}
@@ -0,0 +1,186 @@
+package domain
+
+import (
+	"database/sql"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"time"
+)
+
+// TotpEnrollment is a row of user_mfa, an enrollment only protects logins once it has been
+// confirmed with a valid code
+type TotpEnrollment struct {
+	UserName     string `db:"user_name"`
+	Secret       string `db:"totp_secret"`
+	Confirmed    bool   `db:"confirmed"`
+	LastUsedStep int64  `db:"last_used_step"`
+	// the secret of a re-enrollment, empty unless the user is replacing a confirmed enrollment
+	PendingSecret string `db:"pending_totp_secret"`
+}
+
+type MfaRepository interface {
+	// FindTotp returns nil when the user has not enrolled
+	FindTotp(userName string) (*TotpEnrollment, *exceptions.AppError)
+	SaveTotp(enrollment TotpEnrollment) *exceptions.AppError
+	// SavePendingTotp keeps the secret next to the confirmed one until ConfirmTotp swaps it in
+	SavePendingTotp(userName string, secret string) *exceptions.AppError
+	ConfirmTotp(userName string) *exceptions.AppError
+	// UseTotpStep records the step of an accepted code, false means the step was already used
+	UseTotpStep(userName string, step int64) (bool, *exceptions.AppError)
+	SaveRecoveryCodes(userName string, codeHashes []string) *exceptions.AppError
+	UseRecoveryCode(userName string, codeHash string) (bool, *exceptions.AppError)
+	// SaveMfaChallenge records an issued challenge token so it can only be exchanged for tokens once
+	SaveMfaChallenge(jti string, userName string, expiresAt int64) *exceptions.AppError
+	// ConsumeMfaChallenge marks the challenge used, false means it is unknown, expired or already used
+	ConsumeMfaChallenge(jti string) (bool, *exceptions.AppError)
+}
+
+type MfaRepositoryDB struct {
+	client *Database
+}
+
+func (repository MfaRepositoryDB) FindTotp(userName string) (*TotpEnrollment, *exceptions.AppError) {
+	selectQuery := "SELECT user_name, totp_secret, confirmed, last_used_step, pending_totp_secret FROM user_mfa " +
+		"WHERE user_name = ?"
+	var enrollment TotpEnrollment
+	err := repository.client.Get(&enrollment, selectQuery, userName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return &enrollment, nil
+}
+
+// SaveTotp replaces any previous enrollment of the user, a confirmed one is replaced through SavePendingTotp
+func (repository MfaRepositoryDB) SaveTotp(enrollment TotpEnrollment) *exceptions.AppError {
+	tx, err := repository.client.Beginx()
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM user_mfa WHERE user_name = ?", enrollment.UserName)
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO user_mfa (user_name, totp_secret, confirmed, last_used_step) VALUES (?, ?, 0, 0)",
+				enrollment.UserName, enrollment.Secret)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while storing totp enrollment")
+	}
+	return nil
+}
+
+func (repository MfaRepositoryDB) SavePendingTotp(userName string, secret string) *exceptions.AppError {
+	_, err := repository.client.Exec("UPDATE user_mfa SET pending_totp_secret = ? WHERE user_name = ?", secret, userName)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while storing totp enrollment")
+	}
+	return nil
+}
+
+// ConfirmTotp enables the enrollment, a pending secret replaces the confirmed one
+func (repository MfaRepositoryDB) ConfirmTotp(userName string) *exceptions.AppError {
+	updateQuery := "UPDATE user_mfa SET totp_secret = CASE WHEN pending_totp_secret <> '' THEN pending_totp_secret " +
+		"ELSE totp_secret END, pending_totp_secret = '', confirmed = 1 WHERE user_name = ?"
+	_, err := repository.client.Exec(updateQuery, userName)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while confirming totp enrollment")
+	}
+	return nil
+}
+
+func (repository MfaRepositoryDB) UseTotpStep(userName string, step int64) (bool, *exceptions.AppError) {
+	result, err := repository.client.Exec("UPDATE user_mfa SET last_used_step = ? WHERE user_name = ? AND last_used_step < ?",
+		step, userName, step)
+	if err != nil {
+		logger.Error(err.Error())
+		return false, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		logger.Error(err.Error())
+		return false, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return rows == 1, nil
+}
+
+func (repository MfaRepositoryDB) SaveRecoveryCodes(userName string, codeHashes []string) *exceptions.AppError {
+	tx, err := repository.client.Beginx()
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_name = ?", userName)
+		for _, codeHash := range codeHashes {
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec("INSERT INTO mfa_recovery_codes (user_name, code_hash, used) VALUES (?, ?, 0)",
+				userName, codeHash)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while storing recovery codes")
+	}
+	return nil
+}
+
+func (repository MfaRepositoryDB) UseRecoveryCode(userName string, codeHash string) (bool, *exceptions.AppError) {
+	result, err := repository.client.Exec("UPDATE mfa_recovery_codes SET used = 1 "+
+		"WHERE user_name = ? AND code_hash = ? AND used = 0", userName, codeHash)
+	if err != nil {
+		logger.Error(err.Error())
+		return false, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		logger.Error(err.Error())
+		return false, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return rows == 1, nil
+}
+
+func (repository MfaRepositoryDB) SaveMfaChallenge(jti string, userName string, expiresAt int64) *exceptions.AppError {
+	// challenges nobody completed are dropped as new ones are issued
+	_, err := repository.client.Exec("DELETE FROM mfa_challenges WHERE expires_at < ?", time.Now().Unix())
+	if err == nil {
+		_, err = repository.client.Exec("INSERT INTO mfa_challenges (jti, user_name, expires_at, used) VALUES (?, ?, ?, 0)",
+			jti, userName, expiresAt)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while storing mfa challenge")
+	}
+	return nil
+}
+
+// ConsumeMfaChallenge uses the used = 0 guard so concurrent exchanges of the same challenge race safely
+func (repository MfaRepositoryDB) ConsumeMfaChallenge(jti string) (bool, *exceptions.AppError) {
+	result, err := repository.client.Exec("UPDATE mfa_challenges SET used = 1 WHERE jti = ? AND used = 0 AND expires_at >= ?",
+		jti, time.Now().Unix())
+	if err != nil {
+		logger.Error(err.Error())
+		return false, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		logger.Error(err.Error())
+		return false, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return rows == 1, nil
+}
+
+func NewMfaRepository(client *Database) MfaRepositoryDB {
+	return MfaRepositoryDB{client}
+}
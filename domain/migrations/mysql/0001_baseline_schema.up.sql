@@ -0,0 +1,26 @@
+-- the tables as they were before migrations existed, deployments from then already have them and
+-- IF NOT EXISTS adopts them as they are. 0002 brings them up to date. The baseline shape is
+--
+--   Accounts            (account_id, customer_id)
+--   USERS               (username, password, role, customer_id, created_on)
+--   refresh_token_store (refresh_token)
+
+-- Accounts belongs to the banking service, it is only created here so the auth service can run
+-- against a database of its own
+CREATE TABLE IF NOT EXISTS Accounts (
+    account_id  BIGINT NOT NULL PRIMARY KEY,
+    customer_id BIGINT NOT NULL,
+    INDEX accounts_customer_id (customer_id)
+) ENGINE = InnoDB;
+
+CREATE TABLE IF NOT EXISTS USERS (
+    username    VARCHAR(64)  NOT NULL PRIMARY KEY,
+    password    VARCHAR(255) NOT NULL,
+    role        VARCHAR(64)  NOT NULL DEFAULT '',
+    customer_id BIGINT       NULL,
+    created_on  DATETIME     NULL
+) ENGINE = InnoDB;
+
+CREATE TABLE IF NOT EXISTS refresh_token_store (
+    refresh_token VARCHAR(1000) CHARACTER SET ascii NOT NULL PRIMARY KEY
+) ENGINE = InnoDB;
@@ -0,0 +1,36 @@
+-- drops the tables 0002 created and the columns it added, the baseline tables and their rows stay
+
+-- the baseline reads the role from USERS, so each user keeps one of their roles there
+UPDATE USERS SET role = COALESCE((SELECT MIN(ur.role_name) FROM user_roles ur WHERE ur.user_name = USERS.username), '');
+
+DROP TABLE role_parents;
+DROP TABLE role_permissions;
+DROP TABLE permissions;
+DROP TABLE roles;
+DROP TABLE webauthn_credentials;
+DROP TABLE webauthn_challenges;
+DROP TABLE mfa_challenges;
+DROP TABLE mfa_recovery_codes;
+DROP TABLE user_mfa;
+DROP TABLE authorization_codes;
+DROP TABLE oauth_clients;
+DROP TABLE login_attempts;
+DROP TABLE revoked_tokens;
+DROP TABLE password_reset_tokens;
+DROP TABLE email_verification_tokens;
+DROP TABLE password_history;
+DROP TABLE user_roles;
+
+-- the widened password and refresh_token columns are left as they are, the baseline lengths are not known
+ALTER TABLE refresh_token_store
+    DROP INDEX refresh_token_store_token_family,
+    DROP INDEX refresh_token_store_user_name,
+    DROP COLUMN token_family,
+    DROP COLUMN user_name,
+    DROP COLUMN used,
+    DROP COLUMN revoked;
+ALTER TABLE USERS
+    DROP INDEX users_email,
+    DROP COLUMN email,
+    DROP COLUMN status,
+    DROP COLUMN password_changed_at;
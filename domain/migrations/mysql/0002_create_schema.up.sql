@@ -0,0 +1,166 @@
+-- brings the baseline tables of 0001 up to date and creates every other table the auth service
+-- reads or writes
+
+ALTER TABLE USERS
+    MODIFY password VARCHAR(255) NOT NULL,
+    ADD COLUMN email VARCHAR(255) NULL,
+    ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'active',
+    ADD COLUMN password_changed_at BIGINT NULL,
+    ADD INDEX users_email (email);
+
+-- refresh tokens are JWTs, ascii keeps the key within the index size limit
+ALTER TABLE refresh_token_store
+    MODIFY refresh_token VARCHAR(1000) CHARACTER SET ascii NOT NULL,
+    ADD COLUMN token_family VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN user_name VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN used SMALLINT NOT NULL DEFAULT 0,
+    ADD COLUMN revoked SMALLINT NOT NULL DEFAULT 0,
+    ADD INDEX refresh_token_store_token_family (token_family),
+    ADD INDEX refresh_token_store_user_name (user_name);
+
+-- refresh tokens issued before token families existed can't be rotated safely, their users log in again
+UPDATE refresh_token_store SET revoked = 1 WHERE token_family = '';
+
+CREATE TABLE user_roles (
+    user_name VARCHAR(64) NOT NULL,
+    role_name VARCHAR(64) NOT NULL,
+    PRIMARY KEY (user_name, role_name),
+    INDEX user_roles_role_name (role_name)
+) ENGINE = InnoDB;
+-- user_roles is the only source of roles, the single role users had on USERS is copied over
+INSERT INTO user_roles (user_name, role_name) SELECT username, role FROM USERS WHERE role IS NOT NULL AND role <> '';
+
+-- created_at is in milliseconds so passwords changed in the same second are still trimmed oldest first
+CREATE TABLE password_history (
+    user_name     VARCHAR(64)  NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at    BIGINT       NOT NULL,
+    INDEX password_history_user_name (user_name, created_at)
+) ENGINE = InnoDB;
+
+CREATE TABLE email_verification_tokens (
+    token_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_name  VARCHAR(64) NOT NULL,
+    expires_at BIGINT      NOT NULL,
+    used       SMALLINT    NOT NULL DEFAULT 0,
+    INDEX email_verification_tokens_user_name (user_name)
+) ENGINE = InnoDB;
+
+CREATE TABLE password_reset_tokens (
+    token_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_name  VARCHAR(64) NOT NULL,
+    expires_at BIGINT      NOT NULL,
+    used       SMALLINT    NOT NULL DEFAULT 0,
+    INDEX password_reset_tokens_user_name (user_name)
+) ENGINE = InnoDB;
+
+-- a row without a jti revokes every access token issued to the user before revoked_at. revoked_at is in
+-- milliseconds so a token issued in the same second as a revocation is only rejected when it came first
+CREATE TABLE revoked_tokens (
+    jti        VARCHAR(64) NULL,
+    user_name  VARCHAR(64) NOT NULL,
+    revoked_at BIGINT      NOT NULL,
+    expires_at BIGINT      NOT NULL,
+    INDEX revoked_tokens_jti (jti),
+    INDEX revoked_tokens_user_name (user_name, revoked_at),
+    INDEX revoked_tokens_expires_at (expires_at)
+) ENGINE = InnoDB;
+
+CREATE TABLE login_attempts (
+    attempt_key     VARCHAR(255) NOT NULL PRIMARY KEY,
+    failures        INT          NOT NULL DEFAULT 0,
+    last_failure_at BIGINT       NOT NULL DEFAULT 0,
+    locked_until    BIGINT       NOT NULL DEFAULT 0
+) ENGINE = InnoDB;
+
+CREATE TABLE oauth_clients (
+    client_id      VARCHAR(64)   NOT NULL PRIMARY KEY,
+    -- empty for public clients
+    client_secret  VARCHAR(255)  NOT NULL DEFAULT '',
+    client_name    VARCHAR(255)  NOT NULL DEFAULT '',
+    allowed_scopes VARCHAR(1024) NOT NULL DEFAULT '',
+    token_lifetime BIGINT        NOT NULL DEFAULT 0,
+    redirect_uris  VARCHAR(2048) NOT NULL DEFAULT ''
+) ENGINE = InnoDB;
+
+CREATE TABLE authorization_codes (
+    code_hash      VARCHAR(64)   NOT NULL PRIMARY KEY,
+    client_id      VARCHAR(64)   NOT NULL,
+    user_name      VARCHAR(64)   NOT NULL,
+    redirect_uri   VARCHAR(2048) NOT NULL,
+    scope          VARCHAR(1024) NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128)  NOT NULL DEFAULT '',
+    nonce          VARCHAR(255)  NOT NULL DEFAULT '',
+    auth_time      BIGINT        NOT NULL,
+    expires_at     BIGINT        NOT NULL,
+    used           SMALLINT      NOT NULL DEFAULT 0,
+    INDEX authorization_codes_expires_at (expires_at)
+) ENGINE = InnoDB;
+
+-- a re-enrollment waits in pending_totp_secret until confirmed, the confirmed secret keeps protecting logins
+CREATE TABLE user_mfa (
+    user_name           VARCHAR(64)  NOT NULL PRIMARY KEY,
+    totp_secret         VARCHAR(255) NOT NULL,
+    confirmed           SMALLINT     NOT NULL DEFAULT 0,
+    last_used_step      BIGINT       NOT NULL DEFAULT 0,
+    pending_totp_secret VARCHAR(255) NOT NULL DEFAULT ''
+) ENGINE = InnoDB;
+
+CREATE TABLE mfa_recovery_codes (
+    user_name VARCHAR(64)  NOT NULL,
+    code_hash VARCHAR(255) NOT NULL,
+    used      SMALLINT     NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_name, code_hash)
+) ENGINE = InnoDB;
+
+-- issued mfa challenge tokens, each can only be exchanged for tokens once
+CREATE TABLE mfa_challenges (
+    jti        VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_name  VARCHAR(64) NOT NULL,
+    expires_at BIGINT      NOT NULL,
+    used       SMALLINT    NOT NULL DEFAULT 0,
+    INDEX mfa_challenges_expires_at (expires_at)
+) ENGINE = InnoDB;
+
+CREATE TABLE webauthn_challenges (
+    challenge  VARCHAR(128) NOT NULL PRIMARY KEY,
+    -- empty for usernameless passkey logins
+    user_name  VARCHAR(64)  NOT NULL DEFAULT '',
+    ceremony   VARCHAR(32)  NOT NULL,
+    expires_at BIGINT       NOT NULL,
+    INDEX webauthn_challenges_expires_at (expires_at)
+) ENGINE = InnoDB;
+
+CREATE TABLE webauthn_credentials (
+    credential_id VARCHAR(1400) CHARACTER SET ascii NOT NULL PRIMARY KEY,
+    user_name     VARCHAR(64) NOT NULL,
+    public_key    BLOB        NOT NULL,
+    sign_count    BIGINT      NOT NULL DEFAULT 0,
+    INDEX webauthn_credentials_user_name (user_name)
+) ENGINE = InnoDB;
+
+CREATE TABLE roles (
+    role_name   VARCHAR(64)  NOT NULL PRIMARY KEY,
+    description VARCHAR(255) NULL
+) ENGINE = InnoDB;
+
+CREATE TABLE permissions (
+    permission_name VARCHAR(128) NOT NULL PRIMARY KEY,
+    description     VARCHAR(255) NULL,
+    -- the OAuth scope a token needs to call the operation
+    scope           VARCHAR(128) NULL
+) ENGINE = InnoDB;
+
+-- permission_name may be a wildcard pattern so it does not reference permissions
+CREATE TABLE role_permissions (
+    role_name       VARCHAR(64)  NOT NULL,
+    permission_name VARCHAR(128) NOT NULL,
+    effect          VARCHAR(8)   NOT NULL DEFAULT 'allow',
+    PRIMARY KEY (role_name, permission_name, effect)
+) ENGINE = InnoDB;
+
+CREATE TABLE role_parents (
+    role_name        VARCHAR(64) NOT NULL,
+    parent_role_name VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role_name, parent_role_name)
+) ENGINE = InnoDB;
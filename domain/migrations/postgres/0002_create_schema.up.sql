@@ -0,0 +1,165 @@
+-- brings the baseline tables of 0001 up to date and creates every other table the auth service
+-- reads or writes
+
+ALTER TABLE USERS
+    ALTER COLUMN password TYPE VARCHAR(255),
+    ADD COLUMN email VARCHAR(255) NULL,
+    ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'active',
+    ADD COLUMN password_changed_at BIGINT NULL;
+CREATE INDEX users_email ON USERS (email);
+
+ALTER TABLE refresh_token_store
+    ALTER COLUMN refresh_token TYPE VARCHAR(1000),
+    ADD COLUMN token_family VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN user_name VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN used SMALLINT NOT NULL DEFAULT 0,
+    ADD COLUMN revoked SMALLINT NOT NULL DEFAULT 0;
+CREATE INDEX refresh_token_store_token_family ON refresh_token_store (token_family);
+CREATE INDEX refresh_token_store_user_name ON refresh_token_store (user_name);
+
+-- refresh tokens issued before token families existed can't be rotated safely, their users log in again
+UPDATE refresh_token_store SET revoked = 1 WHERE token_family = '';
+
+CREATE TABLE user_roles (
+    user_name VARCHAR(64) NOT NULL,
+    role_name VARCHAR(64) NOT NULL,
+    PRIMARY KEY (user_name, role_name)
+);
+CREATE INDEX user_roles_role_name ON user_roles (role_name);
+-- user_roles is the only source of roles, the single role users had on USERS is copied over
+INSERT INTO user_roles (user_name, role_name) SELECT username, role FROM USERS WHERE role IS NOT NULL AND role <> '';
+
+-- created_at is in milliseconds so passwords changed in the same second are still trimmed oldest first
+CREATE TABLE password_history (
+    user_name     VARCHAR(64)  NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at    BIGINT       NOT NULL
+);
+CREATE INDEX password_history_user_name ON password_history (user_name, created_at);
+
+CREATE TABLE email_verification_tokens (
+    token_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_name  VARCHAR(64) NOT NULL,
+    expires_at BIGINT      NOT NULL,
+    used       SMALLINT    NOT NULL DEFAULT 0
+);
+CREATE INDEX email_verification_tokens_user_name ON email_verification_tokens (user_name);
+
+CREATE TABLE password_reset_tokens (
+    token_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_name  VARCHAR(64) NOT NULL,
+    expires_at BIGINT      NOT NULL,
+    used       SMALLINT    NOT NULL DEFAULT 0
+);
+CREATE INDEX password_reset_tokens_user_name ON password_reset_tokens (user_name);
+
+-- a row without a jti revokes every access token issued to the user before revoked_at. revoked_at is in
+-- milliseconds so a token issued in the same second as a revocation is only rejected when it came first
+CREATE TABLE revoked_tokens (
+    jti        VARCHAR(64) NULL,
+    user_name  VARCHAR(64) NOT NULL,
+    revoked_at BIGINT      NOT NULL,
+    expires_at BIGINT      NOT NULL
+);
+CREATE INDEX revoked_tokens_jti ON revoked_tokens (jti);
+CREATE INDEX revoked_tokens_user_name ON revoked_tokens (user_name, revoked_at);
+CREATE INDEX revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE login_attempts (
+    attempt_key     VARCHAR(255) NOT NULL PRIMARY KEY,
+    failures        INTEGER      NOT NULL DEFAULT 0,
+    last_failure_at BIGINT       NOT NULL DEFAULT 0,
+    locked_until    BIGINT       NOT NULL DEFAULT 0
+);
+
+CREATE TABLE oauth_clients (
+    client_id      VARCHAR(64)   NOT NULL PRIMARY KEY,
+    -- empty for public clients
+    client_secret  VARCHAR(255)  NOT NULL DEFAULT '',
+    client_name    VARCHAR(255)  NOT NULL DEFAULT '',
+    allowed_scopes VARCHAR(1024) NOT NULL DEFAULT '',
+    token_lifetime BIGINT        NOT NULL DEFAULT 0,
+    redirect_uris  VARCHAR(2048) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE authorization_codes (
+    code_hash      VARCHAR(64)   NOT NULL PRIMARY KEY,
+    client_id      VARCHAR(64)   NOT NULL,
+    user_name      VARCHAR(64)   NOT NULL,
+    redirect_uri   VARCHAR(2048) NOT NULL,
+    scope          VARCHAR(1024) NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128)  NOT NULL DEFAULT '',
+    nonce          VARCHAR(255)  NOT NULL DEFAULT '',
+    auth_time      BIGINT        NOT NULL,
+    expires_at     BIGINT        NOT NULL,
+    used           SMALLINT      NOT NULL DEFAULT 0
+);
+CREATE INDEX authorization_codes_expires_at ON authorization_codes (expires_at);
+
+-- a re-enrollment waits in pending_totp_secret until confirmed, the confirmed secret keeps protecting logins
+CREATE TABLE user_mfa (
+    user_name           VARCHAR(64)  NOT NULL PRIMARY KEY,
+    totp_secret         VARCHAR(255) NOT NULL,
+    confirmed           SMALLINT     NOT NULL DEFAULT 0,
+    last_used_step      BIGINT       NOT NULL DEFAULT 0,
+    pending_totp_secret VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE mfa_recovery_codes (
+    user_name VARCHAR(64)  NOT NULL,
+    code_hash VARCHAR(255) NOT NULL,
+    used      SMALLINT     NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_name, code_hash)
+);
+
+-- issued mfa challenge tokens, each can only be exchanged for tokens once
+CREATE TABLE mfa_challenges (
+    jti        VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_name  VARCHAR(64) NOT NULL,
+    expires_at BIGINT      NOT NULL,
+    used       SMALLINT    NOT NULL DEFAULT 0
+);
+CREATE INDEX mfa_challenges_expires_at ON mfa_challenges (expires_at);
+
+CREATE TABLE webauthn_challenges (
+    challenge  VARCHAR(128) NOT NULL PRIMARY KEY,
+    -- empty for usernameless passkey logins
+    user_name  VARCHAR(64)  NOT NULL DEFAULT '',
+    ceremony   VARCHAR(32)  NOT NULL,
+    expires_at BIGINT       NOT NULL
+);
+CREATE INDEX webauthn_challenges_expires_at ON webauthn_challenges (expires_at);
+
+CREATE TABLE webauthn_credentials (
+    credential_id VARCHAR(1400) NOT NULL PRIMARY KEY,
+    user_name     VARCHAR(64) NOT NULL,
+    public_key    BYTEA       NOT NULL,
+    sign_count    BIGINT      NOT NULL DEFAULT 0
+);
+CREATE INDEX webauthn_credentials_user_name ON webauthn_credentials (user_name);
+
+CREATE TABLE roles (
+    role_name   VARCHAR(64)  NOT NULL PRIMARY KEY,
+    description VARCHAR(255) NULL
+);
+
+CREATE TABLE permissions (
+    permission_name VARCHAR(128) NOT NULL PRIMARY KEY,
+    description     VARCHAR(255) NULL,
+    -- the OAuth scope a token needs to call the operation
+    scope           VARCHAR(128) NULL
+);
+
+-- permission_name may be a wildcard pattern so it does not reference permissions
+CREATE TABLE role_permissions (
+    role_name       VARCHAR(64)  NOT NULL,
+    permission_name VARCHAR(128) NOT NULL,
+    effect          VARCHAR(8)   NOT NULL DEFAULT 'allow',
+    PRIMARY KEY (role_name, permission_name, effect)
+);
+
+CREATE TABLE role_parents (
+    role_name        VARCHAR(64) NOT NULL,
+    parent_role_name VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role_name, parent_role_name)
+);
@@ -0,0 +1,27 @@
+-- the built in roles, see GetUserRolePermissions and GetOperationScopes. Rows that already exist are kept
+INSERT INTO roles (role_name, description) VALUES
+    ('admin', 'Staff managing customers and accounts'),
+    ('user', 'Customers')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO permissions (permission_name, description, scope) VALUES
+    ('GetAllActiveCustomer', NULL, 'customers:read'),
+    ('GetAllInActiveCustomer', NULL, 'customers:read'),
+    ('GetAllCustomer', NULL, 'customers:read'),
+    ('GetCustomer', NULL, 'customers:read'),
+    ('NewAccount', NULL, 'accounts:write'),
+    ('NewTransaction', NULL, 'transactions:write')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission_name, effect) VALUES
+    ('admin', 'GetAllActiveCustomer', 'allow'),
+    ('admin', 'GetAllInActiveCustomer', 'allow'),
+    ('admin', 'GetAllCustomer', 'allow'),
+    ('admin', 'NewAccount', 'allow'),
+    ('user', 'GetCustomer', 'allow'),
+    ('user', 'NewTransaction', 'allow')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_parents (role_name, parent_role_name) VALUES
+    ('admin', 'user')
+ON CONFLICT DO NOTHING;
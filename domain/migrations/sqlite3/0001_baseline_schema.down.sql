@@ -0,0 +1,3 @@
+-- 0001 can't be rolled back, the baseline tables may hold the users of a deployment that predates
+-- migrations and there is no telling whether 0001 created or adopted them. migrate down refuses to go
+-- past it as this script has no statements
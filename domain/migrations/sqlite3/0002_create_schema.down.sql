@@ -0,0 +1,42 @@
+-- drops the tables 0002 created and the columns it added, the baseline tables and their rows stay
+
+-- the baseline reads the role from USERS, so each user keeps one of their roles there
+UPDATE USERS SET role = COALESCE((SELECT MIN(ur.role_name) FROM user_roles ur WHERE ur.user_name = USERS.username), '');
+
+DROP TABLE role_parents;
+DROP TABLE role_permissions;
+DROP TABLE permissions;
+DROP TABLE roles;
+DROP TABLE webauthn_credentials;
+DROP TABLE webauthn_challenges;
+DROP TABLE mfa_challenges;
+DROP TABLE mfa_recovery_codes;
+DROP TABLE user_mfa;
+DROP TABLE authorization_codes;
+DROP TABLE oauth_clients;
+DROP TABLE login_attempts;
+DROP TABLE revoked_tokens;
+DROP TABLE password_reset_tokens;
+DROP TABLE email_verification_tokens;
+DROP TABLE password_history;
+DROP TABLE user_roles;
+
+-- the sqlite bundled with the driver can't drop columns, the baseline tables are copied back into their 0001 shape
+CREATE TABLE refresh_token_store_baseline (
+    refresh_token VARCHAR(1000) NOT NULL PRIMARY KEY
+);
+INSERT INTO refresh_token_store_baseline (refresh_token) SELECT refresh_token FROM refresh_token_store;
+DROP TABLE refresh_token_store;
+ALTER TABLE refresh_token_store_baseline RENAME TO refresh_token_store;
+
+CREATE TABLE users_baseline (
+    username    VARCHAR(64)  NOT NULL PRIMARY KEY,
+    password    VARCHAR(255) NOT NULL,
+    role        VARCHAR(64)  NOT NULL DEFAULT '',
+    customer_id BIGINT       NULL,
+    created_on  TEXT         NULL
+);
+INSERT INTO users_baseline (username, password, role, customer_id, created_on)
+    SELECT username, password, role, customer_id, created_on FROM USERS;
+DROP TABLE USERS;
+ALTER TABLE users_baseline RENAME TO USERS;
@@ -0,0 +1,7 @@
+DELETE FROM role_parents WHERE role_name = 'admin' AND parent_role_name = 'user';
+DELETE FROM role_permissions WHERE (role_name = 'admin' AND permission_name IN
+    ('GetAllActiveCustomer', 'GetAllInActiveCustomer', 'GetAllCustomer', 'NewAccount') AND effect = 'allow')
+    OR (role_name = 'user' AND permission_name IN ('GetCustomer', 'NewTransaction') AND effect = 'allow');
+DELETE FROM permissions WHERE permission_name IN
+    ('GetAllActiveCustomer', 'GetAllInActiveCustomer', 'GetAllCustomer', 'GetCustomer', 'NewAccount', 'NewTransaction');
+DELETE FROM roles WHERE role_name IN ('admin', 'user');
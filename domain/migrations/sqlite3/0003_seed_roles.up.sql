@@ -0,0 +1,23 @@
+-- the built in roles, see GetUserRolePermissions and GetOperationScopes. Rows that already exist are kept
+INSERT OR IGNORE INTO roles (role_name, description) VALUES
+    ('admin', 'Staff managing customers and accounts'),
+    ('user', 'Customers');
+
+INSERT OR IGNORE INTO permissions (permission_name, description, scope) VALUES
+    ('GetAllActiveCustomer', NULL, 'customers:read'),
+    ('GetAllInActiveCustomer', NULL, 'customers:read'),
+    ('GetAllCustomer', NULL, 'customers:read'),
+    ('GetCustomer', NULL, 'customers:read'),
+    ('NewAccount', NULL, 'accounts:write'),
+    ('NewTransaction', NULL, 'transactions:write');
+
+INSERT OR IGNORE INTO role_permissions (role_name, permission_name, effect) VALUES
+    ('admin', 'GetAllActiveCustomer', 'allow'),
+    ('admin', 'GetAllInActiveCustomer', 'allow'),
+    ('admin', 'GetAllCustomer', 'allow'),
+    ('admin', 'NewAccount', 'allow'),
+    ('user', 'GetCustomer', 'allow'),
+    ('user', 'NewTransaction', 'allow');
+
+INSERT OR IGNORE INTO role_parents (role_name, parent_role_name) VALUES
+    ('admin', 'user');
@@ -0,0 +1,269 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations of each dialect as migrations/<dialect>/<version>_<name>.<up|down>.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+const createSchemaVersionTable = "CREATE TABLE IF NOT EXISTS schema_version (" +
+	"version BIGINT NOT NULL PRIMARY KEY, " +
+	"name VARCHAR(255) NOT NULL, " +
+	"checksum VARCHAR(64) NOT NULL, " +
+	"applied_at BIGINT NOT NULL)"
+
+// Migration is one step of the schema, Checksum is the sha256 of the up script so edits to a
+// migration that has already been applied are noticed
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// AppliedMigration is a row of schema_version
+type AppliedMigration struct {
+	Version   int64  `db:"version"`
+	Name      string `db:"name"`
+	Checksum  string `db:"checksum"`
+	AppliedAt int64  `db:"applied_at"`
+}
+
+// Migrator applies the embedded migrations of the database's dialect and records them in schema_version.
+// Each migration runs in a transaction, MySQL commits DDL as it goes though so a migration failing
+// there part way has to be repaired by hand
+type Migrator struct {
+	client     *Database
+	migrations []Migration
+}
+
+// Migrations returns every migration known to the binary in version order
+func (migrator Migrator) Migrations() []Migration {
+	return migrator.migrations
+}
+
+// Applied returns the migrations recorded in schema_version in version order, the table is created
+// when it does not exist yet
+func (migrator Migrator) Applied() ([]AppliedMigration, error) {
+	if _, err := migrator.client.Exec(createSchemaVersionTable); err != nil {
+		return nil, err
+	}
+	applied := make([]AppliedMigration, 0)
+	err := migrator.client.Select(&applied, "SELECT version, name, checksum, applied_at FROM schema_version ORDER BY version")
+	return applied, err
+}
+
+// Verify checks the applied migrations against the binary, a migration that was edited after being
+// applied or one the binary doesn't know, e.g. after a rollback of the service, is an error
+func (migrator Migrator) Verify() error {
+	applied, err := migrator.Applied()
+	if err != nil {
+		return err
+	}
+	return migrator.verify(applied)
+}
+
+func (migrator Migrator) verify(applied []AppliedMigration) error {
+	for _, row := range applied {
+		migration, ok := migrator.find(row.Version)
+		if !ok {
+			return fmt.Errorf("migration %d %s is applied but unknown to this version of the service", row.Version, row.Name)
+		}
+		if migration.Checksum != row.Checksum {
+			return fmt.Errorf("migration %d %s has changed since it was applied", row.Version, row.Name)
+		}
+	}
+	return nil
+}
+
+// Pending returns the migrations that have not been applied yet
+func (migrator Migrator) Pending() ([]Migration, error) {
+	applied, err := migrator.Applied()
+	if err != nil {
+		return nil, err
+	}
+	if err = migrator.verify(applied); err != nil {
+		return nil, err
+	}
+	done := make(map[int64]bool, len(applied))
+	for _, row := range applied {
+		done[row.Version] = true
+	}
+	pending := make([]Migration, 0)
+	for _, migration := range migrator.migrations {
+		if !done[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration and returns the ones it applied
+func (migrator Migrator) Up() ([]Migration, error) {
+	pending, err := migrator.Pending()
+	if err != nil {
+		return nil, err
+	}
+	for i, migration := range pending {
+		err = migrator.run(migration.Up, func(tx *Transaction) error {
+			_, err := tx.Exec("INSERT INTO schema_version (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+				migration.Version, migration.Name, migration.Checksum, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return pending[:i], fmt.Errorf("migration %d %s failed : %s", migration.Version, migration.Name, err.Error())
+		}
+	}
+	return pending, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first, and returns the ones it rolled back.
+// A migration whose down script has no statements can't be rolled back and stops it with an error
+func (migrator Migrator) Down(steps int) ([]Migration, error) {
+	applied, err := migrator.Applied()
+	if err != nil {
+		return nil, err
+	}
+	if err = migrator.verify(applied); err != nil {
+		return nil, err
+	}
+	rolledBack := make([]Migration, 0, steps)
+	for i := len(applied) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		migration, _ := migrator.find(applied[i].Version)
+		if len(splitStatements(migration.Down)) == 0 {
+			return rolledBack, fmt.Errorf("migration %d %s can't be rolled back", migration.Version, migration.Name)
+		}
+		err = migrator.run(migration.Down, func(tx *Transaction) error {
+			_, err := tx.Exec("DELETE FROM schema_version WHERE version = ?", migration.Version)
+			return err
+		})
+		if err != nil {
+			return rolledBack, fmt.Errorf("rolling back migration %d %s failed : %s", migration.Version,
+				migration.Name, err.Error())
+		}
+		rolledBack = append(rolledBack, migration)
+	}
+	return rolledBack, nil
+}
+
+// run executes the script and then record in one transaction
+func (migrator Migrator) run(script string, record func(tx *Transaction) error) error {
+	tx, err := migrator.client.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, statement := range splitStatements(script) {
+		if _, err = tx.Exec(statement); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err = record(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (migrator Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range migrator.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// splitStatements splits a script into statements ending with a ; at the end of a line, the drivers
+// don't all accept several statements in one call. Comment lines are dropped
+func splitStatements(script string) []string {
+	statements := make([]string, 0)
+	var statement strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(statement.String()), ";"))
+			statement.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(statement.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// loadMigrations reads the embedded migrations of the dialect, every version needs both an up and a down script
+func loadMigrations(dialect Dialect) ([]Migration, error) {
+	directory := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrationFiles, directory)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s", dialect)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		parts := migrationFileName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.ParseInt(parts[1], 10, 64)
+		content, err := fs.ReadFile(migrationFiles, path.Join(directory, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			sum := sha256.Sum256(content)
+			migration.Up = string(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d %s needs an up and a down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func NewMigrator(client *Database) (*Migrator, error) {
+	migrations, err := loadMigrations(client.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{client: client, migrations: migrations}, nil
+}
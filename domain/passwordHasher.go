@@ -0,0 +1,205 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+const (
+	HASH_ALGORITHM_BCRYPT   string = "bcrypt"
+	HASH_ALGORITHM_ARGON2ID string = "argon2id"
+	// bcrypt refuses to hash longer passwords
+	BCRYPT_MAX_PASSWORD_BYTES int = 72
+)
+
+// PasswordHasher hashes passwords into a self describing encoded string and verifies
+// candidate passwords against a previously encoded hash
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, encodedHash string) (bool, error)
+	// NeedsRehash reports whether the encoded hash was produced by a weaker algorithm or weaker parameters
+	NeedsRehash(encodedHash string) bool
+	// Supports reports whether the encoded hash is in this hasher's format
+	Supports(encodedHash string) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (hasher BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (hasher BcryptHasher) Verify(password string, encodedHash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (hasher BcryptHasher) NeedsRehash(encodedHash string) bool {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	if err != nil {
+		return true
+	}
+	return cost < hasher.Cost
+}
+
+func (hasher BcryptHasher) Supports(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") ||
+		strings.HasPrefix(encodedHash, "$2b$") ||
+		strings.HasPrefix(encodedHash, "$2y$")
+}
+
+// Argon2idHasher encodes hashes in the PHC string format used by the reference implementation
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+type Argon2idHasher struct {
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	KeyLength  uint32
+	SaltLength uint32
+}
+
+type argon2idParams struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (hasher Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, hasher.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, hasher.Time, hasher.Memory, hasher.Threads, hasher.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, hasher.Memory, hasher.Time,
+		hasher.Threads, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (hasher Argon2idHasher) Verify(password string, encodedHash string) (bool, error) {
+	params, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (hasher Argon2idHasher) NeedsRehash(encodedHash string) bool {
+	params, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return true
+	}
+	return params.time < hasher.Time ||
+		params.memory < hasher.Memory ||
+		params.threads < hasher.Threads ||
+		uint32(len(params.key)) < hasher.KeyLength ||
+		uint32(len(params.salt)) < hasher.SaltLength
+}
+
+func (hasher Argon2idHasher) Supports(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$argon2id$")
+}
+
+func decodeArgon2idHash(encodedHash string) (*argon2idParams, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != HASH_ALGORITHM_ARGON2ID {
+		return nil, errors.New("invalid argon2id hash format")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+	if version != argon2.Version {
+		return nil, errors.New("unsupported argon2id version")
+	}
+	var params argon2idParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, err
+	}
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	return &params, nil
+}
+
+// DefaultPasswordHasher hashes new passwords with the preferred algorithm and verifies
+// passwords stored by any supported algorithm, including legacy plaintext rows
+type DefaultPasswordHasher struct {
+	preferred PasswordHasher
+	hashers   []PasswordHasher
+}
+
+func (hasher DefaultPasswordHasher) Hash(password string) (string, error) {
+	return hasher.preferred.Hash(password)
+}
+
+func (hasher DefaultPasswordHasher) Verify(password string, encodedHash string) (bool, error) {
+	for _, h := range hasher.hashers {
+		if h.Supports(encodedHash) {
+			return h.Verify(password, encodedHash)
+		}
+	}
+	// legacy row holding the plaintext password
+	return subtle.ConstantTimeCompare([]byte(password), []byte(encodedHash)) == 1, nil
+}
+
+func (hasher DefaultPasswordHasher) NeedsRehash(encodedHash string) bool {
+	if !hasher.preferred.Supports(encodedHash) {
+		return true
+	}
+	return hasher.preferred.NeedsRehash(encodedHash)
+}
+
+func (hasher DefaultPasswordHasher) Supports(encodedHash string) bool {
+	for _, h := range hasher.hashers {
+		if h.Supports(encodedHash) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewBcryptHasher() BcryptHasher {
+	return BcryptHasher{Cost: 12}
+}
+
+func NewArgon2idHasher() Argon2idHasher {
+	// OWASP recommended minimum for argon2id
+	return Argon2idHasher{Time: 2, Memory: 19 * 1024, Threads: 1, KeyLength: 32, SaltLength: 16}
+}
+
+func NewPasswordHasher(algorithm string) (PasswordHasher, error) {
+	bcryptHasher := NewBcryptHasher()
+	argon2idHasher := NewArgon2idHasher()
+	hashers := []PasswordHasher{bcryptHasher, argon2idHasher}
+	switch algorithm {
+	case HASH_ALGORITHM_BCRYPT:
+		return DefaultPasswordHasher{preferred: bcryptHasher, hashers: hashers}, nil
+	case HASH_ALGORITHM_ARGON2ID, "":
+		return DefaultPasswordHasher{preferred: argon2idHasher, hashers: hashers}, nil
+	}
+	return nil, fmt.Errorf("unsupported password hash algorithm %s", algorithm)
+}
@@ -0,0 +1,218 @@
+package domain
+
+import (
+	"banking-auth/dto"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/barnettt/banking-lib/logger"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	PASSWORD_RULE_MIN_LENGTH string = "min_length"
+	PASSWORD_RULE_MAX_LENGTH string = "max_length"
+	PASSWORD_RULE_UPPERCASE  string = "uppercase"
+	PASSWORD_RULE_LOWERCASE  string = "lowercase"
+	PASSWORD_RULE_DIGIT      string = "digit"
+	PASSWORD_RULE_SYMBOL     string = "symbol"
+	PASSWORD_RULE_HISTORY    string = "history"
+	PASSWORD_RULE_BREACHED   string = "breached"
+)
+
+// PasswordPolicy is checked wherever a password is set. MaxAge and HistorySize of 0 turn those rules off.
+// MaxLength counts characters, MaxBytes limits the utf-8 length for hashers that can't take long passwords
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	MaxAge        time.Duration
+	// the number of previous passwords that may not be reused, the current one included
+	HistorySize    int
+	BreachedHashes BreachedPasswordList
+}
+
+// Check returns every rule the password breaks, the history rule needs the user's previous hashes so it is checked by the caller
+func (policy PasswordPolicy) Check(password string) []dto.PasswordRuleViolation {
+	violations := make([]dto.PasswordRuleViolation, 0)
+	length := len([]rune(password))
+	if length < policy.MinLength {
+		violations = append(violations, dto.PasswordRuleViolation{Rule: PASSWORD_RULE_MIN_LENGTH,
+			Message: "password must be at least " + strconv.Itoa(policy.MinLength) + " characters"})
+	}
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		violations = append(violations, dto.PasswordRuleViolation{Rule: PASSWORD_RULE_MAX_LENGTH,
+			Message: "password must be at most " + strconv.Itoa(policy.MaxLength) + " characters"})
+	} else if policy.MaxBytes > 0 && len(password) > policy.MaxBytes {
+		violations = append(violations, dto.PasswordRuleViolation{Rule: PASSWORD_RULE_MAX_LENGTH,
+			Message: "password must be at most " + strconv.Itoa(policy.MaxBytes) + " bytes"})
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if policy.RequireUpper && !upper {
+		violations = append(violations, dto.PasswordRuleViolation{Rule: PASSWORD_RULE_UPPERCASE,
+			Message: "password must contain an uppercase letter"})
+	}
+	if policy.RequireLower && !lower {
+		violations = append(violations, dto.PasswordRuleViolation{Rule: PASSWORD_RULE_LOWERCASE,
+			Message: "password must contain a lowercase letter"})
+	}
+	if policy.RequireDigit && !digit {
+		violations = append(violations, dto.PasswordRuleViolation{Rule: PASSWORD_RULE_DIGIT,
+			Message: "password must contain a digit"})
+	}
+	if policy.RequireSymbol && !symbol {
+		violations = append(violations, dto.PasswordRuleViolation{Rule: PASSWORD_RULE_SYMBOL,
+			Message: "password must contain a symbol"})
+	}
+	if policy.BreachedHashes != nil {
+		breached, err := policy.BreachedHashes.IsBreached(password)
+		if err != nil {
+			// the list is a defence in depth, an unreadable list must not stop users setting passwords
+			logger.Error("Error checking breached password list : " + err.Error())
+		} else if breached {
+			violations = append(violations, dto.PasswordRuleViolation{Rule: PASSWORD_RULE_BREACHED,
+				Message: "password has appeared in a data breach, choose another"})
+		}
+	}
+	return violations
+}
+
+// HasExpired reports whether a password set at changedAt must be changed, passwords with no recorded change time never expire
+func (policy PasswordPolicy) HasExpired(changedAt int64) bool {
+	if policy.MaxAge <= 0 || changedAt == 0 {
+		return false
+	}
+	return time.Now().After(time.Unix(changedAt, 0).Add(policy.MaxAge))
+}
+
+// BreachedPasswordList looks up passwords known from data breaches
+type BreachedPasswordList interface {
+	IsBreached(password string) (bool, error)
+}
+
+// HashListFile is a local copy of a k-anonymity breached password list, one upper case SHA-1
+// hash per line optionally followed by ":count", sorted by hash as in the "ordered by hash"
+// downloads. The file is binary searched so it is never loaded into memory
+type HashListFile struct {
+	path string
+}
+
+func (list HashListFile) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+	file, err := os.Open(list.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	// narrow down to an offset after which the hash must start, every line starting before lo is smaller
+	lo, hi := int64(0), info.Size()
+	for hi-lo > 4096 {
+		mid := lo + (hi-lo)/2
+		hash, err := hashAfter(file, mid)
+		if err != nil {
+			return false, err
+		}
+		if hash == "" || hash >= target {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	if _, err = file.Seek(lo, io.SeekStart); err != nil {
+		return false, err
+	}
+	reader := bufio.NewReader(file)
+	if lo > 0 {
+		// lo is inside a line that is known to be smaller
+		if _, err = reader.ReadString('\n'); err != nil {
+			return false, nil
+		}
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if hash := lineHash(line); hash != "" {
+			if hash == target {
+				return true, nil
+			}
+			if hash > target {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// hashAfter returns the hash of the first line starting after offset, empty at the end of the file
+func hashAfter(file *os.File, offset int64) (string, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	reader := bufio.NewReader(file)
+	if _, err := reader.ReadString('\n'); err != nil {
+		if err == io.EOF {
+			return "", nil
+		}
+		return "", err
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return lineHash(line), nil
+}
+
+func lineHash(line string) string {
+	line = strings.TrimSpace(line)
+	if index := strings.IndexByte(line, ':'); index >= 0 {
+		line = line[:index]
+	}
+	return strings.ToUpper(line)
+}
+
+func NewHashListFile(path string) (HashListFile, error) {
+	if _, err := os.Stat(path); err != nil {
+		return HashListFile{}, err
+	}
+	return HashListFile{path: path}, nil
+}
+
+// NewPasswordPolicy follows the NIST 800-63B guidance of long passwords screened against breaches
+// rather than composition rules, which deployments can still turn on
+func NewPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:   12,
+		MaxLength:   128,
+		HistorySize: 5,
+	}
+}
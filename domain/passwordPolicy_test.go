@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyMaxBytes(t *testing.T) {
+	policy := NewPasswordPolicy()
+	policy.MaxBytes = BCRYPT_MAX_PASSWORD_BYTES
+	tests := []struct {
+		name     string
+		password string
+		allowed  bool
+	}{
+		{"ascii at the limit", strings.Repeat("a", 72), true},
+		{"ascii over the limit", strings.Repeat("a", 73), false},
+		// 40 characters but 80 bytes
+		{"multi byte over the limit", strings.Repeat("é", 40), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			violations := policy.Check(test.password)
+			if allowed := len(violations) == 0; allowed != test.allowed {
+				t.Fatalf("allowed = %v, want %v : %v", allowed, test.allowed, violations)
+			}
+			if !test.allowed && violations[0].Rule != PASSWORD_RULE_MAX_LENGTH {
+				t.Errorf("rule = %s, want %s", violations[0].Rule, PASSWORD_RULE_MAX_LENGTH)
+			}
+			if test.allowed {
+				if _, err := NewBcryptHasher().Hash(test.password); err != nil {
+					t.Errorf("bcrypt refused a password the policy allows : %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PolicyExpression is a compiled access policy condition. Conditions are boolean expressions over
+// named values, for example
+//
+//	params.amount < 5000 && time.clock >= '09:00' && time.clock < '17:30' && time.weekday in ['Mon', 'Tue', 'Wed', 'Thu', 'Fri']
+//
+// Operators are || && ! == != < <= > >= and in, with parentheses for grouping and [a, b] for lists.
+// Values that are both plain decimals compare as numbers, request params arrive as strings so this
+// is what lets params.amount < 5000 work
+type PolicyExpression struct {
+	source string
+	root   expressionNode
+}
+
+// PolicyValues looks up the named values a condition refers to, nil is returned for unknown names
+type PolicyValues func(name string) interface{}
+
+func (expression PolicyExpression) String() string {
+	return expression.source
+}
+
+// Evaluate runs the condition, an error means the condition could not be decided, for example a
+// param it compares is missing, and callers should treat it as not met
+func (expression PolicyExpression) Evaluate(values PolicyValues) (bool, error) {
+	result, err := expression.root.eval(values)
+	if err != nil {
+		return false, err
+	}
+	met, ok := result.(bool)
+	if !ok {
+		return false, errors.New("condition is not a true or false value")
+	}
+	return met, nil
+}
+
+func CompilePolicyExpression(source string) (*PolicyExpression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := expressionParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected %q", parser.tokens[parser.position].text)
+	}
+	return &PolicyExpression{source: source, root: root}, nil
+}
+
+const (
+	tokenIdentifier = iota
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type expressionToken struct {
+	kind int
+	text string
+}
+
+func tokenizeExpression(source string) ([]expressionToken, error) {
+	tokens := make([]expressionToken, 0)
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, expressionToken{tokenIdentifier, string(runes[start:i])})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, expressionToken{tokenNumber, string(runes[start:i])})
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, expressionToken{tokenString, string(runes[i+1 : end])})
+			i = end + 1
+		default:
+			operator := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, expressionToken{tokenOperator, operator})
+			i += len(operator)
+		}
+	}
+	return tokens, nil
+}
+
+type expressionParser struct {
+	tokens   []expressionToken
+	position int
+}
+
+func (parser *expressionParser) peek() (expressionToken, bool) {
+	if parser.position >= len(parser.tokens) {
+		return expressionToken{}, false
+	}
+	return parser.tokens[parser.position], true
+}
+
+// accept consumes the next token when it is one of the given operators or keywords
+func (parser *expressionParser) accept(texts ...string) (string, bool) {
+	token, ok := parser.peek()
+	if !ok || (token.kind != tokenOperator && token.kind != tokenIdentifier) {
+		return "", false
+	}
+	for _, text := range texts {
+		if token.text == text {
+			parser.position++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (parser *expressionParser) expect(text string) error {
+	if _, ok := parser.accept(text); !ok {
+		return fmt.Errorf("expected %q", text)
+	}
+	return nil
+}
+
+func (parser *expressionParser) parseOr() (expressionNode, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := parser.accept("||"); !ok {
+			return left, nil
+		}
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{operator: "||", left: left, right: right}
+	}
+}
+
+func (parser *expressionParser) parseAnd() (expressionNode, error) {
+	left, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := parser.accept("&&"); !ok {
+			return left, nil
+		}
+		right, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{operator: "&&", left: left, right: right}
+	}
+}
+
+func (parser *expressionParser) parseUnary() (expressionNode, error) {
+	if _, ok := parser.accept("!"); ok {
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return parser.parseComparison()
+}
+
+func (parser *expressionParser) parseComparison() (expressionNode, error) {
+	left, err := parser.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	operator, ok := parser.accept("==", "!=", "<=", ">=", "<", ">", "in")
+	if !ok {
+		return left, nil
+	}
+	right, err := parser.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return comparisonNode{operator: operator, left: left, right: right}, nil
+}
+
+func (parser *expressionParser) parsePrimary() (expressionNode, error) {
+	token, ok := parser.peek()
+	if !ok {
+		return nil, errors.New("unexpected end of condition")
+	}
+	parser.position++
+	switch token.kind {
+	case tokenNumber:
+		number, ok := parseDecimal(token.text)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %q", token.text)
+		}
+		return literalNode{number}, nil
+	case tokenString:
+		return literalNode{token.text}, nil
+	case tokenIdentifier:
+		switch token.text {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "in":
+			return nil, errors.New("unexpected \"in\"")
+		}
+		return valueNode{token.text}, nil
+	}
+	switch token.text {
+	case "(":
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, parser.expect(")")
+	case "[":
+		items := make([]expressionNode, 0)
+		if _, ok := parser.accept("]"); ok {
+			return listNode{items}, nil
+		}
+		for {
+			item, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if _, ok := parser.accept(","); !ok {
+				return listNode{items}, parser.expect("]")
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q", token.text)
+}
+
+type expressionNode interface {
+	eval(values PolicyValues) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (node literalNode) eval(PolicyValues) (interface{}, error) {
+	return node.value, nil
+}
+
+type valueNode struct {
+	name string
+}
+
+func (node valueNode) eval(values PolicyValues) (interface{}, error) {
+	return values(node.name), nil
+}
+
+type listNode struct {
+	items []expressionNode
+}
+
+func (node listNode) eval(values PolicyValues) (interface{}, error) {
+	list := make([]interface{}, 0, len(node.items))
+	for _, item := range node.items {
+		value, err := item.eval(values)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+type notNode struct {
+	operand expressionNode
+}
+
+func (node notNode) eval(values PolicyValues) (interface{}, error) {
+	value, err := evalBool(node.operand, values)
+	if err != nil {
+		return nil, err
+	}
+	return !value, nil
+}
+
+type logicalNode struct {
+	operator string
+	left     expressionNode
+	right    expressionNode
+}
+
+func (node logicalNode) eval(values PolicyValues) (interface{}, error) {
+	left, err := evalBool(node.left, values)
+	if err != nil {
+		return nil, err
+	}
+	// short circuit so a later part can rely on an earlier one, e.g. role != 'teller' || params.amount < 500
+	if (node.operator == "&&" && !left) || (node.operator == "||" && left) {
+		return left, nil
+	}
+	return evalBool(node.right, values)
+}
+
+type comparisonNode struct {
+	operator string
+	left     expressionNode
+	right    expressionNode
+}
+
+func (node comparisonNode) eval(values PolicyValues) (interface{}, error) {
+	left, err := node.left.eval(values)
+	if err != nil {
+		return nil, err
+	}
+	right, err := node.right.eval(values)
+	if err != nil {
+		return nil, err
+	}
+	switch node.operator {
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	case "in":
+		list, ok := right.([]interface{})
+		if !ok {
+			return nil, errors.New("the right of in must be a list")
+		}
+		for _, item := range list {
+			if valuesEqual(left, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	order, err := compareValues(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch node.operator {
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	}
+	return order >= 0, nil
+}
+
+func evalBool(node expressionNode, values PolicyValues) (bool, error) {
+	value, err := node.eval(values)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected true or false but got %v", value)
+	}
+	return result, nil
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		return parseDecimal(v)
+	}
+	return 0, false
+}
+
+// parseDecimal only accepts plain decimals such as 5000, -12 or 0.25. ParseFloat alone would also
+// take NaN, Inf, hex and exponents, which would let a param like NaN or 0x1p20 slip past a limit
+func parseDecimal(text string) (float64, bool) {
+	digits := strings.TrimPrefix(text, "-")
+	if point := strings.Index(digits, "."); point >= 0 {
+		if !isDigits(digits[:point]) || !isDigits(digits[point+1:]) {
+			return 0, false
+		}
+	} else if !isDigits(digits) {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsInf(number, 0) {
+		return 0, false
+	}
+	return number, true
+}
+
+func isDigits(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, r := range text {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func valuesEqual(left interface{}, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if leftNumber, ok := toNumber(left); ok {
+		if rightNumber, ok := toNumber(right); ok {
+			return leftNumber == rightNumber
+		}
+	}
+	if _, ok := left.([]interface{}); ok {
+		return false
+	}
+	if _, ok := right.([]interface{}); ok {
+		return false
+	}
+	return fmt.Sprint(left) == fmt.Sprint(right)
+}
+
+func compareValues(left interface{}, right interface{}) (int, error) {
+	if left == nil || right == nil {
+		return 0, errors.New("cannot compare a missing value")
+	}
+	if leftNumber, ok := toNumber(left); ok {
+		if rightNumber, ok := toNumber(right); ok {
+			switch {
+			case leftNumber < rightNumber:
+				return -1, nil
+			case leftNumber > rightNumber:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	leftString, leftOk := left.(string)
+	rightString, rightOk := right.(string)
+	if !leftOk || !rightOk {
+		return 0, fmt.Errorf("cannot compare %v and %v", left, right)
+	}
+	return strings.Compare(leftString, rightString), nil
+}
@@ -0,0 +1,138 @@
+package domain
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func mapValues(values map[string]interface{}) PolicyValues {
+	return func(name string) interface{} {
+		return values[name]
+	}
+}
+
+func TestPolicyExpressionEvaluate(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		values    map[string]interface{}
+		want      bool
+		wantErr   bool
+	}{
+		// precedence, ! binds tighter than && which binds tighter than ||
+		{"and before or", "true || false && false", nil, true, false},
+		{"and before or on the left", "false && false || true", nil, true, false},
+		{"parentheses", "(true || false) && false", nil, false, false},
+		{"not before and", "!false && false", nil, false, false},
+		{"not of group", "!(true && false)", nil, true, false},
+		{"double not", "!!true", nil, true, false},
+		{"comparison before and", "a == 1 && b == 2", map[string]interface{}{"a": "1", "b": "2"}, true, false},
+
+		// in
+		{"in list", "role in ['teller', 'manager']", map[string]interface{}{"role": "teller"}, true, false},
+		{"not in list", "role in ['teller', 'manager']", map[string]interface{}{"role": "admin"}, false, false},
+		{"in empty list", "role in []", map[string]interface{}{"role": "admin"}, false, false},
+		{"in compares numbers", "params.amount in [1, 2.0]", map[string]interface{}{"params.amount": "2"}, true, false},
+		{"in does not compare hex as numbers", "params.amount in [16]", map[string]interface{}{"params.amount": "0x10"}, false, false},
+		{"in needs a list", "role in 'teller'", map[string]interface{}{"role": "teller"}, false, true},
+		{"missing value in list", "role in ['teller']", nil, false, false},
+
+		// short circuiting skips the part that cannot be evaluated
+		{"or short circuits", "role != 'teller' || params.amount < 500", map[string]interface{}{"role": "admin"}, true, false},
+		{"and short circuits", "false && params.amount < 500", nil, false, false},
+		{"and evaluates the right", "true && params.amount < 500", nil, false, true},
+		{"or evaluates the right", "role != 'admin' || params.amount < 500", map[string]interface{}{"role": "admin"}, false, true},
+
+		// numbers
+		{"decimal param", "params.amount < 5000", map[string]interface{}{"params.amount": "4999.5"}, true, false},
+		{"decimal param at limit", "params.amount < 5000", map[string]interface{}{"params.amount": "5000"}, false, false},
+		{"negative param", "params.amount < 0", map[string]interface{}{"params.amount": "-12"}, true, false},
+		{"numbers not strings", "params.amount < 5000", map[string]interface{}{"params.amount": "10000"}, false, false},
+		{"float value", "limit >= 10", map[string]interface{}{"limit": 10.0}, true, false},
+		{"int value", "limit >= 10", map[string]interface{}{"limit": 10}, true, false},
+		{"NaN param", "params.amount < 5000", map[string]interface{}{"params.amount": "NaN"}, false, true},
+		{"Inf param", "params.amount < 5000", map[string]interface{}{"params.amount": "-Inf"}, false, true},
+		{"infinity param", "params.amount > 0", map[string]interface{}{"params.amount": "infinity"}, false, true},
+		{"hex param", "params.amount < 5000", map[string]interface{}{"params.amount": "0x10"}, false, true},
+		{"hex float param", "params.amount < 5000", map[string]interface{}{"params.amount": "0x1p-2"}, false, true},
+		{"exponent param", "params.amount < 5000", map[string]interface{}{"params.amount": "1e3"}, false, true},
+		{"plus sign param", "params.amount < 5000", map[string]interface{}{"params.amount": "+5"}, false, true},
+		{"underscore param", "params.amount < 5000", map[string]interface{}{"params.amount": "1_000"}, false, true},
+		{"padded param", "params.amount < 5000", map[string]interface{}{"params.amount": " 10"}, false, true},
+		{"trailing point param", "params.amount < 5000", map[string]interface{}{"params.amount": "10."}, false, true},
+		{"huge param", "params.amount > 0", map[string]interface{}{"params.amount": "1" + strings.Repeat("0", 400)}, false, true},
+		{"NaN value", "limit < 10", map[string]interface{}{"limit": math.NaN()}, false, true},
+		{"Inf value", "limit > 10", map[string]interface{}{"limit": math.Inf(1)}, false, true},
+		{"NaN is not equal to a number", "params.amount == 0", map[string]interface{}{"params.amount": "NaN"}, false, false},
+
+		// strings and type mismatches
+		{"string order", "time.clock >= '09:00' && time.clock < '17:30'", map[string]interface{}{"time.clock": "12:15"}, true, false},
+		{"string equality", "role == 'teller'", map[string]interface{}{"role": "teller"}, true, false},
+		{"bool equality", "params.flag == true", map[string]interface{}{"params.flag": true}, true, false},
+		{"bool is not a number", "params.flag == 1", map[string]interface{}{"params.flag": true}, false, false},
+		{"string compared to number", "role < 5", map[string]interface{}{"role": "teller"}, false, true},
+		{"bool compared to number", "true < 5", nil, false, true},
+		{"list compared to number", "[1] < 5", nil, false, true},
+		{"list is not equal to its item", "[1] == 1", nil, false, false},
+		{"missing value compared", "params.amount < 5000", nil, false, true},
+		{"missing values are equal", "a == b", nil, true, false},
+		{"missing value is not a string", "role == 'teller'", nil, false, false},
+		{"condition must be bool", "params.amount", map[string]interface{}{"params.amount": "5"}, false, true},
+		{"and needs bools", "1 && true", nil, false, true},
+		{"not needs a bool", "!role", map[string]interface{}{"role": "teller"}, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expression, err := CompilePolicyExpression(test.condition)
+			if err != nil {
+				t.Fatalf("compile %q: %v", test.condition, err)
+			}
+			got, err := expression.Evaluate(mapValues(test.values))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("%q evaluated to %v, expected an error", test.condition, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q: %v", test.condition, err)
+			}
+			if got != test.want {
+				t.Errorf("%q = %v, want %v", test.condition, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompilePolicyExpressionRejectsMalformed(t *testing.T) {
+	conditions := []string{
+		"",
+		"   ",
+		"role ==",
+		"== 'teller'",
+		"(role == 'teller'",
+		"role == 'teller')",
+		"role == 'teller",
+		"role = 'teller'",
+		"role == 'teller' admin",
+		"role in ['teller', 'manager'",
+		"role in ['teller',]",
+		"role in",
+		"in == 1",
+		"params.amount < 1.2.3",
+		"params.amount < 5.",
+		"params.amount < 0x10",
+		"params.amount $ 5",
+		"&& true",
+		"true ||",
+		"!",
+		"()",
+		"a < b < c",
+	}
+	for _, condition := range conditions {
+		if _, err := CompilePolicyExpression(condition); err == nil {
+			t.Errorf("%q compiled", condition)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Requests per Period on average, with bursts of up to Requests
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (limit RateLimit) tokensPerSecond() float64 {
+	return float64(limit.Requests) / limit.Period.Seconds()
+}
+
+// RateLimiter is a token bucket store. The in memory limiter suits a single instance, multiple
+// instances need an implementation backed by a shared store so the buckets are shared too
+type RateLimiter interface {
+	// Allow takes a token from the key's bucket, when the bucket is empty it returns how long until a token is available
+	Allow(key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type InMemoryRateLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	sweptAt   time.Time
+	sweepIdle time.Duration
+}
+
+func (limiter *InMemoryRateLimiter) Allow(key string, limit RateLimit) (bool, time.Duration, error) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	limiter.sweep(now)
+	capacity := float64(limit.Requests)
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updatedAt: now}
+		limiter.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*limit.tokensPerSecond())
+	bucket.updatedAt = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - bucket.tokens) / limit.tokensPerSecond() * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep drops buckets that have been idle long enough to have refilled, it must be called holding the lock
+func (limiter *InMemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.sweptAt) < limiter.sweepIdle {
+		return
+	}
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.updatedAt) > limiter.sweepIdle {
+			delete(limiter.buckets, key)
+		}
+	}
+	limiter.sweptAt = now
+}
+
+// NewInMemoryRateLimiter forgets buckets idle for longer than idleAfter, which must be at least the longest limit period
+func NewInMemoryRateLimiter(idleAfter time.Duration) *InMemoryRateLimiter {
+	return &InMemoryRateLimiter{buckets: make(map[string]*tokenBucket), sweptAt: time.Now(), sweepIdle: idleAfter}
+}
@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// RefreshTokenRecord is a row of refresh_token_store. Every refresh token issued from the same
+// login shares a token family so a replayed token can revoke the whole chain.
+type RefreshTokenRecord struct {
+	RefreshToken string `db:"refresh_token"`
+	TokenFamily  string `db:"token_family"`
+	UserName     string `db:"user_name"`
+	Used         bool   `db:"used"`
+	Revoked      bool   `db:"revoked"`
+}
+
+// NewTokenId returns a random url safe identifier used for jti claims and token families
+func NewTokenId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(id)
+}
@@ -0,0 +1,224 @@
+package domain
+
+import (
+	"database/sql"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"strings"
+)
+
+type Role struct {
+	Name        string         `db:"role_name"`
+	Description sql.NullString `db:"description"`
+}
+
+type Permission struct {
+	Name        string         `db:"permission_name"`
+	Description sql.NullString `db:"description"`
+	// Scope is the OAuth scope a token needs to call the operation, e.g. accounts:read
+	Scope sql.NullString `db:"scope"`
+}
+
+type RoleRepository interface {
+	// FindRoleDefinitions returns every role with its parents and its own allow and deny rules
+	FindRoleDefinitions() (map[string]RoleDefinition, *exceptions.AppError)
+	FindRoles() ([]Role, *exceptions.AppError)
+	CreateRole(role Role) *exceptions.AppError
+	// DeleteRole refuses to delete a role that users still have
+	DeleteRole(roleName string) *exceptions.AppError
+	FindPermissions() ([]Permission, *exceptions.AppError)
+	CreatePermission(permission Permission) *exceptions.AppError
+	UpdatePermission(permission Permission) *exceptions.AppError
+	DeletePermission(permissionName string) *exceptions.AppError
+	// GrantPermission adds an allow or deny rule, the permission may be a wildcard pattern
+	GrantPermission(roleName string, permissionName string, effect string) *exceptions.AppError
+	RevokePermission(roleName string, permissionName string, effect string) *exceptions.AppError
+	AddParentRole(roleName string, parentRoleName string) *exceptions.AppError
+	RemoveParentRole(roleName string, parentRoleName string) *exceptions.AppError
+}
+
+type RoleRepositoryDB struct {
+	client *Database
+}
+
+func (repository RoleRepositoryDB) FindRoleDefinitions() (map[string]RoleDefinition, *exceptions.AppError) {
+	roles, appErr := repository.FindRoles()
+	if appErr != nil {
+		return nil, appErr
+	}
+	var grants []struct {
+		RoleName       string `db:"role_name"`
+		PermissionName string `db:"permission_name"`
+		Effect         string `db:"effect"`
+	}
+	if err := repository.client.Select(&grants, "SELECT role_name, permission_name, effect FROM role_permissions"); err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	var parents []struct {
+		RoleName       string `db:"role_name"`
+		ParentRoleName string `db:"parent_role_name"`
+	}
+	if err := repository.client.Select(&parents, "SELECT role_name, parent_role_name FROM role_parents"); err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	definitions := make(map[string]RoleDefinition, len(roles))
+	for _, role := range roles {
+		definitions[role.Name] = RoleDefinition{Parents: make([]string, 0), Allow: make([]string, 0), Deny: make([]string, 0)}
+	}
+	for _, grant := range grants {
+		definition, ok := definitions[grant.RoleName]
+		if !ok {
+			continue
+		}
+		if grant.Effect == PERMISSION_EFFECT_DENY {
+			definition.Deny = append(definition.Deny, grant.PermissionName)
+		} else {
+			definition.Allow = append(definition.Allow, grant.PermissionName)
+		}
+		definitions[grant.RoleName] = definition
+	}
+	for _, parent := range parents {
+		if definition, ok := definitions[parent.RoleName]; ok {
+			definition.Parents = append(definition.Parents, parent.ParentRoleName)
+			definitions[parent.RoleName] = definition
+		}
+	}
+	return definitions, nil
+}
+
+func (repository RoleRepositoryDB) FindRoles() ([]Role, *exceptions.AppError) {
+	roles := make([]Role, 0)
+	if err := repository.client.Select(&roles, "SELECT role_name, description FROM roles ORDER BY role_name"); err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return roles, nil
+}
+
+func (repository RoleRepositoryDB) CreateRole(role Role) *exceptions.AppError {
+	_, err := repository.client.Exec("INSERT INTO roles (role_name, description) VALUES (?, ?)", role.Name,
+		role.Description)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while creating role")
+	}
+	return nil
+}
+
+func (repository RoleRepositoryDB) DeleteRole(roleName string) *exceptions.AppError {
+	var users int
+	countQuery := "SELECT COUNT(*) FROM user_roles WHERE role_name = ?"
+	if err := repository.client.Get(&users, countQuery, roleName); err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return exceptions.NewDatabaseError("Unexpected database error")
+	}
+	if users > 0 {
+		return exceptions.NewValidationError("role " + roleName + " is still assigned to users")
+	}
+	return repository.deleteWithGrants(roleName, "DELETE FROM role_permissions WHERE role_name = ?",
+		"DELETE FROM role_parents WHERE role_name = ? OR parent_role_name = ?",
+		"DELETE FROM roles WHERE role_name = ?")
+}
+
+func (repository RoleRepositoryDB) FindPermissions() ([]Permission, *exceptions.AppError) {
+	permissions := make([]Permission, 0)
+	selectQuery := "SELECT permission_name, description, scope FROM permissions ORDER BY permission_name"
+	if err := repository.client.Select(&permissions, selectQuery); err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return permissions, nil
+}
+
+func (repository RoleRepositoryDB) CreatePermission(permission Permission) *exceptions.AppError {
+	_, err := repository.client.Exec("INSERT INTO permissions (permission_name, description, scope) VALUES (?, ?, ?)",
+		permission.Name, permission.Description, permission.Scope)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while creating permission")
+	}
+	return nil
+}
+
+func (repository RoleRepositoryDB) UpdatePermission(permission Permission) *exceptions.AppError {
+	_, err := repository.client.Exec("UPDATE permissions SET description = ?, scope = ? WHERE permission_name = ?",
+		permission.Description, permission.Scope, permission.Name)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while updating permission")
+	}
+	return nil
+}
+
+func (repository RoleRepositoryDB) DeletePermission(permissionName string) *exceptions.AppError {
+	return repository.deleteWithGrants(permissionName, "DELETE FROM role_permissions WHERE permission_name = ?",
+		"DELETE FROM permissions WHERE permission_name = ?")
+}
+
+func (repository RoleRepositoryDB) GrantPermission(roleName string, permissionName string, effect string) *exceptions.AppError {
+	insertQuery := "INSERT INTO role_permissions (role_name, permission_name, effect) VALUES (?, ?, ?)"
+	if _, err := repository.client.Exec(insertQuery, roleName, permissionName, effect); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while granting permission")
+	}
+	return nil
+}
+
+func (repository RoleRepositoryDB) RevokePermission(roleName string, permissionName string, effect string) *exceptions.AppError {
+	deleteQuery := "DELETE FROM role_permissions WHERE role_name = ? AND permission_name = ? AND effect = ?"
+	if _, err := repository.client.Exec(deleteQuery, roleName, permissionName, effect); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while revoking permission")
+	}
+	return nil
+}
+
+func (repository RoleRepositoryDB) AddParentRole(roleName string, parentRoleName string) *exceptions.AppError {
+	insertQuery := "INSERT INTO role_parents (role_name, parent_role_name) VALUES (?, ?)"
+	if _, err := repository.client.Exec(insertQuery, roleName, parentRoleName); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while adding parent role")
+	}
+	return nil
+}
+
+func (repository RoleRepositoryDB) RemoveParentRole(roleName string, parentRoleName string) *exceptions.AppError {
+	deleteQuery := "DELETE FROM role_parents WHERE role_name = ? AND parent_role_name = ?"
+	if _, err := repository.client.Exec(deleteQuery, roleName, parentRoleName); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while removing parent role")
+	}
+	return nil
+}
+
+// deleteWithGrants removes a role or permission together with the rows referring to it, each query
+// takes the name for every placeholder
+func (repository RoleRepositoryDB) deleteWithGrants(name string, queries ...string) *exceptions.AppError {
+	tx, err := repository.client.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while deleting " + name)
+	}
+	for _, query := range queries {
+		args := make([]interface{}, strings.Count(query, "?"))
+		for i := range args {
+			args[i] = name
+		}
+		if _, err = tx.Exec(query, args...); err != nil {
+			logger.Error(err.Error())
+			_ = tx.Rollback()
+			return exceptions.NewDatabaseError("Error while deleting " + name)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while deleting " + name)
+	}
+	return nil
+}
+
+func NewRoleRepository(client *Database) RoleRepositoryDB {
+	return RoleRepositoryDB{client}
+}
@@ -1,31 +1,288 @@
 package domain
 
-import "strings"
+import (
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
+const PERMISSION_EFFECT_ALLOW = "allow"
+const PERMISSION_EFFECT_DENY = "deny"
+
+// RoleDefinition is a role as it is stored, Allow and Deny may hold wildcard patterns such as Get* or customers:*
+type RoleDefinition struct {
+	Parents []string
+	Allow   []string
+	Deny    []string
+}
+
+// RolePermissions maps roles to the operations they may call. It is shared by every request and
+// replaced as a whole when the roles are reloaded from the database
 type RolePermissions struct {
-	rolePermissions map[string][]string
+	mutex sync.RWMutex
+	// roles holds each role with the permissions of its ancestors folded in
+	roles map[string]RoleDefinition
+	// operationScopes maps operations to the scope a token needs to call them
+	operationScopes map[string]string
+}
+
+// IsAuthorisedForRole checks the operation against the role and everything it inherits, a deny
+// anywhere in the hierarchy overrides any allow
+func (roles *RolePermissions) IsAuthorisedForRole(role string, operation string) bool {
+	roles.mutex.RLock()
+	defer roles.mutex.RUnlock()
+	return roles.isAuthorised(role, strings.TrimSpace(operation))
+}
+
+func (roles *RolePermissions) isAuthorised(role string, operation string) bool {
+	definition, ok := roles.roles[role]
+	if !ok {
+		return false
+	}
+	for _, pattern := range definition.Deny {
+		if MatchPermission(pattern, operation) {
+			return false
+		}
+	}
+	for _, pattern := range definition.Allow {
+		if MatchPermission(pattern, operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRoleDefined reports whether users can be assigned the role
+func (roles *RolePermissions) IsRoleDefined(role string) bool {
+	roles.mutex.RLock()
+	defer roles.mutex.RUnlock()
+	_, ok := roles.roles[role]
+	return ok
+}
+
+// ScopeFor returns the scope needed to call the operation, empty when no scope covers it
+func (roles *RolePermissions) ScopeFor(operation string) string {
+	roles.mutex.RLock()
+	defer roles.mutex.RUnlock()
+	return roles.operationScopes[strings.TrimSpace(operation)]
 }
 
-func (roles RolePermissions) IsAuthorisedForRole(role string, operation string) bool {
-	permissions := roles.rolePermissions[role]
-	for _, p := range permissions {
-		if p == strings.TrimSpace(operation) {
+// IsApiScope reports whether the scope covers operations, as opposed to scopes like openid
+func (roles *RolePermissions) IsApiScope(scope string) bool {
+	roles.mutex.RLock()
+	defer roles.mutex.RUnlock()
+	for _, operationScope := range roles.operationScopes {
+		if operationScope == scope {
 			return true
 		}
 	}
 	return false
 }
 
-func GetUserRolePermissions() RolePermissions {
-	// create a map of the admin and user permissions
-	return RolePermissions{map[string][]string{
-		"admin": {"GetAllActiveCustomer",
-			"GetAllInActiveCustomer",
-			"GetCustomer",
-			"GetAllCustomer",
-			"NewAccount",
-			"NewTransaction"},
-		"user": {"GetCustomer", "NewTransaction"},
-	},
+// Scopes returns every scope that covers an operation
+func (roles *RolePermissions) Scopes() []string {
+	roles.mutex.RLock()
+	defer roles.mutex.RUnlock()
+	scopes := make(map[string]bool)
+	for _, scope := range roles.operationScopes {
+		scopes[scope] = true
+	}
+	return sortedKeys(scopes)
+}
+
+// ScopesForRoles returns the scopes covering at least one operation the roles are authorised for,
+// these are the most a token issued for the roles can carry
+func (roles *RolePermissions) ScopesForRoles(roleNames []string) []string {
+	roles.mutex.RLock()
+	defer roles.mutex.RUnlock()
+	scopes := make(map[string]bool)
+	for operation, scope := range roles.operationScopes {
+		for _, role := range roleNames {
+			if roles.isAuthorised(role, operation) {
+				scopes[scope] = true
+				break
+			}
+		}
+	}
+	return sortedKeys(scopes)
+}
+
+// Reload replaces the roles with those in the repository, the current roles are kept when loading
+// fails or the hierarchy has a cycle
+func (roles *RolePermissions) Reload(repository RoleRepository) *exceptions.AppError {
+	definitions, appErr := repository.FindRoleDefinitions()
+	if appErr != nil {
+		return appErr
+	}
+	// nobody could be authorised for anything, the roles tables have not been seeded
+	if len(definitions) == 0 {
+		return exceptions.NewDatabaseError("no roles are defined")
+	}
+	resolved, appErr := ResolveRoleHierarchy(definitions)
+	if appErr != nil {
+		return appErr
+	}
+	permissions, appErr := repository.FindPermissions()
+	if appErr != nil {
+		return appErr
+	}
+	operationScopes := make(map[string]string)
+	for _, permission := range permissions {
+		if permission.Scope.String != "" {
+			operationScopes[permission.Name] = permission.Scope.String
+		}
+	}
+	roles.mutex.Lock()
+	roles.roles = resolved
+	roles.operationScopes = operationScopes
+	roles.mutex.Unlock()
+	return nil
+}
+
+// StartAutoRefresh reloads the roles every interval so changes made by other instances are picked
+// up, calling the returned func stops the refresh
+func (roles *RolePermissions) StartAutoRefresh(repository RoleRepository, interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if appErr := roles.Reload(repository); appErr != nil {
+					logger.Error("Unable to refresh roles : " + appErr.Message)
+				}
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// MatchPermission matches an operation against a permission, * in the permission matches any run of characters
+func MatchPermission(pattern string, operation string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == operation
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(operation, parts[0]) {
+		return false
+	}
+	operation = operation[len(parts[0]):]
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		index := strings.Index(operation, part)
+		if index < 0 {
+			return false
+		}
+		operation = operation[index+len(part):]
+	}
+	return strings.HasSuffix(operation, parts[last])
+}
+
+// ResolveRoleHierarchy folds the permissions of every ancestor into each role. Parents that are not
+// defined and cycles are rejected so a bad edit can't leave roles half inherited
+func ResolveRoleHierarchy(definitions map[string]RoleDefinition) (map[string]RoleDefinition, *exceptions.AppError) {
+	resolved := make(map[string]RoleDefinition, len(definitions))
+	// roles on the current path, used to spot a role inheriting from itself
+	visiting := make(map[string]bool)
+	var resolve func(role string, path []string) *exceptions.AppError
+	resolve = func(role string, path []string) *exceptions.AppError {
+		if _, ok := resolved[role]; ok {
+			return nil
+		}
+		path = append(path, role)
+		if visiting[role] {
+			return exceptions.NewValidationError("role hierarchy has a cycle : " + strings.Join(path, " -> "))
+		}
+		definition, ok := definitions[role]
+		if !ok {
+			return exceptions.NewValidationError("role " + path[len(path)-2] + " inherits undefined role " + role)
+		}
+		visiting[role] = true
+		allow := make(map[string]bool)
+		deny := make(map[string]bool)
+		addAll(allow, definition.Allow)
+		addAll(deny, definition.Deny)
+		for _, parent := range definition.Parents {
+			if appErr := resolve(parent, path); appErr != nil {
+				return appErr
+			}
+			addAll(allow, resolved[parent].Allow)
+			addAll(deny, resolved[parent].Deny)
+		}
+		delete(visiting, role)
+		resolved[role] = RoleDefinition{Parents: definition.Parents, Allow: sortedKeys(allow), Deny: sortedKeys(deny)}
+		return nil
+	}
+	for _, role := range sortedRoles(definitions) {
+		if appErr := resolve(role, nil); appErr != nil {
+			return nil, appErr
+		}
+	}
+	return resolved, nil
+}
+
+func addAll(set map[string]bool, values []string) {
+	for _, value := range values {
+		set[value] = true
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedRoles(definitions map[string]RoleDefinition) []string {
+	roles := make([]string, 0, len(definitions))
+	for role := range definitions {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// NewRolePermissions loads the roles from the repository
+func NewRolePermissions(repository RoleRepository) (*RolePermissions, *exceptions.AppError) {
+	roles := &RolePermissions{}
+	if appErr := roles.Reload(repository); appErr != nil {
+		return nil, appErr
+	}
+	return roles, nil
+}
+
+// GetUserRolePermissions returns the built in roles, which the roles tables are seeded with
+func GetUserRolePermissions() map[string]RoleDefinition {
+	// admin gets everything a user can do plus the customer and account management operations
+	return map[string]RoleDefinition{
+		"admin": {Parents: []string{"user"},
+			Allow: []string{"GetAllActiveCustomer",
+				"GetAllInActiveCustomer",
+				"GetAllCustomer",
+				"NewAccount"}},
+		"user": {Allow: []string{"GetCustomer", "NewTransaction"}},
+	}
+}
+
+// GetOperationScopes returns the scopes of the built in operations, which the permissions table is seeded with
+func GetOperationScopes() map[string]string {
+	return map[string]string{
+		"GetAllActiveCustomer":   "customers:read",
+		"GetAllInActiveCustomer": "customers:read",
+		"GetAllCustomer":         "customers:read",
+		"GetCustomer":            "customers:read",
+		"NewAccount":             "accounts:write",
+		"NewTransaction":         "transactions:write",
 	}
 }
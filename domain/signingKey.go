@@ -0,0 +1,214 @@
+package domain
+
+import (
+	"banking-auth/dto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"io/ioutil"
+	"math/big"
+)
+
+// SigningKey pairs a jwt signing method with the key material used to sign and verify tokens.
+// For HMAC the secret is both the signing and the verification key.
+type SigningKey struct {
+	KeyId      string
+	Method     jwt.SigningMethod
+	privateKey interface{}
+	publicKey  interface{}
+}
+
+func (key SigningKey) Sign(token *jwt.Token) (string, error) {
+	if key.KeyId != "" {
+		token.Header["kid"] = key.KeyId
+	}
+	return token.SignedString(key.privateKey)
+}
+
+// Keyfunc is handed to the jwt parser, it refuses tokens signed with a different algorithm
+// so an asymmetric public key can never be used as an HMAC secret
+func (key SigningKey) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	if kid, ok := token.Header["kid"].(string); ok && kid != key.KeyId {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+	return key.publicKey, nil
+}
+
+func (key SigningKey) IsAsymmetric() bool {
+	_, isHmac := key.Method.(*jwt.SigningMethodHMAC)
+	return !isHmac
+}
+
+// Jwk returns the public half of the key as a json web key, HMAC secrets are never published
+func (key SigningKey) Jwk() (*dto.Jwk, error) {
+	jwk := dto.Jwk{KeyId: key.KeyId, Use: "sig", Algorithm: key.Method.Alg()}
+	switch publicKey := key.publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = publicKey.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(publicKey.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(publicKey.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	default:
+		return nil, errors.New("key has no public representation")
+	}
+	return &jwk, nil
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+func NewHmacSigningKey(keyId string, secret []byte) SigningKey {
+	return SigningKey{KeyId: keyId, Method: jwt.SigningMethodHS256, privateKey: secret, publicKey: secret}
+}
+
+// LoadSigningKey reads a PEM encoded private key for one of the RS*, PS*, ES* or EdDSA algorithms
+func LoadSigningKey(keyId string, algorithm string, privateKeyFile string) (*SigningKey, error) {
+	pemBytes, err := ioutil.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKeyFromPEM(keyId, algorithm, pemBytes)
+}
+
+func NewSigningKeyFromPEM(keyId string, algorithm string, pemBytes []byte) (*SigningKey, error) {
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+	key := SigningKey{KeyId: keyId, Method: method}
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		key.privateKey, key.publicKey = privateKey, &privateKey.PublicKey
+	case *jwt.SigningMethodECDSA:
+		privateKey, err := jwt.ParseECPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		if privateKey.Curve.Params().BitSize != m.CurveBits {
+			return nil, fmt.Errorf("%s requires a %d bit curve", algorithm, m.CurveBits)
+		}
+		key.privateKey, key.publicKey = privateKey, &privateKey.PublicKey
+	case *jwt.SigningMethodEd25519:
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("not an ed25519 private key")
+		}
+		key.privateKey, key.publicKey = edKey, edKey.Public().(ed25519.PublicKey)
+	default:
+		return nil, fmt.Errorf("signing algorithm %s is not asymmetric", algorithm)
+	}
+	if key.KeyId == "" {
+		// default the key id to the RFC 7638 thumbprint so every token carries a kid
+		jwk, err := key.Jwk()
+		if err != nil {
+			return nil, err
+		}
+		key.KeyId = jwkThumbprint(*jwk)
+	}
+	return &key, nil
+}
+
+// GenerateSigningKey creates fresh key material for the algorithm, used for automatic rotation
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+	key := SigningKey{Method: method}
+	switch m := method.(type) {
+	case *jwt.SigningMethodHMAC:
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		key.privateKey, key.publicKey = secret, secret
+		kid := make([]byte, 16)
+		if _, err := rand.Read(kid); err != nil {
+			return nil, err
+		}
+		key.KeyId = base64.RawURLEncoding.EncodeToString(kid)
+		return &key, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key.privateKey, key.publicKey = privateKey, &privateKey.PublicKey
+	case *jwt.SigningMethodECDSA:
+		var curve elliptic.Curve
+		switch m.CurveBits {
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		default:
+			curve = elliptic.P521()
+		}
+		privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.privateKey, key.publicKey = privateKey, &privateKey.PublicKey
+	case *jwt.SigningMethodEd25519:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.privateKey, key.publicKey = privateKey, publicKey
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+	jwk, err := key.Jwk()
+	if err != nil {
+		return nil, err
+	}
+	key.KeyId = jwkThumbprint(*jwk)
+	return &key, nil
+}
+
+func jwkThumbprint(jwk dto.Jwk) string {
+	var members string
+	switch jwk.KeyType {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Curve, jwk.X, jwk.Y)
+	default:
+		members = fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s"}`, jwk.Curve, jwk.KeyType, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
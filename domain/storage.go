@@ -0,0 +1,48 @@
+package domain
+
+// Storage holds the repositories of one storage backend, services only ever see the interfaces so
+// the backend can be swapped through configuration
+type Storage struct {
+	Auth               AuthRepository
+	UserAccounts       UserAccountRepository
+	Roles              RoleRepository
+	Mfa                MfaRepository
+	WebAuthn           WebAuthnRepository
+	LoginAttempts      LoginAttemptRepository
+	Clients            ClientRepository
+	AuthorizationCodes AuthorizationCodeRepository
+	// Close releases the backend, e.g. the database connection pool
+	Close func() error
+}
+
+// NewDatabaseStorage uses the MySQL, PostgreSQL or SQLite database for every repository
+func NewDatabaseStorage(client *Database) Storage {
+	return Storage{
+		Auth:               NewUserRepository(client),
+		UserAccounts:       NewUserAccountRepository(client),
+		Roles:              NewRoleRepository(client),
+		Mfa:                NewMfaRepository(client),
+		WebAuthn:           NewWebAuthnRepository(client),
+		LoginAttempts:      NewLoginAttemptRepository(client),
+		Clients:            NewClientRepository(client),
+		AuthorizationCodes: NewAuthorizationCodeRepository(client),
+		Close:              client.Close,
+	}
+}
+
+// NewInMemoryStorage uses the store for every repository
+func NewInMemoryStorage(store *InMemoryStore) Storage {
+	return Storage{
+		Auth:               store,
+		UserAccounts:       store,
+		Roles:              store,
+		Mfa:                store,
+		WebAuthn:           store,
+		LoginAttempts:      store,
+		Clients:            store,
+		AuthorizationCodes: store,
+		Close: func() error {
+			return nil
+		},
+	}
+}
@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TOTP_PERIOD int64 = 30
+	TOTP_DIGITS int   = 6
+	// codes from one step either side are accepted to allow for clock drift
+	TOTP_SKEW int64 = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTotpSecret returns a random 160 bit secret, base32 encoded as authenticator apps expect
+func NewTotpSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TotpProvisioningUri builds the otpauth uri rendered as a QR code for authenticator apps
+func TotpProvisioningUri(issuer string, userName string, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", TOTP_DIGITS))
+	params.Set("period", fmt.Sprintf("%d", TOTP_PERIOD))
+	label := url.PathEscape(issuer + ":" + userName)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TotpCode computes the RFC 6238 code for the time step
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	// RFC 4226 dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%modulo), nil
+}
+
+func TotpStep(t time.Time) int64 {
+	return t.Unix() / TOTP_PERIOD
+}
+
+// ValidateTotpCode returns the matching time step so the caller can refuse a replay of the
+// same or an earlier step, zero means the code did not match
+func ValidateTotpCode(secret string, code string, now time.Time) int64 {
+	current := TotpStep(now)
+	for step := current - TOTP_SKEW; step <= current+TOTP_SKEW; step++ {
+		expected, err := TotpCode(secret, step)
+		if err != nil {
+			return 0
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+// NewRecoveryCodes returns single use codes formatted as xxxxx-xxxxx for users who lose their authenticator
+func NewRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// NormaliseRecoveryCode lets users type recovery codes without the dash or in upper case
+func NormaliseRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
@@ -4,13 +4,51 @@ type User struct {
 	UserName       string
 	Password       string
 	CustomerId     int `db:"customer_id"`
-	Role           string
+	Roles          []string
 	AccountNumbers string `db:"account_numbers"`
 	CreatedDate    string `db:"created_on"`
+	Status         string
+	// unix time of the last password change, 0 when it predates password ages being recorded
+	PasswordChangedAt int64
 }
 
-func (claims AccessTokenClaims) IsUserRole() bool {
-	return claims.Role == "user"
+// IsActive reports whether the user may log in, rows created before user statuses existed have none
+func (user User) IsActive() bool {
+	return user.Status == "" || user.Status == USER_STATUS_ACTIVE
+}
+
+// CUSTOMER_ROLE is the role of customers, it only grants operations on the customer's own accounts
+const CUSTOMER_ROLE string = "user"
+
+func IsCustomerRole(role string) bool {
+	return role == CUSTOMER_ROLE
+}
+
+// RoleNames returns the roles the token was issued for, tokens issued before users could have
+// several roles carry a single role claim instead
+func (claims AccessTokenClaims) RoleNames() []string {
+	if len(claims.Roles) > 0 {
+		return claims.Roles
+	}
+	if claims.Role != "" {
+		return []string{claims.Role}
+	}
+	return nil
+}
+
+func (claims AccessTokenClaims) HasRole(role string) bool {
+	return contains(claims.RoleNames(), role)
+}
+
+// IsMachineToken reports whether the token was issued to a client through the client credentials grant
+func (claims AccessTokenClaims) IsMachineToken() bool {
+	return claims.TokenType == MACHINE_TOKEN_TYPE
+}
+
+// IsUserAccessToken reports whether the token was issued to a user, tokens issued at login have no token
+// type and refreshed ones are typed access. Id tokens verify with the same keys but carry no user name
+func (claims AccessTokenClaims) IsUserAccessToken() bool {
+	return (claims.TokenType == "" || claims.TokenType == "access") && claims.UserName != ""
 }
 
 func (claims AccessTokenClaims) IsRequestParamsVerifiedWithTokenClaims(params map[string]string) bool {
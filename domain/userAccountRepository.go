@@ -0,0 +1,298 @@
+package domain
+
+import (
+	"database/sql"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"time"
+)
+
+const (
+	USER_STATUS_ACTIVE   string = "active"
+	USER_STATUS_DISABLED string = "disabled"
+	// self registered users stay pending until they follow the link sent to their email
+	USER_STATUS_PENDING string = "pending_verification"
+)
+
+const EMAIL_VERIFICATION_DURATION time.Duration = time.Hour * 24
+const PASSWORD_RESET_DURATION time.Duration = time.Minute * 30
+
+// UserAccount is a row of USERS as managed through the user api, the password hash is never read back
+type UserAccount struct {
+	UserName    string         `db:"username"`
+	Email       sql.NullString `db:"email"`
+	Roles       []string       `db:"-"`
+	CustomerId  sql.NullInt64  `db:"customer_id"`
+	Status      string         `db:"status"`
+	CreatedDate sql.NullString `db:"created_on"`
+}
+
+type UserAccountRepository interface {
+	// FindUserAccount returns nil when the user does not exist
+	FindUserAccount(userName string) (*UserAccount, *exceptions.AppError)
+	FindUserAccounts() ([]UserAccount, *exceptions.AppError)
+	CreateUser(account UserAccount, passwordHash string) *exceptions.AppError
+	UpdateUser(account UserAccount) *exceptions.AppError
+	SetUserStatus(userName string, status string) *exceptions.AppError
+	// DeleteUser removes the user together with their second factors and outstanding tokens
+	DeleteUser(userName string) *exceptions.AppError
+	SaveVerificationToken(tokenHash string, userName string, expiresAt int64) *exceptions.AppError
+	// ConsumeVerificationToken returns the user the unused, unexpired token was issued to
+	ConsumeVerificationToken(tokenHash string) (string, *exceptions.AppError)
+	SavePasswordResetToken(tokenHash string, userName string, expiresAt int64) *exceptions.AppError
+	// FindPasswordResetToken returns the user the unused, unexpired token was issued to without using it up
+	FindPasswordResetToken(tokenHash string) (string, *exceptions.AppError)
+	// ConsumePasswordResetToken returns the user the unused, unexpired token was issued to
+	ConsumePasswordResetToken(tokenHash string) (string, *exceptions.AppError)
+	// InvalidatePasswordResetTokens marks every outstanding reset token of the user used
+	InvalidatePasswordResetTokens(userName string) *exceptions.AppError
+}
+
+type UserAccountRepositoryDB struct {
+	client *Database
+}
+
+// rows created before user statuses existed count as active
+const userAccountColumns = "username, email, customer_id, COALESCE(status, 'active') AS status, created_on"
+
+// findUserRoles maps users to their roles, all users when userName is empty
+func findUserRoles(client *Database, userName string) (map[string][]string, error) {
+	var rows []struct {
+		UserName string `db:"user_name"`
+		RoleName string `db:"role_name"`
+	}
+	selectQuery := "SELECT user_name, role_name FROM user_roles"
+	var err error
+	if userName == "" {
+		err = client.Select(&rows, selectQuery+" ORDER BY user_name, role_name")
+	} else {
+		err = client.Select(&rows, selectQuery+" WHERE user_name = ? ORDER BY role_name", userName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	userRoles := make(map[string][]string)
+	for _, row := range rows {
+		userRoles[row.UserName] = append(userRoles[row.UserName], row.RoleName)
+	}
+	return userRoles, nil
+}
+
+func (repository UserAccountRepositoryDB) FindUserAccount(userName string) (*UserAccount, *exceptions.AppError) {
+	var account UserAccount
+	err := repository.client.Get(&account, "SELECT "+userAccountColumns+" FROM USERS WHERE username = ?", userName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	userRoles, err := findUserRoles(repository.client, userName)
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	account.Roles = userRoles[userName]
+	return &account, nil
+}
+
+func (repository UserAccountRepositoryDB) FindUserAccounts() ([]UserAccount, *exceptions.AppError) {
+	accounts := make([]UserAccount, 0)
+	if err := repository.client.Select(&accounts, "SELECT "+userAccountColumns+" FROM USERS ORDER BY username"); err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	userRoles, err := findUserRoles(repository.client, "")
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	for i := range accounts {
+		accounts[i].Roles = userRoles[accounts[i].UserName]
+	}
+	return accounts, nil
+}
+
+// CreateUser stores the user and their roles
+func (repository UserAccountRepositoryDB) CreateUser(account UserAccount, passwordHash string) *exceptions.AppError {
+	tx, err := repository.client.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while creating user")
+	}
+	insertQuery := "INSERT INTO USERS (username, password, email, customer_id, status, created_on, " +
+		"password_changed_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	now := time.Now()
+	_, err = tx.Exec(insertQuery, account.UserName, passwordHash, account.Email, account.CustomerId, account.Status,
+		now.Format("2006-01-02 15:04:05"), now.Unix())
+	if err == nil {
+		err = insertUserRoles(tx, account.UserName, account.Roles)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		_ = tx.Rollback()
+		return exceptions.NewDatabaseError("Error while creating user")
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while creating user")
+	}
+	return nil
+}
+
+// UpdateUser replaces the user's email, customer link and roles
+func (repository UserAccountRepositoryDB) UpdateUser(account UserAccount) *exceptions.AppError {
+	tx, err := repository.client.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while updating user")
+	}
+	updateQuery := "UPDATE USERS SET email = ?, customer_id = ? WHERE username = ?"
+	_, err = tx.Exec(updateQuery, account.Email, account.CustomerId, account.UserName)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM user_roles WHERE user_name = ?", account.UserName)
+	}
+	if err == nil {
+		err = insertUserRoles(tx, account.UserName, account.Roles)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		_ = tx.Rollback()
+		return exceptions.NewDatabaseError("Error while updating user")
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while updating user")
+	}
+	return nil
+}
+
+func insertUserRoles(tx *Transaction, userName string, roles []string) error {
+	for _, role := range roles {
+		if _, err := tx.Exec("INSERT INTO user_roles (user_name, role_name) VALUES (?, ?)", userName, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repository UserAccountRepositoryDB) SetUserStatus(userName string, status string) *exceptions.AppError {
+	if _, err := repository.client.Exec("UPDATE USERS SET status = ? WHERE username = ?", status, userName); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while updating user status")
+	}
+	return nil
+}
+
+func (repository UserAccountRepositoryDB) DeleteUser(userName string) *exceptions.AppError {
+	tx, err := repository.client.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while deleting user")
+	}
+	deleteQueries := []string{
+		"DELETE FROM mfa_recovery_codes WHERE user_name = ?",
+		"DELETE FROM user_mfa WHERE user_name = ?",
+		"DELETE FROM webauthn_credentials WHERE user_name = ?",
+		"DELETE FROM email_verification_tokens WHERE user_name = ?",
+		"DELETE FROM password_reset_tokens WHERE user_name = ?",
+		"DELETE FROM user_roles WHERE user_name = ?",
+		"DELETE FROM USERS WHERE username = ?",
+	}
+	for _, deleteQuery := range deleteQueries {
+		if _, err = tx.Exec(deleteQuery, userName); err != nil {
+			logger.Error(err.Error())
+			_ = tx.Rollback()
+			return exceptions.NewDatabaseError("Error while deleting user")
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while deleting user")
+	}
+	return nil
+}
+
+func (repository UserAccountRepositoryDB) SaveVerificationToken(tokenHash string, userName string, expiresAt int64) *exceptions.AppError {
+	return repository.saveUserToken("email_verification_tokens", tokenHash, userName, expiresAt)
+}
+
+func (repository UserAccountRepositoryDB) ConsumeVerificationToken(tokenHash string) (string, *exceptions.AppError) {
+	return repository.consumeUserToken("email_verification_tokens", tokenHash, "invalid or expired verification token")
+}
+
+func (repository UserAccountRepositoryDB) SavePasswordResetToken(tokenHash string, userName string, expiresAt int64) *exceptions.AppError {
+	return repository.saveUserToken("password_reset_tokens", tokenHash, userName, expiresAt)
+}
+
+func (repository UserAccountRepositoryDB) FindPasswordResetToken(tokenHash string) (string, *exceptions.AppError) {
+	return repository.findUserToken("password_reset_tokens", tokenHash, "invalid or expired password reset token")
+}
+
+func (repository UserAccountRepositoryDB) ConsumePasswordResetToken(tokenHash string) (string, *exceptions.AppError) {
+	return repository.consumeUserToken("password_reset_tokens", tokenHash, "invalid or expired password reset token")
+}
+
+func (repository UserAccountRepositoryDB) InvalidatePasswordResetTokens(userName string) *exceptions.AppError {
+	return repository.invalidateUserTokens("password_reset_tokens", userName)
+}
+
+// saveUserToken stores a single use token in one of the user token tables, which all share the same columns
+func (repository UserAccountRepositoryDB) saveUserToken(table string, tokenHash string, userName string, expiresAt int64) *exceptions.AppError {
+	insertQuery := "INSERT INTO " + table + " (token_hash, user_name, expires_at, used) VALUES (?, ?, ?, 0)"
+	if _, err := repository.client.Exec(insertQuery, tokenHash, userName, expiresAt); err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while storing token")
+	}
+	return nil
+}
+
+func (repository UserAccountRepositoryDB) consumeUserToken(table string, tokenHash string, invalidMessage string) (string, *exceptions.AppError) {
+	userName, appErr := repository.findUserToken(table, tokenHash, invalidMessage)
+	if appErr != nil {
+		return "", appErr
+	}
+	result, err := repository.client.Exec("UPDATE "+table+" SET used = 1 WHERE token_hash = ? AND used = 0", tokenHash)
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return "", exceptions.NewDatabaseError("Unexpected database error")
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != 1 {
+		return "", exceptions.NewValidationError(invalidMessage)
+	}
+	return userName, nil
+}
+
+// findUserToken returns the user an unused, unexpired token was issued to
+func (repository UserAccountRepositoryDB) findUserToken(table string, tokenHash string, invalidMessage string) (string, *exceptions.AppError) {
+	var token struct {
+		UserName  string `db:"user_name"`
+		ExpiresAt int64  `db:"expires_at"`
+	}
+	selectQuery := "SELECT user_name, expires_at FROM " + table + " WHERE token_hash = ? AND used = 0"
+	err := repository.client.Get(&token, selectQuery, tokenHash)
+	if err == sql.ErrNoRows {
+		return "", exceptions.NewValidationError(invalidMessage)
+	}
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return "", exceptions.NewDatabaseError("Unexpected database error")
+	}
+	if time.Now().Unix() > token.ExpiresAt {
+		return "", exceptions.NewValidationError(invalidMessage)
+	}
+	return token.UserName, nil
+}
+
+func (repository UserAccountRepositoryDB) invalidateUserTokens(table string, userName string) *exceptions.AppError {
+	if _, err := repository.client.Exec("UPDATE "+table+" SET used = 1 WHERE user_name = ? AND used = 0", userName); err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return nil
+}
+
+func NewUserAccountRepository(client *Database) UserAccountRepositoryDB {
+	return UserAccountRepositoryDB{client}
+}
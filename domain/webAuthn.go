@@ -0,0 +1,208 @@
+package domain
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"math/big"
+)
+
+const (
+	WEBAUTHN_CEREMONY_REGISTRATION   string = "webauthn.create"
+	WEBAUTHN_CEREMONY_AUTHENTICATION string = "webauthn.get"
+)
+
+// COSE algorithm identifiers accepted for credential public keys
+const (
+	COSE_ALG_ES256 int64 = -7
+	COSE_ALG_EDDSA int64 = -8
+	COSE_ALG_RS256 int64 = -257
+)
+
+const (
+	authenticatorFlagUserPresent  byte = 0x01
+	authenticatorFlagUserVerified byte = 0x04
+	authenticatorFlagAttestedData byte = 0x40
+)
+
+// CollectedClientData is the clientDataJSON the browser signs over
+type CollectedClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// AuthenticatorData is the parsed authenticator data, the credential fields are only
+// present during registration
+type AuthenticatorData struct {
+	RpIdHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialId []byte
+	PublicKey    []byte
+}
+
+func (authData AuthenticatorData) UserPresent() bool {
+	return authData.Flags&authenticatorFlagUserPresent != 0
+}
+
+func (authData AuthenticatorData) UserVerified() bool {
+	return authData.Flags&authenticatorFlagUserVerified != 0
+}
+
+// VerifyRpId checks the authenticator scoped the credential to our relying party id
+func (authData AuthenticatorData) VerifyRpId(rpId string) bool {
+	expected := sha256.Sum256([]byte(rpId))
+	return subtle.ConstantTimeCompare(authData.RpIdHash, expected[:]) == 1
+}
+
+type attestationObject struct {
+	Format   string          `cbor:"fmt"`
+	AuthData []byte          `cbor:"authData"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+}
+
+func ParseClientData(clientDataJSON []byte) (*CollectedClientData, error) {
+	var clientData CollectedClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return nil, err
+	}
+	return &clientData, nil
+}
+
+// ParseAttestationObject returns the authenticator data of a registration. Registration asks for
+// "none" attestation so the attestation statement is not verified, the credential is trusted
+// because the user was authenticated when the ceremony started
+func ParseAttestationObject(data []byte) (*AuthenticatorData, error) {
+	var attestation attestationObject
+	if err := cbor.Unmarshal(data, &attestation); err != nil {
+		return nil, err
+	}
+	authData, err := ParseAuthenticatorData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.CredentialId == nil {
+		return nil, errors.New("attestation has no attested credential data")
+	}
+	return authData, nil
+}
+
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+	authData := AuthenticatorData{
+		RpIdHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.Flags&authenticatorFlagAttestedData == 0 {
+		return &authData, nil
+	}
+	// aaguid(16) credentialIdLength(2) credentialId credentialPublicKey
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, errors.New("credential id too short")
+	}
+	authData.CredentialId = rest[:idLength]
+	// the public key is followed by optional extensions, decode exactly one cbor item
+	var publicKey cbor.RawMessage
+	if err := cbor.NewDecoder(bytes.NewReader(rest[idLength:])).Decode(&publicKey); err != nil {
+		return nil, err
+	}
+	if _, _, err := ParseCosePublicKey(publicKey); err != nil {
+		return nil, err
+	}
+	authData.PublicKey = publicKey
+	return &authData, nil
+}
+
+// ParseCosePublicKey converts a COSE_Key to a go public key and its algorithm
+func ParseCosePublicKey(coseKey []byte) (crypto.PublicKey, int64, error) {
+	var key map[int64]interface{}
+	if err := cbor.Unmarshal(coseKey, &key); err != nil {
+		return nil, 0, err
+	}
+	alg, _ := key[3].(int64)
+	switch alg {
+	case COSE_ALG_ES256:
+		x, xOk := key[-2].([]byte)
+		y, yOk := key[-3].([]byte)
+		if !xOk || !yOk || coseInt(key[-1]) != 1 {
+			return nil, 0, errors.New("invalid ES256 cose key")
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, 0, errors.New("ES256 cose key is not on the curve")
+		}
+		return publicKey, alg, nil
+	case COSE_ALG_EDDSA:
+		x, ok := key[-2].([]byte)
+		if !ok || len(x) != ed25519.PublicKeySize || coseInt(key[-1]) != 6 {
+			return nil, 0, errors.New("invalid EdDSA cose key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case COSE_ALG_RS256:
+		n, nOk := key[-1].([]byte)
+		e, eOk := key[-2].([]byte)
+		if !nOk || !eOk {
+			return nil, 0, errors.New("invalid RS256 cose key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported cose algorithm %d", alg)
+}
+
+// cbor decodes positive integers as uint64 and negative ones as int64
+func coseInt(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case uint64:
+		return int64(v)
+	}
+	return 0
+}
+
+// VerifyAssertionSignature checks the signature an authenticator made over authenticatorData || sha256(clientDataJSON)
+func VerifyAssertionSignature(coseKey []byte, authenticatorData []byte, clientDataJSON []byte, signature []byte) error {
+	publicKey, alg, err := ParseCosePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+	switch alg {
+	case COSE_ALG_ES256:
+		digest := sha256.Sum256(signed)
+		if !ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), digest[:], signature) {
+			return errors.New("invalid assertion signature")
+		}
+	case COSE_ALG_EDDSA:
+		if !ed25519.Verify(publicKey.(ed25519.PublicKey), signed, signature) {
+			return errors.New("invalid assertion signature")
+		}
+	case COSE_ALG_RS256:
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid assertion signature")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package domain
+
+import (
+	"database/sql"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"time"
+)
+
+const WEBAUTHN_CHALLENGE_DURATION time.Duration = time.Minute * 5
+
+// WebAuthnCredential is a passkey registered by a user, the credential id is base64url encoded
+// and the public key is kept in its COSE encoding
+type WebAuthnCredential struct {
+	CredentialId string `db:"credential_id"`
+	UserName     string `db:"user_name"`
+	PublicKey    []byte `db:"public_key"`
+	SignCount    int64  `db:"sign_count"`
+}
+
+// WebAuthnChallenge is an outstanding registration or authentication ceremony, the user name
+// is empty for a usernameless passkey login
+type WebAuthnChallenge struct {
+	Challenge string `db:"challenge"`
+	UserName  string `db:"user_name"`
+	Ceremony  string `db:"ceremony"`
+	ExpiresAt int64  `db:"expires_at"`
+}
+
+func (challenge WebAuthnChallenge) HasExpired() bool {
+	return time.Now().Unix() > challenge.ExpiresAt
+}
+
+type WebAuthnRepository interface {
+	SaveChallenge(challenge WebAuthnChallenge) *exceptions.AppError
+	// ConsumeChallenge deletes and returns the challenge so each one can only be answered once
+	ConsumeChallenge(challenge string) (*WebAuthnChallenge, *exceptions.AppError)
+	FindCredentials(userName string) ([]WebAuthnCredential, *exceptions.AppError)
+	FindCredential(credentialId string) (*WebAuthnCredential, *exceptions.AppError)
+	SaveCredential(credential WebAuthnCredential) *exceptions.AppError
+	UpdateSignCount(credentialId string, signCount int64) *exceptions.AppError
+}
+
+type WebAuthnRepositoryDB struct {
+	client *Database
+}
+
+func (repository WebAuthnRepositoryDB) SaveChallenge(challenge WebAuthnChallenge) *exceptions.AppError {
+	insertQuery := "INSERT INTO webauthn_challenges (challenge, user_name, ceremony, expires_at) VALUES (?, ?, ?, ?)"
+	_, err := repository.client.Exec(insertQuery, challenge.Challenge, challenge.UserName, challenge.Ceremony,
+		challenge.ExpiresAt)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while storing webauthn challenge")
+	}
+	if _, err = repository.client.Exec("DELETE FROM webauthn_challenges WHERE expires_at < ?",
+		time.Now().Unix()); err != nil {
+		logger.Error("Error while deleting expired webauthn challenges : " + err.Error())
+	}
+	return nil
+}
+
+func (repository WebAuthnRepositoryDB) ConsumeChallenge(challenge string) (*WebAuthnChallenge, *exceptions.AppError) {
+	selectQuery := "SELECT challenge, user_name, ceremony, expires_at FROM webauthn_challenges WHERE challenge = ?"
+	var stored WebAuthnChallenge
+	err := repository.client.Get(&stored, selectQuery, challenge)
+	if err == sql.ErrNoRows {
+		return nil, exceptions.NewUnauthorisedError("unknown webauthn challenge")
+	}
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	result, err := repository.client.Exec("DELETE FROM webauthn_challenges WHERE challenge = ?", challenge)
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != 1 {
+		return nil, exceptions.NewUnauthorisedError("unknown webauthn challenge")
+	}
+	return &stored, nil
+}
+
+func (repository WebAuthnRepositoryDB) FindCredentials(userName string) ([]WebAuthnCredential, *exceptions.AppError) {
+	selectQuery := "SELECT credential_id, user_name, public_key, sign_count FROM webauthn_credentials WHERE user_name = ?"
+	credentials := make([]WebAuthnCredential, 0)
+	if err := repository.client.Select(&credentials, selectQuery, userName); err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return credentials, nil
+}
+
+func (repository WebAuthnRepositoryDB) FindCredential(credentialId string) (*WebAuthnCredential, *exceptions.AppError) {
+	selectQuery := "SELECT credential_id, user_name, public_key, sign_count FROM webauthn_credentials WHERE credential_id = ?"
+	var credential WebAuthnCredential
+	err := repository.client.Get(&credential, selectQuery, credentialId)
+	if err == sql.ErrNoRows {
+		return nil, exceptions.NewUnauthorisedError("unknown webauthn credential")
+	}
+	if err != nil {
+		logger.Error("Unexpected database error" + err.Error())
+		return nil, exceptions.NewDatabaseError("Unexpected database error")
+	}
+	return &credential, nil
+}
+
+func (repository WebAuthnRepositoryDB) SaveCredential(credential WebAuthnCredential) *exceptions.AppError {
+	insertQuery := "INSERT INTO webauthn_credentials (credential_id, user_name, public_key, sign_count) VALUES (?, ?, ?, ?)"
+	_, err := repository.client.Exec(insertQuery, credential.CredentialId, credential.UserName, credential.PublicKey,
+		credential.SignCount)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while storing webauthn credential")
+	}
+	return nil
+}
+
+func (repository WebAuthnRepositoryDB) UpdateSignCount(credentialId string, signCount int64) *exceptions.AppError {
+	_, err := repository.client.Exec("UPDATE webauthn_credentials SET sign_count = ? WHERE credential_id = ?",
+		signCount, credentialId)
+	if err != nil {
+		logger.Error(err.Error())
+		return exceptions.NewDatabaseError("Error while updating webauthn sign count")
+	}
+	return nil
+}
+
+func NewWebAuthnRepository(client *Database) WebAuthnRepositoryDB {
+	return WebAuthnRepositoryDB{client}
+}
@@ -6,6 +6,12 @@ type Login struct {
 	UserName       string
 	Password       string
 	CustomerId     sql.NullString
-	Role           string
+	Roles          []string
 	AccountNumbers sql.NullString
+	// set when the login was made through an OAuth client
+	ClientId string
+	Scope    string
+	// OpenID Connect nonce and the time the user authenticated, used for the id token
+	Nonce    string
+	AuthTime int64
 }
@@ -0,0 +1,18 @@
+package dto
+
+// Jwk is the RFC 7517 json web key representation of a public verification key
+type Jwk struct {
+	KeyType   string `json:"kty"`
+	KeyId     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JwkSet struct {
+	Keys []Jwk `json:"keys"`
+}
@@ -5,6 +5,16 @@ package dto
 type LoginResponse struct {
 	UserName     string `json:"user_name,omitempty"`
 	LoginTime    string `json:"access_time,omitempty"`
-	Token        string `json:"access_token"`
+	Token        string `json:"access_token,omitempty"`
 	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// set instead of the tokens when the user must complete a second factor at /customers/login/mfa
+	MfaRequired bool `json:"mfa_required,omitempty"`
+	// set instead of the tokens when mfa is enforced and the user has no second factor, the mfa token
+	// then only authorises /customers/mfa/totp and /customers/mfa/totp/confirm
+	MfaEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	MfaToken              string `json:"mfa_token,omitempty"`
+	// the password is older than the policy allows, the front end should send the user to change it
+	PasswordExpired bool `json:"password_expired,omitempty"`
 }
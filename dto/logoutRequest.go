@@ -0,0 +1,6 @@
+package dto
+
+type LogoutRequest struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
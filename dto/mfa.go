@@ -0,0 +1,30 @@
+package dto
+
+type MfaLoginRequest struct {
+	MfaToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+	// set by the handler from the connection, never read from the body
+	ClientIp string `json:"-" xml:"-"`
+}
+
+// MfaEnrollRequest proves the user holds the current second factor, with a one-time or recovery code
+// or the password, before a confirmed enrollment is replaced. It is empty for a first enrollment
+type MfaEnrollRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+	// set by the handler from the connection, never read from the body
+	ClientIp string `json:"-"`
+}
+
+type MfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TotpEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningUri string `json:"provisioning_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
@@ -0,0 +1,64 @@
+package dto
+
+// OAuth error codes from RFC 6749 section 5.2
+const (
+	OAUTH_INVALID_REQUEST           string = "invalid_request"
+	OAUTH_INVALID_CLIENT            string = "invalid_client"
+	OAUTH_INVALID_GRANT             string = "invalid_grant"
+	OAUTH_INVALID_SCOPE             string = "invalid_scope"
+	OAUTH_ACCESS_DENIED             string = "access_denied"
+	OAUTH_UNSUPPORTED_GRANT_TYPE    string = "unsupported_grant_type"
+	OAUTH_UNSUPPORTED_RESPONSE_TYPE string = "unsupported_response_type"
+	OAUTH_SERVER_ERROR              string = "server_error"
+)
+
+const (
+	GRANT_TYPE_CLIENT_CREDENTIALS string = "client_credentials"
+	GRANT_TYPE_AUTHORIZATION_CODE string = "authorization_code"
+	GRANT_TYPE_REFRESH_TOKEN      string = "refresh_token"
+	RESPONSE_TYPE_CODE            string = "code"
+	CODE_CHALLENGE_METHOD_S256    string = "S256"
+)
+
+// AuthorizeRequest carries the /oauth/authorize parameters, PKCE with S256 is mandatory
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientId            string
+	RedirectUri         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// TokenResponse is the RFC 6749 successful token response
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+}
+
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// IntrospectionResponse is the RFC 7662 introspection response, inactive tokens only carry active=false
+type IntrospectionResponse struct {
+	Active     bool     `json:"active"`
+	Scope      string   `json:"scope,omitempty"`
+	ClientId   string   `json:"client_id,omitempty"`
+	UserName   string   `json:"username,omitempty"`
+	TokenType  string   `json:"token_type,omitempty"`
+	ExpiresAt  int64    `json:"exp,omitempty"`
+	IssuedAt   int64    `json:"iat,omitempty"`
+	Subject    string   `json:"sub,omitempty"`
+	Jti        string   `json:"jti,omitempty"`
+	CustomerId string   `json:"customer_id,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	Accounts   []string `json:"accounts,omitempty"`
+}
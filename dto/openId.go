@@ -0,0 +1,31 @@
+package dto
+
+const SCOPE_OPENID string = "openid"
+const SCOPE_PROFILE string = "profile"
+
+// OpenIdConfiguration is the OpenID Connect discovery document
+type OpenIdConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type UserInfoResponse struct {
+	Subject           string   `json:"sub"`
+	PreferredUserName string   `json:"preferred_username,omitempty"`
+	CustomerId        string   `json:"customer_id,omitempty"`
+	Roles             []string `json:"roles,omitempty"`
+	Accounts          []string `json:"accounts,omitempty"`
+}
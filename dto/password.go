@@ -0,0 +1,37 @@
+package dto
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ForgotPasswordRequest struct {
+	UserName string `json:"username"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type PasswordCheckRequest struct {
+	Password string `json:"password"`
+}
+
+// PasswordRuleViolation is one failed password policy rule, Rule is a stable code and Message is for display
+type PasswordRuleViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyErrorResponse is the 422 body when a password being set breaks the policy, Message
+// matches the other error bodies and Violations lists every broken rule
+type PasswordPolicyErrorResponse struct {
+	Message    string
+	Violations []PasswordRuleViolation `json:"violations"`
+}
+
+type PasswordCheckResponse struct {
+	Valid      bool                    `json:"valid"`
+	Violations []PasswordRuleViolation `json:"violations"`
+}
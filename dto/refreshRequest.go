@@ -7,18 +7,16 @@ import (
 	"time"
 )
 
-const SECRET_WORD string = "Today is good day to code"
-
 type RefreshTokenRequest struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	// optional, narrows the scope of the new tokens to a subset of the current scope
+	Scope string `json:"scope"`
 }
 
-func (r RefreshTokenRequest) IsAccessTokenValid() *jwt.ValidationError {
+func (r RefreshTokenRequest) IsAccessTokenValid(keyFunc jwt.Keyfunc) *jwt.ValidationError {
 	var validationError *jwt.ValidationError
-	token, err := jwt.Parse(r.AccessToken, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SECRET_WORD), nil
-	})
+	token, err := jwt.Parse(r.AccessToken, keyFunc)
 	if err != nil {
 		logger.Error("Unable to parse token : " + err.Error())
 		if errors.As(err, &validationError) {
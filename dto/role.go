@@ -0,0 +1,26 @@
+package dto
+
+type RoleRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type RoleResponse struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Parents     []string `json:"parents"`
+	Permissions []string `json:"permissions"`
+	Denied      []string `json:"denied"`
+}
+
+type PermissionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Scope       string `json:"scope"`
+}
+
+type PermissionResponse struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+}
@@ -0,0 +1,26 @@
+package dto
+
+// CreateUserRequest is used by admins creating users and, without the roles and customer id, by self registration
+type CreateUserRequest struct {
+	UserName   string   `json:"username"`
+	Password   string   `json:"password"`
+	Email      string   `json:"email"`
+	Roles      []string `json:"roles,omitempty"`
+	CustomerId *int64   `json:"customer_id,omitempty"`
+}
+
+// UpdateUserRequest replaces the user's email, roles and customer link, a nil customer id unlinks the user
+type UpdateUserRequest struct {
+	Email      string   `json:"email"`
+	Roles      []string `json:"roles"`
+	CustomerId *int64   `json:"customer_id"`
+}
+
+type UserResponse struct {
+	UserName   string   `json:"username"`
+	Email      string   `json:"email,omitempty"`
+	Roles      []string `json:"roles"`
+	CustomerId *int64   `json:"customer_id,omitempty"`
+	Status     string   `json:"status"`
+	CreatedOn  string   `json:"created_on,omitempty"`
+}
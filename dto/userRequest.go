@@ -3,4 +3,8 @@ package dto
 type UserRequest struct {
 	UserName string
 	Password string
+	// optional space separated scopes, narrower than the user's roles allow
+	Scope string
+	// set by the handler from the connection, never read from the body
+	ClientIp string `json:"-" xml:"-"`
 }
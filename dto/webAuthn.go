@@ -0,0 +1,97 @@
+package dto
+
+// WebAuthn request and response bodies, binary values are base64url encoded without padding
+// as produced by the usual browser helpers
+
+type RelyingParty struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUser struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type PublicKeyCredentialCreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RelyingParty           RelyingParty           `json:"rp"`
+	User                   WebAuthnUser           `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+type PublicKeyCredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RpId             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+	Timeout          int64                  `json:"timeout"`
+}
+
+type CredentialCreationOptions struct {
+	PublicKey PublicKeyCredentialCreationOptions `json:"publicKey"`
+}
+
+type CredentialRequestOptions struct {
+	PublicKey PublicKeyCredentialRequestOptions `json:"publicKey"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+type RegistrationCredential struct {
+	Id       string              `json:"id"`
+	RawId    string              `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+type AssertionCredential struct {
+	Id       string            `json:"id"`
+	RawId    string            `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+// WebAuthnRegistrationRequest proves the user holds their second factor, with a one-time or recovery code,
+// or knows the password before a passkey is added to the account
+type WebAuthnRegistrationRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+	// set by the handler from the connection, never read from the body
+	ClientIp string `json:"-"`
+}
+
+// WebAuthnLoginRequest starts a passkey login, without a user name any discoverable credential is allowed
+type WebAuthnLoginRequest struct {
+	UserName string `json:"username"`
+}
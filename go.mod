@@ -1,16 +1,24 @@
 module banking-auth
 
-go 1.16
+go 1.18
 
 require (
+	github.com/barnettt/banking-lib v1.0.2
+	github.com/fxamacker/cbor/v2 v2.4.0
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/golang-jwt/jwt v3.2.2+incompatible
+	github.com/gorilla/mux v1.8.0
+	github.com/jmoiron/sqlx v1.3.4
+	github.com/lib/pq v1.2.0
+	github.com/mattn/go-sqlite3 v1.14.6
+	go.uber.org/zap v1.19.1
+	golang.org/x/crypto v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
-	github.com/barnettt/banking-lib v1.0.2 // indirect
-	github.com/go-sql-driver/mysql v1.6.0 // indirect
-	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
-	github.com/golang-sql/civil v0.0.0-20190719163853-cb61b32ac6fe // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
-	github.com/jmoiron/sqlx v1.3.4 // indirect
+require (
+	github.com/x448/float16 v0.8.4 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.7.0 // indirect
-	go.uber.org/zap v1.19.1 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 )
@@ -1,8 +1,19 @@
 package main
 
-import "banking-auth/app"
+import (
+	"banking-auth/app"
+	"os"
+)
 
 func main() {
 	//	logger.Info("Starting App......")
-	app.StartApp()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		app.Migrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "hash-secret" {
+		app.HashSecret(os.Args[2:])
+		return
+	}
+	app.StartApp(os.Args[1:])
 }
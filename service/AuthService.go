@@ -7,7 +7,9 @@ import (
 	"github.com/barnettt/banking-lib/exceptions"
 	"github.com/barnettt/banking-lib/logger"
 	"github.com/golang-jwt/jwt"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,103 +19,689 @@ type AuthService interface {
 }
 
 type DefaultAuthService struct {
-	repository       domain.AuthRepositoryDB
+	repository       domain.AuthRepository
 	tokenService     LoginService
-	rolesPermissions domain.RolePermissions
+	rolesPermissions *domain.RolePermissions
+	passwordHasher   domain.PasswordHasher
+	keyRing          *domain.KeyRing
+	mfaRepository    domain.MfaRepository
+	loginAttempts    domain.LoginAttemptRepository
+	lockoutPolicy    domain.LockoutPolicy
+	passwordPolicy   domain.PasswordPolicy
+	accessPolicies   domain.AccessPolicies
+	tokenLifetimes   domain.TokenLifetimes
+	// dummyPasswordHash is verified against when the user does not exist, so unknown user names take
+	// as long to refuse as wrong passwords
+	dummyPasswordHash string
+	// mfaEnforced stops users without a second factor getting tokens until they enroll one
+	mfaEnforced bool
 }
 
 func (defaultAuthService DefaultAuthService) GetUserByUserName(request dto.UserRequest) (*dto.LoginResponse, *exceptions.AppError) {
-	response, err := defaultAuthService.repository.FindUser(request)
+	user, appErr := defaultAuthService.authenticateUser(request.UserName, request.Password, request.ClientIp)
+	if appErr != nil {
+		return nil, appErr
+	}
+	login, appErr := defaultAuthService.newLogin(*user, request.Scope)
+	if appErr != nil {
+		return nil, appErr
+	}
+	mfaRequired, appErr := isMfaRequired(defaultAuthService.mfaRepository, user.UserName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if mfaRequired || defaultAuthService.mfaEnforced {
+		// tokens are only issued once the second factor is verified at /customers/login/mfa, users
+		// without one must enroll it first
+		return defaultAuthService.newMfaChallenge(*user, request.Scope, mfaRequired)
+	}
+	userResponse, appErr := defaultAuthService.tokenService.GenerateToken(login)
+	if appErr != nil {
+		return nil, appErr
+	}
+	userResponse.PasswordExpired = defaultAuthService.passwordPolicy.HasExpired(user.PasswordChangedAt)
+
+	return userResponse, nil
+}
+
+// secondFactorLogin is the mfa challenge for a login whose first factor was not enough on its own, a user
+// without a second factor cannot complete it unless mfa is enforced, when they are asked to enroll one
+func (defaultAuthService DefaultAuthService) secondFactorLogin(user domain.User) (*dto.LoginResponse, *exceptions.AppError) {
+	enrolled, appErr := isMfaRequired(defaultAuthService.mfaRepository, user.UserName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if !enrolled && !defaultAuthService.mfaEnforced {
+		return nil, exceptions.NewUnauthorisedError("user verification is required")
+	}
+	return defaultAuthService.newMfaChallenge(user, "", enrolled)
+}
+
+// newMfaChallenge answers a good password with a single use challenge token for the second factor,
+// or with an enrollment token when the user has yet to set one up
+func (defaultAuthService DefaultAuthService) newMfaChallenge(user domain.User, scope string, enrolled bool) (*dto.LoginResponse, *exceptions.AppError) {
+	tokenType := domain.MFA_CHALLENGE_TOKEN_TYPE
+	if !enrolled {
+		tokenType = domain.MFA_ENROLLMENT_TOKEN_TYPE
+	}
+	mfaToken, claims, appErr := domain.NewMfaChallengeToken(tokenType, user.UserName, scope, defaultAuthService.keyRing.SigningKey())
+	if appErr != nil {
+		return nil, appErr
+	}
+	if enrolled {
+		appErr = defaultAuthService.mfaRepository.SaveMfaChallenge(claims.Id, user.UserName, claims.ExpiresAt)
+		if appErr != nil {
+			return nil, appErr
+		}
+	}
+	return &dto.LoginResponse{UserName: user.UserName, MfaRequired: enrolled, MfaEnrollmentRequired: !enrolled,
+		MfaToken: mfaToken, PasswordExpired: defaultAuthService.passwordPolicy.HasExpired(user.PasswordChangedAt)}, nil
+}
+
+// authenticateUser is the credential check shared by every flow that logs a user in with a password.
+// Failures are counted per user name and per client ip, unknown user names included so a locked
+// response never tells an attacker whether the account exists
+func (defaultAuthService DefaultAuthService) authenticateUser(userName string, password string, clientIp string) (*domain.User, *exceptions.AppError) {
+	attemptKeys := loginAttemptKeys(userName, clientIp)
+	if appErr := defaultAuthService.checkNotLocked(userName, attemptKeys); appErr != nil {
+		return nil, appErr
+	}
+	response, err := defaultAuthService.repository.FindUser(userName)
 	if err != nil {
+		if err.Code != http.StatusInternalServerError {
+			_, _ = defaultAuthService.passwordHasher.Verify(password, defaultAuthService.dummyPasswordHash)
+			defaultAuthService.recordLoginFailure(userName, attemptKeys)
+		}
 		return nil, err
 	}
 	if response == nil {
 		return nil, exceptions.NewDatabaseError("Error user not found")
 	}
-	login := dto.Login{
-		UserName:       request.UserName,
-		Password:       request.Password,
-		Role:           response.Role,
-		CustomerId:     sql.NullString{String: strconv.Itoa(response.CustomerId), Valid: true},
-		AccountNumbers: sql.NullString{String: response.AccountNumbers, Valid: true},
+	if appErr := defaultAuthService.verifyPassword(response, password); appErr != nil {
+		defaultAuthService.recordLoginFailure(userName, attemptKeys)
+		return nil, appErr
+	}
+	// checked once the password is known to be good so it does not reveal which accounts exist
+	if appErr := checkUserActive(response); appErr != nil {
+		return nil, appErr
+	}
+	// only the user's count is cleared, an attacker owning one account must not be able to reset the ip count
+	if appErr := defaultAuthService.loginAttempts.ResetLoginAttempts(domain.UserAttemptKey(userName)); appErr != nil {
+		logger.Error("Error resetting login attempts : " + appErr.Message)
 	}
-	userResponse, appErr := defaultAuthService.tokenService.GenerateToken(login)
+	return response, nil
+}
+
+// checkSecondFactor verifies the TOTP or recovery code of a login, wrong codes count towards the
+// lockout the same as wrong passwords
+func (defaultAuthService DefaultAuthService) checkSecondFactor(userName string, code string, clientIp string) *exceptions.AppError {
+	attemptKeys := loginAttemptKeys(userName, clientIp)
+	if appErr := defaultAuthService.checkNotLocked(userName, attemptKeys); appErr != nil {
+		return appErr
+	}
+	if appErr := verifySecondFactor(defaultAuthService.mfaRepository, userName, code); appErr != nil {
+		if appErr.Code == http.StatusUnauthorized {
+			defaultAuthService.recordLoginFailure(userName, attemptKeys)
+		}
+		return appErr
+	}
+	return nil
+}
+
+func loginAttemptKeys(userName string, clientIp string) []string {
+	attemptKeys := []string{domain.UserAttemptKey(userName)}
+	if clientIp != "" {
+		attemptKeys = append(attemptKeys, domain.IpAttemptKey(clientIp))
+	}
+	return attemptKeys
+}
+
+// checkNotLocked rejects the attempt while any of the keys is locked or still inside its progressive delay
+func (defaultAuthService DefaultAuthService) checkNotLocked(userName string, attemptKeys []string) *exceptions.AppError {
+	now := time.Now()
+	for _, attemptKey := range attemptKeys {
+		attempts, appErr := defaultAuthService.loginAttempts.FindLoginAttempts(attemptKey)
+		if appErr != nil {
+			return appErr
+		}
+		if attempts == nil {
+			continue
+		}
+		if retryAt := defaultAuthService.lockoutPolicy.RetryAt(*attempts); retryAt.After(now) {
+			domain.RaiseAuditEvent(domain.AuditEvent{
+				Type:     domain.AUDIT_LOGIN_REJECTED_WHILE_LOCKED,
+				UserName: userName,
+				Detail:   attemptKey + " locked until " + retryAt.UTC().Format(time.RFC3339),
+			})
+			return exceptions.NewUnauthorisedError("too many failed login attempts, try again later")
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure counts the failure against every key and locks those that reached their limit. Errors
+// are only logged, the caller is already returning the credential error
+func (defaultAuthService DefaultAuthService) recordLoginFailure(userName string, attemptKeys []string) {
+	policy := defaultAuthService.lockoutPolicy
+	now := time.Now()
+	for _, attemptKey := range attemptKeys {
+		attempts, appErr := defaultAuthService.loginAttempts.RecordLoginFailure(attemptKey, now.Unix(),
+			now.Add(-policy.LockoutDuration).Unix())
+		if appErr != nil {
+			logger.Error("Error recording failed login : " + appErr.Message)
+			continue
+		}
+		maxFailures := policy.MaxUserFailures
+		if attemptKey != domain.UserAttemptKey(userName) {
+			maxFailures = policy.MaxIpFailures
+		}
+		if attempts == nil || attempts.Failures < maxFailures {
+			continue
+		}
+		lockedUntil := now.Add(policy.LockoutDuration)
+		if appErr = defaultAuthService.loginAttempts.LockLogin(attemptKey, lockedUntil.Unix()); appErr != nil {
+			logger.Error("Error locking login : " + appErr.Message)
+			continue
+		}
+		domain.RaiseAuditEvent(domain.AuditEvent{
+			Type:     domain.AUDIT_LOGIN_LOCKED,
+			UserName: userName,
+			Detail: attemptKey + " locked until " + lockedUntil.UTC().Format(time.RFC3339) + " after " +
+				strconv.Itoa(attempts.Failures) + " failed logins",
+		})
+	}
+}
+
+func (defaultAuthService DefaultAuthService) passwordViolations(userName string, password string) ([]dto.PasswordRuleViolation, *exceptions.AppError) {
+	policy := defaultAuthService.passwordPolicy
+	violations := policy.Check(password)
+	if userName == "" || policy.HistorySize <= 0 {
+		return violations, nil
+	}
+	reused, appErr := defaultAuthService.isPasswordReused(userName, password)
 	if appErr != nil {
 		return nil, appErr
 	}
+	if reused {
+		violations = append(violations, dto.PasswordRuleViolation{Rule: domain.PASSWORD_RULE_HISTORY,
+			Message: "password must not be one of your last " + strconv.Itoa(policy.HistorySize) + " passwords"})
+	}
+	return violations, nil
+}
 
-	return userResponse, nil
+// isPasswordReused checks the current password as well as the history, which is empty for users who have
+// not changed their password since history was recorded
+func (defaultAuthService DefaultAuthService) isPasswordReused(userName string, password string) (bool, *exceptions.AppError) {
+	hashes, appErr := defaultAuthService.repository.FindPasswordHistory(userName, defaultAuthService.passwordPolicy.HistorySize)
+	if appErr != nil {
+		return false, appErr
+	}
+	if user, appErr := defaultAuthService.repository.FindUser(userName); appErr == nil {
+		hashes = append(hashes, user.Password)
+	}
+	for _, hash := range hashes {
+		if matched, err := defaultAuthService.passwordHasher.Verify(password, hash); err == nil && matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkUserActive stops disabled and unverified users logging in by any method
+func checkUserActive(user *domain.User) *exceptions.AppError {
+	if !user.IsActive() {
+		return exceptions.NewUnauthorisedError("account is not active")
+	}
+	return nil
+}
+
+// newLogin describes the tokens to issue for the user, see grantScopes for the scope
+func (defaultAuthService DefaultAuthService) newLogin(user domain.User, scope string) (dto.Login, *exceptions.AppError) {
+	grantedScope, appErr := defaultAuthService.grantScopes(user.Roles, scope)
+	if appErr != nil {
+		return dto.Login{}, appErr
+	}
+	return userLogin(user, grantedScope), nil
+}
+
+// newDelegatedLogin describes the tokens a client gets on the user's behalf, see delegatedScopes for the scope
+func (defaultAuthService DefaultAuthService) newDelegatedLogin(user domain.User, clientId string, consented string) dto.Login {
+	login := userLogin(user, defaultAuthService.delegatedScopes(user.Roles, consented))
+	login.ClientId = clientId
+	return login
+}
+
+func userLogin(user domain.User, scope string) dto.Login {
+	return dto.Login{
+		UserName:       user.UserName,
+		Roles:          user.Roles,
+		CustomerId:     sql.NullString{String: strconv.Itoa(user.CustomerId), Valid: user.CustomerId != 0},
+		AccountNumbers: sql.NullString{String: user.AccountNumbers, Valid: user.AccountNumbers != ""},
+		Scope:          scope,
+	}
+}
+
+// grantScopes works out the scope claim of a first party login. First party tokens are only presented to
+// this api, so only scopes covering operations may be asked for and each must be allowed by one of the
+// roles. Scopes such as openid belong to OAuth clients and are refused. When no scope is asked for the
+// token gets every scope the roles allow
+func (defaultAuthService DefaultAuthService) grantScopes(roles []string, requested string) (string, *exceptions.AppError) {
+	allowed := defaultAuthService.rolesPermissions.ScopesForRoles(roles)
+	granted := make([]string, 0)
+	for _, scope := range strings.Fields(requested) {
+		if !defaultAuthService.rolesPermissions.IsApiScope(scope) {
+			return "", exceptions.NewValidationError("unknown scope " + scope)
+		}
+		if !containsString(allowed, scope) {
+			return "", exceptions.NewValidationError("scope " + scope + " is not allowed for the user's roles")
+		}
+		if !containsString(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	if len(granted) == 0 {
+		granted = append(granted, allowed...)
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// delegatedScopes works out the scope claim of a token issued to an OAuth client, only the operation scopes
+// the user consented to that the roles still allow are granted, never everything the roles allow. Other
+// scopes such as openid were checked against the client when the code was issued. Refreshes use it to
+// drop scopes the user's roles have lost
+func (defaultAuthService DefaultAuthService) delegatedScopes(roles []string, consented string) string {
+	allowed := defaultAuthService.rolesPermissions.ScopesForRoles(roles)
+	granted := make([]string, 0)
+	for _, scope := range strings.Fields(consented) {
+		if defaultAuthService.rolesPermissions.IsApiScope(scope) && !containsString(allowed, scope) {
+			continue
+		}
+		if !containsString(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return strings.Join(granted, " ")
+}
+
+// narrowScope checks a refresh asks for no more than the current token was granted
+func (defaultAuthService DefaultAuthService) narrowScope(roles []string, clientId string, current string, requested string) (string, *exceptions.AppError) {
+	if clientId == "" && !defaultAuthService.hasApiScope(current) {
+		// first party tokens from before scopes were issued could call anything their roles allow, an
+		// OAuth client only ever has what the user consented to
+		var appErr *exceptions.AppError
+		if current, appErr = defaultAuthService.grantScopes(roles, current); appErr != nil {
+			return "", appErr
+		}
+	}
+	currentScopes := strings.Fields(current)
+	narrowed := make([]string, 0)
+	for _, scope := range strings.Fields(requested) {
+		if !containsString(currentScopes, scope) {
+			return "", exceptions.NewValidationError("scope " + scope + " was not granted")
+		}
+		if !containsString(narrowed, scope) {
+			narrowed = append(narrowed, scope)
+		}
+	}
+	return strings.Join(narrowed, " "), nil
+}
+
+func (defaultAuthService DefaultAuthService) hasApiScope(scope string) bool {
+	for _, value := range strings.Fields(scope) {
+		if defaultAuthService.rolesPermissions.IsApiScope(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasOperationScope reports whether the token carries the scope covering the operation
+func (defaultAuthService DefaultAuthService) hasOperationScope(claims domain.AccessTokenClaims, operation string) bool {
+	required := defaultAuthService.rolesPermissions.ScopeFor(operation)
+	return required != "" && containsString(strings.Fields(claims.Scope), required)
+}
+
+// verifyPassword checks the password against the stored hash and transparently upgrades
+// plaintext or weaker hashes to the preferred algorithm once the password is known to be good
+func (defaultAuthService DefaultAuthService) verifyPassword(user *domain.User, password string) *exceptions.AppError {
+	matched, err := defaultAuthService.passwordHasher.Verify(password, user.Password)
+	if err != nil {
+		logger.Error("Error verifying password hash : " + err.Error())
+		return exceptions.NewJwtError("invalid user credentials  user")
+	}
+	if !matched {
+		return exceptions.NewJwtError("invalid user credentials  user")
+	}
+	if defaultAuthService.passwordHasher.NeedsRehash(user.Password) {
+		hash, err := defaultAuthService.passwordHasher.Hash(password)
+		if err != nil {
+			// the login is still good, the upgrade will be attempted on the next login
+			logger.Error("Error rehashing password : " + err.Error())
+			return nil
+		}
+		if appErr := defaultAuthService.repository.UpdatePassword(user.UserName, hash); appErr != nil {
+			logger.Error("Error storing rehashed password : " + appErr.Message)
+			return nil
+		}
+		user.Password = hash
+	}
+	return nil
 }
 
 func (defaultAuthService DefaultAuthService) Verify(params map[string]string) (bool, *exceptions.AppError) {
-	// get a jwt token from the token string in params
-	if jwtToken, err := jwtTokenFromParams(params["token"]); err != nil {
-		return false, err
-	} else {
-		/* check that the token is valid for expiry and is a valid token*/
-		if jwtToken.Valid {
-			// cast the tokens claims to a  jwt.MapClaims
-			claimsMap := jwtToken.Claims.(jwt.MapClaims)
-			// convert the claims to a claims struct
-			var claims *domain.AccessTokenClaims
-			claims, err := domain.ConvertJwtClaimsToUserClaims(claimsMap)
-			if err != nil {
-				return false, exceptions.NewUnauthorisedError("User not authorise")
-			}
-			/* check the role based access against the accounts and customerId on url
-			are matching the accounts and customerId in the token
-			*/
-			if claims.IsUserRole() {
-
-				b := claims.IsRequestParamsVerifiedWithTokenClaims(params)
-				if !b {
-					return b, exceptions.NewJwtError("Forbidden bad request information ")
-				}
-				// check the token has not expired
-				expired, err := domain.HasTokenExpired(time.Unix(claims.StandardClaims.ExpiresAt, 0))
-				if expired {
-					return expired, err
-				}
-			}
-			// now check the roles and permissions allow the operation
-			isAuthorised := defaultAuthService.rolesPermissions.IsAuthorisedForRole(claims.Role, params["operation"])
-			return isAuthorised, nil
-		}
-
-	}
-	return false, exceptions.NewJwtError("Unable to verify this request")
+	// only an unexpired, unrevoked access token authorises a request, refresh and mfa tokens never do
+	claims, appErr := defaultAuthService.authenticateAccessToken(params["token"])
+	if appErr != nil {
+		return false, appErr
+	}
+	// machine tokens have no roles, only the scope mapped to the operation authorises them
+	if claims.IsMachineToken() {
+		return defaultAuthService.hasOperationScope(*claims, params["operation"]), nil
+	}
+	if claims.ClientId != "" {
+		// an OAuth client only ever has what the user consented to, operations no scope covers were never consented to
+		if !defaultAuthService.hasOperationScope(*claims, params["operation"]) {
+			return false, nil
+		}
+	} else if defaultAuthService.hasApiScope(claims.Scope) && defaultAuthService.rolesPermissions.ScopeFor(params["operation"]) != "" &&
+		!defaultAuthService.hasOperationScope(*claims, params["operation"]) {
+		// a scoped first party token must also carry the scope covering the operation. Tokens issued before scopes
+		// existed carry none, and operations no scope covers have none to carry, both are left to the roles
+		return false, nil
+	}
+	// any of the roles may grant the operation, a customer role only grants it when the accounts
+	// and customerId on the url match the accounts and customerId in the token
+	customerScopeFailed := false
+	for _, role := range claims.RoleNames() {
+		if !defaultAuthService.rolesPermissions.IsAuthorisedForRole(role, params["operation"]) {
+			continue
+		}
+		if domain.IsCustomerRole(role) && !claims.IsRequestParamsVerifiedWithTokenClaims(params) {
+			customerScopeFailed = true
+			continue
+		}
+		if defaultAuthService.isAllowedByPolicies(role, *claims, params) {
+			return true, nil
+		}
+	}
+	if customerScopeFailed {
+		return false, exceptions.NewJwtError("Forbidden bad request information ")
+	}
+	return false, nil
+}
+
+// isAllowedByPolicies applies the access policies to an operation the role grants
+func (defaultAuthService DefaultAuthService) isAllowedByPolicies(role string, claims domain.AccessTokenClaims,
+	params map[string]string) bool {
+	allowed, policy, err := defaultAuthService.accessPolicies.Allows(role, params["operation"], claims, params, time.Now())
+	if err != nil {
+		logger.Error("Access policy " + policy + " could not be evaluated : " + err.Error())
+	}
+	if !allowed {
+		logger.Info("Operation " + params["operation"] + " for " + claims.UserName + " as " + role +
+			" refused by access policy " + policy)
+	}
+	return allowed
 }
 
 func (defaultAuthService DefaultAuthService) RefreshToken(request dto.RefreshTokenRequest) (*dto.LoginResponse, *exceptions.AppError) {
-	var appErr *exceptions.AppError
 	// var validationError *jwt.ValidationError
-	if validationError := request.IsAccessTokenValid(); validationError != nil {
+	if validationError := request.IsAccessTokenValid(defaultAuthService.keyRing.Keyfunc); validationError != nil {
 		if validationError.Errors == jwt.ValidationErrorExpired {
-			if appErr = defaultAuthService.repository.DoesRefreshTokenExist(request.RefreshToken); appErr != nil {
-				return nil, appErr
-			}
-			token, appErr := domain.NewAccessTokenFromRefreshToken(request.RefreshToken)
-			if appErr != nil {
-				return nil, appErr
-			}
-			return &dto.LoginResponse{
-				UserName:     "",
-				LoginTime:    "",
-				RefreshToken: request.RefreshToken,
-				Token:        token}, nil
+			return defaultAuthService.rotateRefreshToken(request.RefreshToken, request.Scope, "")
 		}
 		return nil, exceptions.NewUnauthorisedError("invalid token")
 	}
 	return nil, exceptions.NewJwtError("cannot generate access token until current expires")
 }
 
-func jwtTokenFromParams(tokenStr string) (*jwt.Token, *exceptions.AppError) {
+// rotateRefreshToken exchanges the refresh token for a new access token and refresh token in the same
+// family. clientId is the OAuth client presenting the token, empty for first party refreshes, and must
+// be the client the token was issued to
+func (defaultAuthService DefaultAuthService) rotateRefreshToken(refreshToken string, scope string, clientId string) (*dto.LoginResponse, *exceptions.AppError) {
+	authToken, appErr := domain.NewAuthTokenFromRefreshToken(refreshToken, defaultAuthService.keyRing,
+		defaultAuthService.tokenLifetimes)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if authToken.ClientId() != clientId {
+		return nil, exceptions.NewUnauthorisedError("invalid or expired refresh token")
+	}
+	if appErr = defaultAuthService.reloadUser(authToken); appErr != nil {
+		return nil, appErr
+	}
+	// the narrower scope carries over to the rotated refresh token
+	if scope != "" {
+		narrowed, appErr := defaultAuthService.narrowScope(authToken.Roles(), authToken.ClientId(), authToken.Scope(), scope)
+		if appErr != nil {
+			return nil, appErr
+		}
+		authToken.SetScope(narrowed)
+	}
+	if appErr := defaultAuthService.consumeRefreshToken(refreshToken); appErr != nil {
+		return nil, appErr
+	}
+	token, appErr := authToken.NewAccessToken()
+	if appErr != nil {
+		return nil, appErr
+	}
+	rotatedRefreshToken, appErr := defaultAuthService.repository.GenerateAndStoreRefreshToken(authToken)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return &dto.LoginResponse{
+		UserName:     "",
+		LoginTime:    "",
+		RefreshToken: rotatedRefreshToken,
+		Token:        token,
+		Scope:        authToken.Scope()}, nil
+}
 
-	logger.Info(tokenStr)
-	// parse the string into a jwt token using a token function to which the secret key must be returned
-	token, err := jwt.Parse(tokenStr, func(*jwt.Token) (interface{}, error) {
-		return []byte(dto.SECRET_WORD), nil
+// reloadUser brings the roles, customer and accounts of a refreshed token up to date with the user as
+// stored, role changes made by an admin reach the user's tokens on their next refresh. Scopes the
+// current roles no longer allow are dropped
+func (defaultAuthService DefaultAuthService) reloadUser(authToken *domain.AuthToken) *exceptions.AppError {
+	user, appErr := defaultAuthService.repository.FindUser(authToken.UserName())
+	if appErr != nil {
+		if appErr.Code == http.StatusInternalServerError {
+			return appErr
+		}
+		return exceptions.NewUnauthorisedError("invalid or expired refresh token")
+	}
+	if appErr = checkUserActive(user); appErr != nil {
+		return appErr
+	}
+	login := userLogin(*user, authToken.Scope())
+	claims := getClaimsForAccessToken(login, defaultAuthService.tokenLifetimes.AccessToken)
+	authToken.SetUser(claims.Roles, claims.CustomerId, claims.Accounts)
+	authToken.SetScope(defaultAuthService.delegatedScopes(user.Roles, authToken.Scope()))
+	return nil
+}
+
+// consumeRefreshToken marks the refresh token used so it can only be exchanged once. Presenting
+// a used token means it has leaked, so every token in its family is revoked.
+func (defaultAuthService DefaultAuthService) consumeRefreshToken(refreshToken string) *exceptions.AppError {
+	record, appErr := defaultAuthService.repository.FindRefreshToken(refreshToken)
+	if appErr != nil {
+		return appErr
+	}
+	if record.Revoked {
+		return exceptions.NewUnauthorisedError("refresh token has been revoked")
+	}
+	consumed := false
+	if !record.Used {
+		if consumed, appErr = defaultAuthService.repository.MarkRefreshTokenUsed(refreshToken); appErr != nil {
+			return appErr
+		}
+	}
+	if !consumed {
+		if appErr = defaultAuthService.repository.RevokeRefreshTokenFamily(record.TokenFamily); appErr != nil {
+			return appErr
+		}
+		domain.RaiseAuditEvent(domain.AuditEvent{
+			Type:     domain.AUDIT_REFRESH_TOKEN_REUSE,
+			UserName: record.UserName,
+			Detail:   "used refresh token presented again, revoked token family " + record.TokenFamily,
+		})
+		return exceptions.NewUnauthorisedError("refresh token has been revoked")
+	}
+	return nil
+}
+
+// Logout ends the session by revoking the refresh token family and, when presented, the access token
+func (defaultAuthService DefaultAuthService) Logout(request dto.LogoutRequest) *exceptions.AppError {
+	record, appErr := defaultAuthService.repository.FindRefreshToken(request.RefreshToken)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr = defaultAuthService.repository.RevokeRefreshTokenFamily(record.TokenFamily); appErr != nil {
+		return appErr
+	}
+	if request.AccessToken != "" {
+		return defaultAuthService.RevokeToken(request.AccessToken)
+	}
+	return nil
+}
+
+// RevokeToken revokes an access or refresh token in the style of RFC 7009, tokens which are
+// invalid, expired or unknown are ignored as there is nothing left to revoke
+func (defaultAuthService DefaultAuthService) RevokeToken(tokenStr string) *exceptions.AppError {
+	token, err := jwt.Parse(tokenStr, defaultAuthService.keyRing.Keyfunc)
+	if err != nil || !token.Valid {
+		return nil
+	}
+	claimsMap := token.Claims.(jwt.MapClaims)
+	if claimsMap["token_type"] == "refresh" {
+		record, appErr := defaultAuthService.repository.FindRefreshToken(tokenStr)
+		if appErr != nil {
+			return nil
+		}
+		return defaultAuthService.repository.RevokeRefreshTokenFamily(record.TokenFamily)
+	}
+	claims, appErr := domain.ConvertJwtClaimsToUserClaims(claimsMap)
+	if appErr != nil || claims.StandardClaims.Id == "" {
+		return nil
+	}
+	return defaultAuthService.repository.RevokeAccessToken(claims.StandardClaims.Id, claims.UserName,
+		claims.StandardClaims.ExpiresAt)
+}
+
+// RevokeUserSessions lets an admin end every session of a user
+func (defaultAuthService DefaultAuthService) RevokeUserSessions(adminToken string, userName string) *exceptions.AppError {
+	if _, appErr := defaultAuthService.authoriseAdmin(adminToken); appErr != nil {
+		return appErr
+	}
+	return defaultAuthService.revokeUserSessions(userName)
+}
+
+// revokeUserSessions ends every session of the user, the revocation is kept until the access tokens
+// issued up to now have expired
+func (defaultAuthService DefaultAuthService) revokeUserSessions(userName string) *exceptions.AppError {
+	expiresAt := time.Now().Add(defaultAuthService.tokenLifetimes.AccessToken).Unix()
+	return defaultAuthService.repository.RevokeUserSessions(userName, expiresAt)
+}
+
+// UnlockUser lets an admin clear a user's lockout and failed login count before the lockout expires
+func (defaultAuthService DefaultAuthService) UnlockUser(adminToken string, userName string) *exceptions.AppError {
+	admin, appErr := defaultAuthService.authoriseAdmin(adminToken)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr = defaultAuthService.loginAttempts.ResetLoginAttempts(domain.UserAttemptKey(userName)); appErr != nil {
+		return appErr
+	}
+	domain.RaiseAuditEvent(domain.AuditEvent{
+		Type:     domain.AUDIT_LOGIN_UNLOCKED,
+		UserName: userName,
+		Detail:   "unlocked by " + admin.UserName,
 	})
+	return nil
+}
+
+// authenticateAccessToken verifies the signature, expiry and revocation of an access token
+func (defaultAuthService DefaultAuthService) authenticateAccessToken(tokenStr string) (*domain.AccessTokenClaims, *exceptions.AppError) {
+	jwtToken, appErr := jwtTokenFromParams(tokenStr, defaultAuthService.keyRing.Keyfunc)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if !jwtToken.Valid {
+		return nil, exceptions.NewUnauthorisedError("invalid token")
+	}
+	claims, appErr := domain.ConvertJwtClaimsToUserClaims(jwtToken.Claims.(jwt.MapClaims))
+	if appErr != nil {
+		return nil, appErr
+	}
+	// refresh, mfa and id tokens are signed with the same keys and must not pass for access tokens
+	if !claims.IsUserAccessToken() && !claims.IsMachineToken() {
+		return nil, exceptions.NewUnauthorisedError("invalid token")
+	}
+	if expired, appErr := domain.HasTokenExpired(time.Unix(claims.StandardClaims.ExpiresAt, 0)); expired {
+		return nil, appErr
+	}
+	if appErr = defaultAuthService.checkNotRevoked(claims); appErr != nil {
+		return nil, appErr
+	}
+	return claims, nil
+}
+
+// authenticateFirstPartyUser accepts only an access token the user was issued by logging in here, tokens
+// handed to a client application or to a machine cannot manage the user's account or credentials
+func (defaultAuthService DefaultAuthService) authenticateFirstPartyUser(tokenStr string) (*domain.AccessTokenClaims, *exceptions.AppError) {
+	claims, appErr := defaultAuthService.authenticateAccessToken(tokenStr)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if claims.IsMachineToken() || claims.ClientId != "" {
+		return nil, exceptions.NewJwtError("a token issued to the user is required")
+	}
+	return claims, nil
+}
+
+// reauthenticate makes the user prove again who they are with a current one-time or recovery code, or
+// failing that the password, before a second factor is added or replaced. Wrong codes and passwords count
+// towards the lockout like any other failed login
+func (defaultAuthService DefaultAuthService) reauthenticate(userName string, code string, password string,
+	clientIp string) *exceptions.AppError {
+	if code != "" {
+		return defaultAuthService.checkSecondFactor(userName, code, clientIp)
+	}
+	if password != "" {
+		_, appErr := defaultAuthService.authenticateUser(userName, password, clientIp)
+		return appErr
+	}
+	return exceptions.NewUnauthorisedError("a current one-time code or the password is required")
+}
+
+// authoriseAdmin only accepts an admin's own token, a client application the admin consented to must
+// not be able to manage users and roles with it
+func (defaultAuthService DefaultAuthService) authoriseAdmin(tokenStr string) (*domain.AccessTokenClaims, *exceptions.AppError) {
+	claims, appErr := defaultAuthService.authenticateFirstPartyUser(tokenStr)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if !claims.HasRole("admin") {
+		return nil, exceptions.NewJwtError("admin role required")
+	}
+	return claims, nil
+}
+
+func (defaultAuthService DefaultAuthService) checkNotRevoked(claims *domain.AccessTokenClaims) *exceptions.AppError {
+	revoked, appErr := defaultAuthService.repository.IsAccessTokenRevoked(claims.StandardClaims.Id, claims.UserName,
+		claims.IssuedAt())
+	if appErr != nil {
+		return appErr
+	}
+	if revoked {
+		return exceptions.NewUnauthorisedError("token has been revoked")
+	}
+	return nil
+}
+
+func jwtTokenFromParams(tokenStr string, keyFunc jwt.Keyfunc) (*jwt.Token, *exceptions.AppError) {
+
+	logger.Info(tokenStr)
+	// parse the string into a jwt token using a token function which returns the verification key
+	token, err := jwt.Parse(tokenStr, keyFunc)
 	if err != nil {
 		logger.Error("Error while parsing token")
 		appErr := exceptions.NewValidationError("Invalid cannot parse token")
@@ -122,7 +710,17 @@ func jwtTokenFromParams(tokenStr string) (*jwt.Token, *exceptions.AppError) {
 	return token, nil
 }
 
-func NewUserService(repo domain.AuthRepositoryDB, tokenService DefaultTokenService,
-	rolesPermissions domain.RolePermissions) DefaultAuthService {
-	return DefaultAuthService{repository: repo, tokenService: tokenService, rolesPermissions: rolesPermissions}
+func NewUserService(repo domain.AuthRepository, tokenService DefaultTokenService,
+	rolesPermissions *domain.RolePermissions, passwordHasher domain.PasswordHasher, keyRing *domain.KeyRing,
+	mfaRepository domain.MfaRepository, loginAttempts domain.LoginAttemptRepository,
+	lockoutPolicy domain.LockoutPolicy, passwordPolicy domain.PasswordPolicy,
+	accessPolicies domain.AccessPolicies, mfaEnforced bool) DefaultAuthService {
+	dummyPasswordHash, err := passwordHasher.Hash(domain.NewTokenId())
+	if err != nil {
+		logger.Error("Error hashing the dummy password : " + err.Error())
+	}
+	return DefaultAuthService{repository: repo, tokenService: tokenService, rolesPermissions: rolesPermissions,
+		passwordHasher: passwordHasher, keyRing: keyRing, mfaRepository: mfaRepository, loginAttempts: loginAttempts,
+		lockoutPolicy: lockoutPolicy, passwordPolicy: passwordPolicy, accessPolicies: accessPolicies,
+		tokenLifetimes: tokenService.Lifetimes(), dummyPasswordHash: dummyPasswordHash, mfaEnforced: mfaEnforced}
 }
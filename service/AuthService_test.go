@@ -0,0 +1,186 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type authFixture struct {
+	service      DefaultAuthService
+	tokenService DefaultTokenService
+	storage      domain.Storage
+}
+
+const (
+	testPassword     string = "correct horse battery"
+	testAdmin        string = "admin"
+	testClient       string = "test-client"
+	testClientSecret string = "test client secret"
+)
+
+func newAuthFixture(t *testing.T) authFixture {
+	passwordHasher := domain.NewBcryptHasher()
+	passwordHash, err := passwordHasher.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := domain.NewInMemoryStore()
+	var seed domain.InMemorySeed
+	err = json.Unmarshal([]byte(`{"users":[{"username":"`+testUser+`","password":"`+passwordHash+`","roles":["user"],
+		"customer_id":2001,"accounts":["95470"]},{"username":"`+testAdmin+`","password":"`+passwordHash+`",
+		"roles":["admin"]}],"clients":[{"client_id":"`+testClient+`","client_secret":"`+testClientSecret+`",
+		"allowed_scopes":"openid profile customers:read","redirect_uris":"`+testOrigin+`/callback"}]}`), &seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Seed(seed)
+	storage := domain.NewInMemoryStorage(store)
+	rolePermissions, appErr := domain.NewRolePermissions(storage.Roles)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	keyRing := domain.NewKeyRing(domain.NewHmacSigningKey("test", []byte("test signing secret")), time.Hour)
+	tokenService := NewTokenService(storage.Auth, keyRing, testOrigin, domain.TokenLifetimes{
+		AccessToken: domain.DEFAULT_ACCESS_TOKEN_LIFETIME, RefreshToken: domain.DEFAULT_REFRESH_TOKEN_LIFETIME})
+	authService := NewUserService(storage.Auth, tokenService, rolePermissions, passwordHasher, keyRing,
+		storage.Mfa, storage.LoginAttempts, domain.NewLockoutPolicy(), domain.NewPasswordPolicy(), nil, false)
+	return authFixture{service: authService, tokenService: tokenService, storage: storage}
+}
+
+// login issues first party tokens to a seeded user without going through the password check
+func (fixture authFixture) login(t *testing.T, userName string) *dto.LoginResponse {
+	login, appErr := fixture.service.newLogin(fixture.findUser(t, userName), "")
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	return fixture.issue(t, login)
+}
+
+// delegatedLogin issues the tokens a client application gets on a seeded user's behalf
+func (fixture authFixture) delegatedLogin(t *testing.T, userName string) *dto.LoginResponse {
+	return fixture.issue(t, fixture.service.newDelegatedLogin(fixture.findUser(t, userName), testClient, ""))
+}
+
+func (fixture authFixture) findUser(t *testing.T, userName string) domain.User {
+	user, appErr := fixture.storage.Auth.FindUser(userName)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	return *user
+}
+
+func (fixture authFixture) issue(t *testing.T, login dto.Login) *dto.LoginResponse {
+	tokens, appErr := fixture.tokenService.GenerateToken(login)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	return tokens
+}
+
+func TestVerifyRefusesRefreshToken(t *testing.T) {
+	fixture := newAuthFixture(t)
+	tokens := fixture.login(t, testUser)
+	params := map[string]string{"operation": "GetCustomer", "customer_id": "2001", "id": "95470"}
+
+	params["token"] = tokens.Token
+	if allowed, appErr := fixture.service.Verify(params); appErr != nil || !allowed {
+		t.Fatalf("access token was not authorised: %v %v", allowed, appErr)
+	}
+	params["token"] = tokens.RefreshToken
+	if allowed, appErr := fixture.service.Verify(params); appErr == nil || allowed {
+		t.Fatal("refresh token authorised a request")
+	}
+
+	appErr := fixture.service.Logout(dto.LogoutRequest{AccessToken: tokens.Token, RefreshToken: tokens.RefreshToken})
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	for _, token := range []string{tokens.Token, tokens.RefreshToken} {
+		params["token"] = token
+		if allowed, appErr := fixture.service.Verify(params); appErr == nil || allowed {
+			t.Fatal("token authorised a request after logout")
+		}
+	}
+}
+
+func TestAdminOperationsRefuseDelegatedToken(t *testing.T) {
+	fixture := newAuthFixture(t)
+	if appErr := fixture.service.UnlockUser(fixture.login(t, testAdmin).Token, testUser); appErr != nil {
+		t.Fatalf("admin token was refused: %s", appErr.Message)
+	}
+	appErr := fixture.service.UnlockUser(fixture.delegatedLogin(t, testAdmin).Token, testUser)
+	if appErr == nil || appErr.Code != http.StatusForbidden {
+		t.Fatalf("delegated admin token got %v, want 403", appErr)
+	}
+}
+
+func TestVerifyAllowsPermissionWithoutScope(t *testing.T) {
+	fixture := newAuthFixture(t)
+	roleService := NewRoleService(fixture.service, fixture.storage.Roles)
+	adminToken := fixture.login(t, testAdmin).Token
+	if appErr := roleService.CreatePermission(adminToken, dto.PermissionRequest{Name: "GetStatements"}); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if appErr := roleService.GrantPermission(adminToken, "user", "GetStatements", domain.PERMISSION_EFFECT_ALLOW); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	tokens := fixture.login(t, testUser)
+	params := map[string]string{"token": tokens.Token, "customer_id": "2001", "id": "95470"}
+
+	params["operation"] = "GetStatements"
+	if allowed, appErr := fixture.service.Verify(params); appErr != nil || !allowed {
+		t.Fatalf("operation without a scope was not authorised: %v %v", allowed, appErr)
+	}
+	params["operation"] = "GetAllCustomer"
+	if allowed, _ := fixture.service.Verify(params); allowed {
+		t.Fatal("operation the role does not grant was authorised")
+	}
+}
+
+func TestVerifyDelegatedTokenNeedsConsentedScope(t *testing.T) {
+	fixture := newAuthFixture(t)
+	roleService := NewRoleService(fixture.service, fixture.storage.Roles)
+	adminToken := fixture.login(t, testAdmin).Token
+	if appErr := roleService.CreatePermission(adminToken, dto.PermissionRequest{Name: "GetStatements"}); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if appErr := roleService.GrantPermission(adminToken, "user", "GetStatements", domain.PERMISSION_EFFECT_ALLOW); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	user := fixture.findUser(t, testUser)
+	params := map[string]string{"customer_id": "2001", "id": "95470"}
+
+	params["token"] = fixture.issue(t, fixture.service.newDelegatedLogin(user, testClient, "openid")).Token
+	for _, operation := range []string{"GetCustomer", "GetStatements"} {
+		params["operation"] = operation
+		if allowed, _ := fixture.service.Verify(params); allowed {
+			t.Fatalf("openid only delegated token was authorised for %s", operation)
+		}
+	}
+	params["token"] = fixture.issue(t, fixture.service.newDelegatedLogin(user, testClient, "openid customers:read")).Token
+	params["operation"] = "GetCustomer"
+	if allowed, appErr := fixture.service.Verify(params); appErr != nil || !allowed {
+		t.Fatalf("consented scope was not authorised: %v %v", allowed, appErr)
+	}
+}
+
+func TestVerifyMachineTokenNeedsMappedScope(t *testing.T) {
+	fixture := newAuthFixture(t)
+	client := domain.Client{ClientId: testClient}
+	params := map[string]string{"operation": "GetCustomer", "customer_id": "2001"}
+
+	for scope, want := range map[string]bool{"customers:read": true, "GetCustomer": false, "accounts:write": false} {
+		tokens, appErr := fixture.tokenService.GenerateClientToken(client, []string{scope})
+		if appErr != nil {
+			t.Fatal(appErr.Message)
+		}
+		params["token"] = tokens.AccessToken
+		if allowed, appErr := fixture.service.Verify(params); appErr != nil || allowed != want {
+			t.Fatalf("machine token with scope %s got %v %v, want %v", scope, allowed, appErr, want)
+		}
+	}
+}
@@ -16,16 +16,16 @@ type LoginService interface {
 
 type DefaultTokenService struct {
 	loginService LoginService
-	repository   domain.AuthRepositoryDB
+	repository   domain.AuthRepository
+	keyRing      *domain.KeyRing
+	issuer       string
+	lifetimes    domain.TokenLifetimes
 }
 
 func (defaultTokenService DefaultTokenService) GenerateToken(login dto.Login) (*dto.LoginResponse, *exceptions.AppError) {
 	var token *domain.AuthToken
 	var refreshToken string
-	token, err := generateToken(login)
-	if err != nil {
-		return nil, err
-	}
+	token = defaultTokenService.generateToken(login)
 	var accessToken string
 	var appErr *exceptions.AppError
 	if accessToken, appErr = token.NewAccessToken(); appErr != nil {
@@ -36,47 +36,141 @@ func (defaultTokenService DefaultTokenService) GenerateToken(login dto.Login) (*
 	if refreshToken, appErr = defaultTokenService.repository.GenerateAndStoreRefreshToken(token); appErr != nil {
 		return nil, appErr
 	}
-	return &dto.LoginResponse{UserName: login.UserName, LoginTime: time.Now().Format(time.RFC3339), Token: accessToken, RefreshToken: refreshToken}, nil
+	var idToken string
+	if login.ClientId != "" && containsString(strings.Fields(login.Scope), dto.SCOPE_OPENID) {
+		if idToken, appErr = defaultTokenService.generateIdToken(login); appErr != nil {
+			return nil, appErr
+		}
+	}
+	return &dto.LoginResponse{UserName: login.UserName, LoginTime: time.Now().Format(time.RFC3339), Token: accessToken,
+		RefreshToken: refreshToken, IdToken: idToken, Scope: login.Scope}, nil
+}
+
+// generateIdToken issues the OpenID Connect id token for a login made through a client with the openid scope
+func (defaultTokenService DefaultTokenService) generateIdToken(login dto.Login) (string, *exceptions.AppError) {
+	claims := domain.IdTokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    defaultTokenService.issuer,
+			Subject:   login.UserName,
+			Audience:  login.ClientId,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(defaultTokenService.lifetimes.AccessToken).Unix(),
+		},
+		Nonce:    login.Nonce,
+		AuthTime: login.AuthTime,
+	}
+	if containsString(strings.Fields(login.Scope), dto.SCOPE_PROFILE) {
+		claims.PreferredUserName = login.UserName
+		if login.CustomerId.Valid {
+			claims.CustomerId = login.CustomerId.String
+		}
+	}
+	return domain.NewIdToken(claims, defaultTokenService.keyRing.SigningKey())
+}
+
+func (defaultTokenService DefaultTokenService) Issuer() string {
+	return defaultTokenService.issuer
+}
+
+func (defaultTokenService DefaultTokenService) Lifetimes() domain.TokenLifetimes {
+	return defaultTokenService.lifetimes
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateClientToken issues an access token for a machine identity, no refresh token is issued
+// as the client can always authenticate again with its credentials
+func (defaultTokenService DefaultTokenService) GenerateClientToken(client domain.Client, scopes []string) (*dto.TokenResponse, *exceptions.AppError) {
+	lifetime := client.AccessTokenDuration(defaultTokenService.lifetimes.AccessToken)
+	claims := NewMachineClaim(client, scopes, lifetime)
+	authToken := domain.NewAuthToken(claims, defaultTokenService.keyRing.SigningKey(), defaultTokenService.lifetimes)
+	accessToken, appErr := authToken.NewAccessToken()
+	if appErr != nil {
+		logger.Error(appErr.Message)
+		return nil, appErr
+	}
+	return &dto.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(lifetime.Seconds()),
+		Scope:       claims.Scope,
+	}, nil
 }
 
-func generateToken(login dto.Login) (*domain.AuthToken, *exceptions.AppError) {
-	claims := getClaimsForAccessToken(login)
-	authToken := domain.NewAuthToken(claims)
-	return &authToken, nil
+func (defaultTokenService DefaultTokenService) generateToken(login dto.Login) *domain.AuthToken {
+	claims := getClaimsForAccessToken(login, defaultTokenService.lifetimes.AccessToken)
+	authToken := domain.NewAuthToken(claims, defaultTokenService.keyRing.SigningKey(), defaultTokenService.lifetimes)
+	return &authToken
 }
 
-func getClaimsForAccessToken(login dto.Login) domain.AccessTokenClaims {
+func getClaimsForAccessToken(login dto.Login, lifetime time.Duration) domain.AccessTokenClaims {
 	if login.AccountNumbers.Valid && login.CustomerId.Valid {
-		return NewCustomerClaim(login)
+		return NewCustomerClaim(login, lifetime)
 	} else {
-		return NewAdminClaim(login)
+		return NewAdminClaim(login, lifetime)
+	}
+}
+
+func NewAdminClaim(login dto.Login, lifetime time.Duration) domain.AccessTokenClaims {
+	now := time.Now()
+	return domain.AccessTokenClaims{
+		UserName:       login.UserName,
+		Roles:          login.Roles,
+		ClientId:       login.ClientId,
+		Scope:          login.Scope,
+		IssuedAtMillis: now.UnixMilli(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        domain.NewTokenId(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(lifetime).Unix(),
+		},
 	}
 }
 
-func NewAdminClaim(login dto.Login) domain.AccessTokenClaims {
+func NewMachineClaim(client domain.Client, scopes []string, lifetime time.Duration) domain.AccessTokenClaims {
+	now := time.Now()
 	return domain.AccessTokenClaims{
-		UserName: login.UserName,
-		Role:     login.Role,
+		TokenType:      domain.MACHINE_TOKEN_TYPE,
+		ClientId:       client.ClientId,
+		Scope:          strings.Join(scopes, " "),
+		IssuedAtMillis: now.UnixMilli(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(domain.TOKEN_DURATION).Unix(),
+			Id:        domain.NewTokenId(),
+			Subject:   client.ClientId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(lifetime).Unix(),
 		},
 	}
 }
 
-func NewCustomerClaim(login dto.Login) domain.AccessTokenClaims {
+func NewCustomerClaim(login dto.Login, lifetime time.Duration) domain.AccessTokenClaims {
 
 	accounts := strings.Split(login.AccountNumbers.String, ",")
+	now := time.Now()
 	return domain.AccessTokenClaims{
-		CustomerId: login.CustomerId.String,
-		Accounts:   accounts,
-		UserName:   login.UserName,
-		Role:       login.Role,
+		CustomerId:     login.CustomerId.String,
+		Accounts:       accounts,
+		UserName:       login.UserName,
+		Roles:          login.Roles,
+		ClientId:       login.ClientId,
+		Scope:          login.Scope,
+		IssuedAtMillis: now.UnixMilli(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(domain.TOKEN_DURATION).Unix(),
+			Id:        domain.NewTokenId(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(lifetime).Unix(),
 		},
 	}
 }
 
-func NewTokenService(repository domain.AuthRepositoryDB) DefaultTokenService {
-	return DefaultTokenService{repository: repository}
+func NewTokenService(repository domain.AuthRepository, keyRing *domain.KeyRing, issuer string,
+	lifetimes domain.TokenLifetimes) DefaultTokenService {
+	return DefaultTokenService{repository: repository, keyRing: keyRing, issuer: issuer, lifetimes: lifetimes}
 }
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"time"
+)
+
+const RECOVERY_CODE_COUNT int = 10
+
+type MfaService interface {
+	EnrollTotp(bearerToken string, request dto.MfaEnrollRequest) (*dto.TotpEnrollmentResponse, *exceptions.AppError)
+	ConfirmTotp(bearerToken string, request dto.MfaCodeRequest) (*dto.RecoveryCodesResponse, *exceptions.AppError)
+	VerifyMfaLogin(request dto.MfaLoginRequest) (*dto.LoginResponse, *exceptions.AppError)
+}
+
+type DefaultMfaService struct {
+	authService   DefaultAuthService
+	mfaRepository domain.MfaRepository
+	// shown by authenticator apps next to the account name
+	totpIssuer string
+}
+
+// EnrollTotp creates a new unconfirmed TOTP secret for the user, logins are not challenged until it is confirmed.
+// Replacing a confirmed enrollment keeps the new secret pending, the current secret and recovery codes stay in
+// use until it is confirmed. The user must first prove they hold the current second factor or know the password,
+// a stolen access token alone is not enough
+func (mfaService DefaultMfaService) EnrollTotp(bearerToken string, request dto.MfaEnrollRequest) (*dto.TotpEnrollmentResponse, *exceptions.AppError) {
+	userName, appErr := mfaService.authenticateEnrollment(bearerToken)
+	if appErr != nil {
+		return nil, appErr
+	}
+	enrolled, appErr := isMfaRequired(mfaService.mfaRepository, userName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if enrolled {
+		appErr = mfaService.authService.reauthenticate(userName, request.Code, request.Password, request.ClientIp)
+		if appErr != nil {
+			return nil, appErr
+		}
+	}
+	secret, err := domain.NewTotpSecret()
+	if err != nil {
+		logger.Error("Error generating totp secret : " + err.Error())
+		return nil, exceptions.NewDatabaseError("Error generating totp secret")
+	}
+	if enrolled {
+		appErr = mfaService.mfaRepository.SavePendingTotp(userName, secret)
+	} else {
+		appErr = mfaService.mfaRepository.SaveTotp(domain.TotpEnrollment{UserName: userName, Secret: secret})
+	}
+	if appErr != nil {
+		return nil, appErr
+	}
+	return &dto.TotpEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningUri: domain.TotpProvisioningUri(mfaService.totpIssuer, userName, secret),
+	}, nil
+}
+
+// ConfirmTotp enables mfa, or swaps in the secret of a re-enrollment, once the user proves their authenticator
+// produces valid codes and returns new recovery codes, which are only ever shown this once
+func (mfaService DefaultMfaService) ConfirmTotp(bearerToken string, request dto.MfaCodeRequest) (*dto.RecoveryCodesResponse, *exceptions.AppError) {
+	userName, appErr := mfaService.authenticateEnrollment(bearerToken)
+	if appErr != nil {
+		return nil, appErr
+	}
+	enrollment, appErr := mfaService.mfaRepository.FindTotp(userName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	// a confirmed enrollment only has something to confirm once the user re-enrolled
+	if enrollment == nil || (enrollment.Confirmed && enrollment.PendingSecret == "") {
+		return nil, exceptions.NewNotFoundError("no totp enrollment to confirm")
+	}
+	secret := enrollment.Secret
+	if enrollment.Confirmed {
+		secret = enrollment.PendingSecret
+	}
+	step := domain.ValidateTotpCode(secret, request.Code, time.Now())
+	if step == 0 {
+		return nil, exceptions.NewUnauthorisedError("invalid one-time code")
+	}
+	accepted, appErr := mfaService.mfaRepository.UseTotpStep(userName, step)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if !accepted {
+		return nil, exceptions.NewUnauthorisedError("one-time code already used")
+	}
+	codes, err := domain.NewRecoveryCodes(RECOVERY_CODE_COUNT)
+	if err != nil {
+		logger.Error("Error generating recovery codes : " + err.Error())
+		return nil, exceptions.NewDatabaseError("Error generating recovery codes")
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, domain.HashToken(code))
+	}
+	if appErr = mfaService.mfaRepository.SaveRecoveryCodes(userName, hashes); appErr != nil {
+		return nil, appErr
+	}
+	if appErr = mfaService.mfaRepository.ConfirmTotp(userName); appErr != nil {
+		return nil, appErr
+	}
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// authenticateEnrollment accepts a user's access token, or the enrollment token handed out at login
+// when mfa is enforced and the user has no second factor yet
+func (mfaService DefaultMfaService) authenticateEnrollment(bearerToken string) (string, *exceptions.AppError) {
+	keyRing := mfaService.authService.keyRing
+	if claims, appErr := domain.ParseMfaChallengeToken(bearerToken, domain.MFA_ENROLLMENT_TOKEN_TYPE, keyRing); appErr == nil {
+		return claims.Subject, nil
+	}
+	// a client application acting for the user must not replace their second factor
+	claims, appErr := mfaService.authService.authenticateFirstPartyUser(bearerToken)
+	if appErr != nil {
+		return "", appErr
+	}
+	return claims.UserName, nil
+}
+
+// VerifyMfaLogin exchanges the challenge token from /customers/login and a TOTP or recovery code for the tokens,
+// each challenge can only be exchanged once
+func (mfaService DefaultMfaService) VerifyMfaLogin(request dto.MfaLoginRequest) (*dto.LoginResponse, *exceptions.AppError) {
+	claims, appErr := domain.ParseMfaChallengeToken(request.MfaToken, domain.MFA_CHALLENGE_TOKEN_TYPE,
+		mfaService.authService.keyRing)
+	if appErr != nil {
+		return nil, appErr
+	}
+	userName := claims.Subject
+	if appErr = mfaService.authService.checkSecondFactor(userName, request.Code, request.ClientIp); appErr != nil {
+		return nil, appErr
+	}
+	consumed, appErr := mfaService.mfaRepository.ConsumeMfaChallenge(claims.Id)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if !consumed {
+		return nil, exceptions.NewUnauthorisedError("invalid or expired mfa challenge")
+	}
+	user, appErr := mfaService.authService.repository.FindUser(userName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if appErr = checkUserActive(user); appErr != nil {
+		return nil, appErr
+	}
+	login, appErr := mfaService.authService.newLogin(*user, claims.Scope)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return mfaService.authService.tokenService.GenerateToken(login)
+}
+
+// isMfaRequired reports whether the user has a confirmed second factor
+func isMfaRequired(mfaRepository domain.MfaRepository, userName string) (bool, *exceptions.AppError) {
+	enrollment, appErr := mfaRepository.FindTotp(userName)
+	if appErr != nil {
+		return false, appErr
+	}
+	return enrollment != nil && enrollment.Confirmed, nil
+}
+
+// verifySecondFactor accepts a current TOTP code, which cannot be replayed, or an unused recovery code
+func verifySecondFactor(mfaRepository domain.MfaRepository, userName string, code string) *exceptions.AppError {
+	enrollment, appErr := mfaRepository.FindTotp(userName)
+	if appErr != nil {
+		return appErr
+	}
+	if enrollment == nil || !enrollment.Confirmed || code == "" {
+		return exceptions.NewUnauthorisedError("invalid one-time code")
+	}
+	if step := domain.ValidateTotpCode(enrollment.Secret, code, time.Now()); step != 0 {
+		accepted, appErr := mfaRepository.UseTotpStep(userName, step)
+		if appErr != nil {
+			return appErr
+		}
+		if !accepted {
+			return exceptions.NewUnauthorisedError("one-time code already used")
+		}
+		return nil
+	}
+	used, appErr := mfaRepository.UseRecoveryCode(userName, domain.HashToken(domain.NormaliseRecoveryCode(code)))
+	if appErr != nil {
+		return appErr
+	}
+	if !used {
+		return exceptions.NewUnauthorisedError("invalid one-time code")
+	}
+	return nil
+}
+
+func NewMfaService(authService DefaultAuthService, mfaRepository domain.MfaRepository, totpIssuer string) DefaultMfaService {
+	return DefaultMfaService{authService: authService, mfaRepository: mfaRepository, totpIssuer: totpIssuer}
+}
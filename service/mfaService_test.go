@@ -0,0 +1,55 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	code, err := domain.TotpCode(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTotpReEnrollmentKeepsConfirmedSecretUntilConfirmed(t *testing.T) {
+	fixture := newAuthFixture(t)
+	mfaService := NewMfaService(fixture.service, fixture.storage.Mfa, "banking")
+	accessToken := fixture.login(t, testUser).Token
+	step := domain.TotpStep(time.Now())
+
+	enrollment, appErr := mfaService.EnrollTotp(accessToken, dto.MfaEnrollRequest{})
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	code := dto.MfaCodeRequest{Code: totpCode(t, enrollment.Secret, step-1)}
+	if _, appErr = mfaService.ConfirmTotp(accessToken, code); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+
+	reEnrollment, appErr := mfaService.EnrollTotp(accessToken, dto.MfaEnrollRequest{Password: testPassword})
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	stored, _ := fixture.storage.Mfa.FindTotp(testUser)
+	if !stored.Confirmed || stored.Secret != enrollment.Secret || stored.PendingSecret != reEnrollment.Secret {
+		t.Fatalf("re-enrollment replaced the confirmed secret before it was confirmed: %+v", stored)
+	}
+
+	code = dto.MfaCodeRequest{Code: totpCode(t, reEnrollment.Secret, step)}
+	if _, appErr = mfaService.ConfirmTotp(accessToken, code); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	stored, _ = fixture.storage.Mfa.FindTotp(testUser)
+	if !stored.Confirmed || stored.Secret != reEnrollment.Secret || stored.PendingSecret != "" {
+		t.Fatalf("confirmed re-enrollment was not swapped in: %+v", stored)
+	}
+	code = dto.MfaCodeRequest{Code: totpCode(t, reEnrollment.Secret, step+1)}
+	if _, appErr = mfaService.ConfirmTotp(accessToken, code); appErr == nil || appErr.Code != http.StatusNotFound {
+		t.Fatalf("confirming without a pending re-enrollment got %v, want 404", appErr)
+	}
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"github.com/golang-jwt/jwt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type OAuthService interface {
+	Introspect(clientId string, clientSecret string, token string) (*dto.IntrospectionResponse, *exceptions.AppError)
+	ClientCredentialsToken(clientId string, clientSecret string, scope string) (*dto.TokenResponse, *exceptions.AppError)
+	FindAuthorizeClient(clientId string, redirectUri string) (*domain.Client, *exceptions.AppError)
+	ValidateAuthorizeRequest(client domain.Client, request dto.AuthorizeRequest) *exceptions.AppError
+	Authorize(request dto.AuthorizeRequest, userName string, password string, otp string,
+		clientIp string) (string, *exceptions.AppError)
+	AuthorizationCodeToken(clientId string, clientSecret string, code string, redirectUri string,
+		codeVerifier string) (*dto.TokenResponse, *exceptions.AppError)
+	RefreshTokenGrant(clientId string, clientSecret string, refreshToken string, scope string) (*dto.TokenResponse, *exceptions.AppError)
+	UserInfo(accessToken string) (*dto.UserInfoResponse, *exceptions.AppError)
+	OpenIdConfiguration() dto.OpenIdConfiguration
+}
+
+var _ OAuthService = DefaultOAuthService{}
+
+// DefaultOAuthService exposes the standard OAuth endpoints on top of the tokens issued by DefaultAuthService,
+// errors carry the RFC 6749 error code as their message
+type DefaultOAuthService struct {
+	authService      DefaultAuthService
+	tokenService     DefaultTokenService
+	clientRepository domain.ClientRepository
+	codeRepository   domain.AuthorizationCodeRepository
+	passwordHasher   domain.PasswordHasher
+}
+
+func (oauthService DefaultOAuthService) AuthenticateClient(clientId string, clientSecret string) (*domain.Client, *exceptions.AppError) {
+	if clientId == "" || clientSecret == "" {
+		return nil, exceptions.NewUnauthorisedError(dto.OAUTH_INVALID_CLIENT)
+	}
+	client, appErr := oauthService.clientRepository.FindClient(clientId)
+	if appErr != nil {
+		if appErr.Code == http.StatusUnauthorized {
+			return nil, exceptions.NewUnauthorisedError(dto.OAUTH_INVALID_CLIENT)
+		}
+		return nil, exceptions.NewDatabaseError(dto.OAUTH_SERVER_ERROR)
+	}
+	// the plaintext fallback kept for legacy user passwords does not apply, client secrets must be hashed
+	if !oauthService.passwordHasher.Supports(client.SecretHash) {
+		logger.Error("Client " + clientId + " has an unhashed secret, store its hash from banking-auth hash-secret")
+		return nil, exceptions.NewUnauthorisedError(dto.OAUTH_INVALID_CLIENT)
+	}
+	matched, err := oauthService.passwordHasher.Verify(clientSecret, client.SecretHash)
+	if err != nil {
+		logger.Error("Error verifying client secret : " + err.Error())
+	}
+	if !matched {
+		return nil, exceptions.NewUnauthorisedError(dto.OAUTH_INVALID_CLIENT)
+	}
+	return client, nil
+}
+
+// Introspect reports whether an access or refresh token is active as described in RFC 7662, id tokens
+// are never active as they are not accepted in place of an access token
+func (oauthService DefaultOAuthService) Introspect(clientId string, clientSecret string, token string) (*dto.IntrospectionResponse, *exceptions.AppError) {
+	if _, appErr := oauthService.AuthenticateClient(clientId, clientSecret); appErr != nil {
+		return nil, appErr
+	}
+	if token == "" {
+		return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_REQUEST)
+	}
+	inactive := &dto.IntrospectionResponse{Active: false}
+	refreshClaims := &domain.RefreshTokenClaims{}
+	refreshToken, err := jwt.ParseWithClaims(token, refreshClaims, oauthService.authService.keyRing.Keyfunc)
+	if err == nil && refreshToken.Valid && refreshClaims.TokenType == "refresh" {
+		record, appErr := oauthService.authService.repository.FindRefreshToken(token)
+		if appErr != nil || record.Used || record.Revoked {
+			return inactive, nil
+		}
+		return &dto.IntrospectionResponse{
+			Active:     true,
+			UserName:   refreshClaims.Name,
+			Subject:    refreshClaims.Name,
+			TokenType:  "refresh_token",
+			ExpiresAt:  refreshClaims.StandardClaims.ExpiresAt,
+			Jti:        refreshClaims.StandardClaims.Id,
+			Scope:      refreshClaims.Scope,
+			ClientId:   refreshClaims.ClientId,
+			CustomerId: refreshClaims.CId,
+			Roles:      refreshClaims.Roles,
+		}, nil
+	}
+	claims, appErr := oauthService.authService.authenticateAccessToken(token)
+	if appErr != nil {
+		return inactive, nil
+	}
+	// a machine token has no user, the client it was issued to is its subject
+	subject := claims.UserName
+	if claims.IsMachineToken() {
+		subject = claims.ClientId
+	}
+	return &dto.IntrospectionResponse{
+		Active:     true,
+		UserName:   claims.UserName,
+		Subject:    subject,
+		TokenType:  "access_token",
+		ExpiresAt:  claims.StandardClaims.ExpiresAt,
+		IssuedAt:   claims.StandardClaims.IssuedAt,
+		Jti:        claims.StandardClaims.Id,
+		Scope:      claims.Scope,
+		ClientId:   claims.ClientId,
+		CustomerId: claims.CustomerId,
+		Roles:      claims.RoleNames(),
+		Accounts:   claims.Accounts,
+	}, nil
+}
+
+// ClientCredentialsToken handles grant_type=client_credentials, the requested scope must be
+// a subset of the client's allowed scopes
+func (oauthService DefaultOAuthService) ClientCredentialsToken(clientId string, clientSecret string, scope string) (*dto.TokenResponse, *exceptions.AppError) {
+	client, appErr := oauthService.AuthenticateClient(clientId, clientSecret)
+	if appErr != nil {
+		return nil, appErr
+	}
+	scopes, ok := client.GrantScopes(strings.Fields(scope))
+	if !ok {
+		return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_SCOPE)
+	}
+	response, appErr := oauthService.tokenService.GenerateClientToken(*client, scopes)
+	if appErr != nil {
+		return nil, exceptions.NewDatabaseError(dto.OAUTH_SERVER_ERROR)
+	}
+	return response, nil
+}
+
+// FindAuthorizeClient validates the client and redirect uri of an authorization request, until both
+// are known to be good errors must be shown to the user rather than sent to the redirect uri
+func (oauthService DefaultOAuthService) FindAuthorizeClient(clientId string, redirectUri string) (*domain.Client, *exceptions.AppError) {
+	client, appErr := oauthService.clientRepository.FindClient(clientId)
+	if appErr != nil {
+		return nil, exceptions.NewUnauthorisedError(dto.OAUTH_INVALID_CLIENT)
+	}
+	if !client.IsRedirectUriAllowed(redirectUri) {
+		return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_REQUEST)
+	}
+	return client, nil
+}
+
+func (oauthService DefaultOAuthService) ValidateAuthorizeRequest(client domain.Client, request dto.AuthorizeRequest) *exceptions.AppError {
+	if request.ResponseType != dto.RESPONSE_TYPE_CODE {
+		return exceptions.NewValidationError(dto.OAUTH_UNSUPPORTED_RESPONSE_TYPE)
+	}
+	// RFC 7636 base64url sha256 challenges are 43 characters
+	if request.CodeChallengeMethod != dto.CODE_CHALLENGE_METHOD_S256 || len(request.CodeChallenge) != 43 {
+		return exceptions.NewValidationError(dto.OAUTH_INVALID_REQUEST)
+	}
+	if _, ok := client.GrantScopes(strings.Fields(request.Scope)); !ok {
+		return exceptions.NewValidationError(dto.OAUTH_INVALID_SCOPE)
+	}
+	return nil
+}
+
+// Authorize checks the user's credentials once they have consented and returns a single use authorization code
+func (oauthService DefaultOAuthService) Authorize(request dto.AuthorizeRequest, userName string, password string,
+	otp string, clientIp string) (string, *exceptions.AppError) {
+	client, appErr := oauthService.FindAuthorizeClient(request.ClientId, request.RedirectUri)
+	if appErr != nil {
+		return "", appErr
+	}
+	if appErr = oauthService.ValidateAuthorizeRequest(*client, request); appErr != nil {
+		return "", appErr
+	}
+	user, appErr := oauthService.authService.authenticateUser(userName, password, clientIp)
+	if appErr != nil {
+		return "", appErr
+	}
+	mfaRequired, appErr := isMfaRequired(oauthService.authService.mfaRepository, user.UserName)
+	if appErr != nil {
+		return "", appErr
+	}
+	if mfaRequired {
+		if appErr = oauthService.authService.checkSecondFactor(user.UserName, otp, clientIp); appErr != nil {
+			return "", appErr
+		}
+	} else if oauthService.authService.mfaEnforced {
+		// the user has to enroll a second factor through /customers/login first
+		return "", exceptions.NewJwtError(dto.OAUTH_ACCESS_DENIED)
+	}
+	scopes, _ := client.GrantScopes(strings.Fields(request.Scope))
+	code := domain.NewTokenId()
+	appErr = oauthService.codeRepository.SaveAuthorizationCode(domain.AuthorizationCode{
+		CodeHash:      domain.HashToken(code),
+		ClientId:      client.ClientId,
+		UserName:      user.UserName,
+		RedirectUri:   request.RedirectUri,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: request.CodeChallenge,
+		Nonce:         request.Nonce,
+		AuthTime:      time.Now().Unix(),
+		ExpiresAt:     time.Now().Add(domain.AUTHORIZATION_CODE_DURATION).Unix(),
+	})
+	if appErr != nil {
+		return "", exceptions.NewDatabaseError(dto.OAUTH_SERVER_ERROR)
+	}
+	return code, nil
+}
+
+// AuthorizationCodeToken handles grant_type=authorization_code, confidential clients must also
+// authenticate while public clients are bound to the code by PKCE alone
+func (oauthService DefaultOAuthService) AuthorizationCodeToken(clientId string, clientSecret string, code string,
+	redirectUri string, codeVerifier string) (*dto.TokenResponse, *exceptions.AppError) {
+	client, appErr := oauthService.authenticateTokenClient(clientId, clientSecret)
+	if appErr != nil {
+		return nil, appErr
+	}
+	authorizationCode, appErr := oauthService.codeRepository.ConsumeAuthorizationCode(domain.HashToken(code))
+	if appErr != nil {
+		if appErr.Code == http.StatusInternalServerError {
+			return nil, exceptions.NewDatabaseError(dto.OAUTH_SERVER_ERROR)
+		}
+		return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_GRANT)
+	}
+	if authorizationCode.ClientId != client.ClientId ||
+		authorizationCode.RedirectUri != redirectUri ||
+		authorizationCode.HasExpired() ||
+		!authorizationCode.IsVerifierValid(codeVerifier) {
+		return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_GRANT)
+	}
+	user, appErr := oauthService.authService.repository.FindUser(authorizationCode.UserName)
+	if appErr != nil {
+		return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_GRANT)
+	}
+	// the user may have been disabled since they consented
+	if checkUserActive(user) != nil {
+		return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_GRANT)
+	}
+	login := oauthService.authService.newDelegatedLogin(*user, client.ClientId, authorizationCode.Scope)
+	login.Nonce = authorizationCode.Nonce
+	login.AuthTime = authorizationCode.AuthTime
+	loginResponse, appErr := oauthService.tokenService.GenerateToken(login)
+	if appErr != nil {
+		return nil, exceptions.NewDatabaseError(dto.OAUTH_SERVER_ERROR)
+	}
+	return &dto.TokenResponse{
+		AccessToken:  loginResponse.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(oauthService.tokenService.Lifetimes().AccessToken.Seconds()),
+		RefreshToken: loginResponse.RefreshToken,
+		Scope:        login.Scope,
+		IdToken:      loginResponse.IdToken,
+	}, nil
+}
+
+// RefreshTokenGrant handles grant_type=refresh_token, the refresh token is rotated as it is at /auth/refresh
+// and only the client it was issued to can use it
+func (oauthService DefaultOAuthService) RefreshTokenGrant(clientId string, clientSecret string, refreshToken string,
+	scope string) (*dto.TokenResponse, *exceptions.AppError) {
+	client, appErr := oauthService.authenticateTokenClient(clientId, clientSecret)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if refreshToken == "" {
+		return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_REQUEST)
+	}
+	loginResponse, appErr := oauthService.authService.rotateRefreshToken(refreshToken, scope, client.ClientId)
+	if appErr != nil {
+		switch appErr.Code {
+		case http.StatusInternalServerError:
+			return nil, exceptions.NewDatabaseError(dto.OAUTH_SERVER_ERROR)
+		case http.StatusUnprocessableEntity:
+			return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_SCOPE)
+		}
+		return nil, exceptions.NewValidationError(dto.OAUTH_INVALID_GRANT)
+	}
+	return &dto.TokenResponse{
+		AccessToken:  loginResponse.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(oauthService.tokenService.Lifetimes().AccessToken.Seconds()),
+		RefreshToken: loginResponse.RefreshToken,
+		Scope:        loginResponse.Scope,
+	}, nil
+}
+
+// authenticateTokenClient identifies the client at the token endpoint, confidential clients must
+// authenticate while public clients are bound to their codes and tokens by client_id alone
+func (oauthService DefaultOAuthService) authenticateTokenClient(clientId string, clientSecret string) (*domain.Client, *exceptions.AppError) {
+	client, appErr := oauthService.clientRepository.FindClient(clientId)
+	if appErr != nil {
+		return nil, exceptions.NewUnauthorisedError(dto.OAUTH_INVALID_CLIENT)
+	}
+	if !client.IsPublic() {
+		if _, appErr = oauthService.AuthenticateClient(clientId, clientSecret); appErr != nil {
+			return nil, appErr
+		}
+	}
+	return client, nil
+}
+
+// UserInfo is the OpenID Connect userinfo endpoint, the access token must carry the openid scope
+func (oauthService DefaultOAuthService) UserInfo(accessToken string) (*dto.UserInfoResponse, *exceptions.AppError) {
+	claims, appErr := oauthService.authService.authenticateAccessToken(accessToken)
+	if appErr != nil {
+		return nil, exceptions.NewUnauthorisedError("invalid_token")
+	}
+	scopes := strings.Fields(claims.Scope)
+	if claims.IsMachineToken() || !containsString(scopes, dto.SCOPE_OPENID) {
+		return nil, exceptions.NewJwtError("insufficient_scope")
+	}
+	user, appErr := oauthService.authService.repository.FindUser(claims.UserName)
+	if appErr != nil {
+		return nil, exceptions.NewUnauthorisedError("invalid_token")
+	}
+	userInfo := dto.UserInfoResponse{Subject: user.UserName}
+	if containsString(scopes, dto.SCOPE_PROFILE) {
+		userInfo.PreferredUserName = user.UserName
+		userInfo.Roles = user.Roles
+		if user.CustomerId != 0 {
+			userInfo.CustomerId = strconv.Itoa(user.CustomerId)
+		}
+		if user.AccountNumbers != "" {
+			userInfo.Accounts = strings.Split(user.AccountNumbers, ",")
+		}
+	}
+	return &userInfo, nil
+}
+
+func (oauthService DefaultOAuthService) OpenIdConfiguration() dto.OpenIdConfiguration {
+	issuer := oauthService.tokenService.Issuer()
+	scopes := append([]string{dto.SCOPE_OPENID, dto.SCOPE_PROFILE}, oauthService.authService.rolesPermissions.Scopes()...)
+	return dto.OpenIdConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JwksUri:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		RevocationEndpoint:                issuer + "/auth/revoke",
+		ScopesSupported:                   scopes,
+		ResponseTypesSupported:            []string{dto.RESPONSE_TYPE_CODE},
+		GrantTypesSupported:               []string{dto.GRANT_TYPE_AUTHORIZATION_CODE, dto.GRANT_TYPE_CLIENT_CREDENTIALS, dto.GRANT_TYPE_REFRESH_TOKEN},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{oauthService.authService.keyRing.SigningKey().Method.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{dto.CODE_CHALLENGE_METHOD_S256},
+		ClaimsSupported: []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username",
+			"customer_id", "roles"},
+	}
+}
+
+func NewOAuthService(authService DefaultAuthService, tokenService DefaultTokenService,
+	clientRepository domain.ClientRepository, codeRepository domain.AuthorizationCodeRepository,
+	passwordHasher domain.PasswordHasher) DefaultOAuthService {
+	return DefaultOAuthService{authService: authService, tokenService: tokenService, clientRepository: clientRepository,
+		codeRepository: codeRepository, passwordHasher: passwordHasher}
+}
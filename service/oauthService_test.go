@@ -0,0 +1,84 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"testing"
+)
+
+func (fixture authFixture) oauthService(t *testing.T) DefaultOAuthService {
+	passwordHasher, err := domain.NewPasswordHasher("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewOAuthService(fixture.service, fixture.tokenService, fixture.storage.Clients,
+		fixture.storage.AuthorizationCodes, passwordHasher)
+}
+
+func TestIntrospectRefusesIdToken(t *testing.T) {
+	fixture := newAuthFixture(t)
+	oauthService := fixture.oauthService(t)
+	tokens := fixture.issue(t, fixture.service.newDelegatedLogin(fixture.findUser(t, testUser), testClient, "openid"))
+	if tokens.IdToken == "" {
+		t.Fatal("no id token was issued for the openid scope")
+	}
+
+	response, appErr := oauthService.Introspect(testClient, testClientSecret, tokens.IdToken)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if response.Active {
+		t.Fatalf("id token introspected as an active %s", response.TokenType)
+	}
+	response, appErr = oauthService.Introspect(testClient, testClientSecret, tokens.Token)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if !response.Active || response.Subject != testUser || response.ClientId != testClient || response.Scope != "openid" {
+		t.Fatalf("access token introspected as %+v", response)
+	}
+}
+
+func TestIntrospectMachineToken(t *testing.T) {
+	fixture := newAuthFixture(t)
+	oauthService := fixture.oauthService(t)
+	tokens, appErr := oauthService.ClientCredentialsToken(testClient, testClientSecret, "customers:read")
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+
+	response, appErr := oauthService.Introspect(testClient, testClientSecret, tokens.AccessToken)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if !response.Active || response.Subject != testClient || response.ClientId != testClient ||
+		response.Scope != "customers:read" || response.UserName != "" {
+		t.Fatalf("machine token introspected as %+v", response)
+	}
+}
+
+func TestRefreshTokenGrantRotatesClientToken(t *testing.T) {
+	fixture := newAuthFixture(t)
+	oauthService := fixture.oauthService(t)
+	tokens := fixture.delegatedLogin(t, testUser)
+	if _, appErr := fixture.service.rotateRefreshToken(tokens.RefreshToken, "", ""); appErr == nil {
+		t.Fatal("client refresh token was refreshed as a first party token")
+	}
+
+	response, appErr := oauthService.RefreshTokenGrant(testClient, testClientSecret, tokens.RefreshToken, "")
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if response.AccessToken == "" || response.RefreshToken == "" || response.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("refresh grant returned %+v", response)
+	}
+	_, appErr = oauthService.RefreshTokenGrant(testClient, testClientSecret, tokens.RefreshToken, "")
+	if appErr == nil || appErr.Message != dto.OAUTH_INVALID_GRANT {
+		t.Fatalf("used refresh token got %v, want %s", appErr, dto.OAUTH_INVALID_GRANT)
+	}
+	// presenting the used token revoked its family, the rotated token included
+	_, appErr = oauthService.RefreshTokenGrant(testClient, testClientSecret, response.RefreshToken, "")
+	if appErr == nil || appErr.Message != dto.OAUTH_INVALID_GRANT {
+		t.Fatalf("rotated refresh token got %v after reuse, want %s", appErr, dto.OAUTH_INVALID_GRANT)
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"net/url"
+	"time"
+)
+
+type PasswordService interface {
+	ChangePassword(accessToken string, request dto.ChangePasswordRequest, clientIp string) ([]dto.PasswordRuleViolation, *exceptions.AppError)
+	ForgotPassword(request dto.ForgotPasswordRequest) *exceptions.AppError
+	ResetPassword(request dto.ResetPasswordRequest) ([]dto.PasswordRuleViolation, *exceptions.AppError)
+	CheckPassword(accessToken string, request dto.PasswordCheckRequest) (*dto.PasswordCheckResponse, *exceptions.AppError)
+}
+
+type DefaultPasswordService struct {
+	authService           DefaultAuthService
+	userAccountRepository domain.UserAccountRepository
+	mailSender            domain.MailSender
+	// the page reset emails link to, the token is appended as a query parameter
+	resetUrl string
+}
+
+// ChangePassword needs the current password as well as the access token so a stolen token alone
+// cannot take over the account, wrong current passwords count towards the login lockout. A new password
+// that breaks the policy is refused with the list of broken rules
+func (passwordService DefaultPasswordService) ChangePassword(accessToken string, request dto.ChangePasswordRequest, clientIp string) ([]dto.PasswordRuleViolation, *exceptions.AppError) {
+	claims, appErr := passwordService.authService.authenticateAccessToken(accessToken)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if claims.IsMachineToken() {
+		return nil, exceptions.NewJwtError("only users have passwords")
+	}
+	if _, appErr = passwordService.authService.authenticateUser(claims.UserName, request.CurrentPassword, clientIp); appErr != nil {
+		return nil, appErr
+	}
+	return passwordService.setPassword(claims.UserName, request.NewPassword)
+}
+
+// ForgotPassword emails a reset link when the user exists and has an email address. It succeeds
+// either way, also when the email can't be sent, so the response does not reveal which user names exist
+func (passwordService DefaultPasswordService) ForgotPassword(request dto.ForgotPasswordRequest) *exceptions.AppError {
+	account, appErr := passwordService.userAccountRepository.FindUserAccount(request.UserName)
+	if appErr != nil {
+		return appErr
+	}
+	if account == nil || !account.Email.Valid || account.Status == domain.USER_STATUS_DISABLED {
+		logger.Info("Password reset requested for unknown or unreachable user " + request.UserName)
+		return nil
+	}
+	token := domain.NewTokenId()
+	expiresAt := time.Now().Add(domain.PASSWORD_RESET_DURATION).Unix()
+	if appErr = passwordService.userAccountRepository.SavePasswordResetToken(domain.HashToken(token), account.UserName, expiresAt); appErr != nil {
+		return appErr
+	}
+	body := "A password reset was requested for your account. To choose a new password follow:\n\n" +
+		passwordService.resetUrl + "?token=" + url.QueryEscape(token) + "\n\n" +
+		"The link expires in 30 minutes. If you did not ask for this you can ignore this email."
+	if err := passwordService.mailSender.Send(account.Email.String, "Reset your password", body); err != nil {
+		logger.Error("Error sending password reset email : " + err.Error())
+	}
+	return nil
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword, the token can only be used once
+func (passwordService DefaultPasswordService) ResetPassword(request dto.ResetPasswordRequest) ([]dto.PasswordRuleViolation, *exceptions.AppError) {
+	tokenHash := domain.HashToken(request.Token)
+	userName, appErr := passwordService.userAccountRepository.FindPasswordResetToken(tokenHash)
+	if appErr != nil {
+		return nil, appErr
+	}
+	// the whole policy, password history included, is checked before the token is used up so a rejected
+	// password can be corrected with the same reset link
+	violations, appErr := passwordService.authService.passwordViolations(userName, request.NewPassword)
+	if appErr != nil || len(violations) > 0 {
+		return violations, appErr
+	}
+	if _, appErr = passwordService.userAccountRepository.ConsumePasswordResetToken(tokenHash); appErr != nil {
+		return nil, appErr
+	}
+	violations, appErr = passwordService.setPassword(userName, request.NewPassword)
+	if appErr != nil || len(violations) > 0 {
+		return violations, appErr
+	}
+	// any other reset link sent to the user stops working once one has been used
+	if appErr = passwordService.userAccountRepository.InvalidatePasswordResetTokens(userName); appErr != nil {
+		return nil, appErr
+	}
+	// proving ownership of the email address is as good as a successful login
+	if appErr = passwordService.authService.loginAttempts.ResetLoginAttempts(domain.UserAttemptKey(userName)); appErr != nil {
+		logger.Error("Error resetting login attempts : " + appErr.Message)
+	}
+	return nil, nil
+}
+
+// CheckPassword lists the policy rules a candidate password breaks so a front end can show them while
+// the user types, with an access token the user's password history is checked too
+func (passwordService DefaultPasswordService) CheckPassword(accessToken string, request dto.PasswordCheckRequest) (*dto.PasswordCheckResponse, *exceptions.AppError) {
+	userName := ""
+	if accessToken != "" {
+		claims, appErr := passwordService.authService.authenticateAccessToken(accessToken)
+		if appErr != nil {
+			return nil, appErr
+		}
+		userName = claims.UserName
+	}
+	violations, appErr := passwordService.authService.passwordViolations(userName, request.Password)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return &dto.PasswordCheckResponse{Valid: len(violations) == 0, Violations: violations}, nil
+}
+
+// setPassword stores the new password and revokes every refresh token of the user, so sessions
+// started with the old password end when their access token expires. Nothing is stored when the
+// password breaks the policy, the broken rules are returned instead
+func (passwordService DefaultPasswordService) setPassword(userName string, password string) ([]dto.PasswordRuleViolation, *exceptions.AppError) {
+	violations, appErr := passwordService.authService.passwordViolations(userName, password)
+	if appErr != nil || len(violations) > 0 {
+		return violations, appErr
+	}
+	hash, err := passwordService.authService.passwordHasher.Hash(password)
+	if err != nil {
+		logger.Error("Error hashing password : " + err.Error())
+		return nil, exceptions.NewDatabaseError("Error hashing password")
+	}
+	historySize := passwordService.authService.passwordPolicy.HistorySize
+	if appErr = passwordService.authService.repository.ChangePassword(userName, hash, historySize); appErr != nil {
+		return nil, appErr
+	}
+	return nil, passwordService.authService.repository.RevokeUserRefreshTokens(userName)
+}
+
+func NewPasswordService(authService DefaultAuthService, userAccountRepository domain.UserAccountRepository,
+	mailSender domain.MailSender, resetUrl string) DefaultPasswordService {
+	return DefaultPasswordService{authService: authService, userAccountRepository: userAccountRepository,
+		mailSender: mailSender, resetUrl: resetUrl}
+}
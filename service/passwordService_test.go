@@ -0,0 +1,36 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"testing"
+	"time"
+)
+
+func TestResetPasswordKeepsTokenWhenPasswordReused(t *testing.T) {
+	fixture := newAuthFixture(t)
+	passwordService := NewPasswordService(fixture.service, fixture.storage.UserAccounts, domain.LogMailSender{},
+		testOrigin)
+	token := domain.NewTokenId()
+	appErr := fixture.storage.UserAccounts.SavePasswordResetToken(domain.HashToken(token), testUser,
+		time.Now().Add(time.Hour).Unix())
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+
+	violations, appErr := passwordService.ResetPassword(dto.ResetPasswordRequest{Token: token, NewPassword: testPassword})
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if len(violations) != 1 || violations[0].Rule != domain.PASSWORD_RULE_HISTORY {
+		t.Fatalf("reusing the current password gave %v", violations)
+	}
+	request := dto.ResetPasswordRequest{Token: token, NewPassword: "a brand new passphrase"}
+	if violations, appErr = passwordService.ResetPassword(request); appErr != nil || len(violations) > 0 {
+		t.Fatalf("the reset link stopped working after a rejected password: %v %v", violations, appErr)
+	}
+	request.NewPassword = "another new passphrase"
+	if _, appErr = passwordService.ResetPassword(request); appErr == nil {
+		t.Fatal("the reset link was used twice")
+	}
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"database/sql"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var scopePattern = regexp.MustCompile(`^[A-Za-z0-9:._-]+$`)
+
+type RoleService interface {
+	GetRoles(adminToken string) ([]dto.RoleResponse, *exceptions.AppError)
+	CreateRole(adminToken string, request dto.RoleRequest) *exceptions.AppError
+	DeleteRole(adminToken string, roleName string) *exceptions.AppError
+	GetPermissions(adminToken string) ([]dto.PermissionResponse, *exceptions.AppError)
+	CreatePermission(adminToken string, request dto.PermissionRequest) *exceptions.AppError
+	UpdatePermission(adminToken string, permissionName string, request dto.PermissionRequest) *exceptions.AppError
+	DeletePermission(adminToken string, permissionName string) *exceptions.AppError
+	GrantPermission(adminToken string, roleName string, permissionName string, effect string) *exceptions.AppError
+	RevokePermission(adminToken string, roleName string, permissionName string, effect string) *exceptions.AppError
+	AddParentRole(adminToken string, roleName string, parentRoleName string) *exceptions.AppError
+	RemoveParentRole(adminToken string, roleName string, parentRoleName string) *exceptions.AppError
+	Refresh(adminToken string) *exceptions.AppError
+}
+
+// DefaultRoleService manages the roles tables, every change is loaded into the shared RolePermissions
+// straight away, other instances pick it up on their next refresh
+type DefaultRoleService struct {
+	authService    DefaultAuthService
+	roleRepository domain.RoleRepository
+}
+
+func (roleService DefaultRoleService) GetRoles(adminToken string) ([]dto.RoleResponse, *exceptions.AppError) {
+	if _, appErr := roleService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return nil, appErr
+	}
+	roles, appErr := roleService.roleRepository.FindRoles()
+	if appErr != nil {
+		return nil, appErr
+	}
+	definitions, appErr := roleService.roleRepository.FindRoleDefinitions()
+	if appErr != nil {
+		return nil, appErr
+	}
+	response := make([]dto.RoleResponse, 0, len(roles))
+	for _, role := range roles {
+		definition := definitions[role.Name]
+		sort.Strings(definition.Allow)
+		sort.Strings(definition.Deny)
+		response = append(response, dto.RoleResponse{Name: role.Name, Description: role.Description.String,
+			Parents: definition.Parents, Permissions: definition.Allow, Denied: definition.Deny})
+	}
+	return response, nil
+}
+
+func (roleService DefaultRoleService) CreateRole(adminToken string, request dto.RoleRequest) *exceptions.AppError {
+	if _, appErr := roleService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return appErr
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		return exceptions.NewValidationError("role name is required")
+	}
+	if roleService.authService.rolesPermissions.IsRoleDefined(name) {
+		return exceptions.NewValidationError("role " + name + " already exists")
+	}
+	role := domain.Role{Name: name, Description: sql.NullString{String: request.Description, Valid: request.Description != ""}}
+	if appErr := roleService.roleRepository.CreateRole(role); appErr != nil {
+		return appErr
+	}
+	return roleService.reload()
+}
+
+func (roleService DefaultRoleService) DeleteRole(adminToken string, roleName string) *exceptions.AppError {
+	if _, appErr := roleService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return appErr
+	}
+	if !roleService.authService.rolesPermissions.IsRoleDefined(roleName) {
+		return exceptions.NewNotFoundError("role not found")
+	}
+	if appErr := roleService.roleRepository.DeleteRole(roleName); appErr != nil {
+		return appErr
+	}
+	return roleService.reload()
+}
+
+func (roleService DefaultRoleService) GetPermissions(adminToken string) ([]dto.PermissionResponse, *exceptions.AppError) {
+	if _, appErr := roleService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return nil, appErr
+	}
+	permissions, appErr := roleService.roleRepository.FindPermissions()
+	if appErr != nil {
+		return nil, appErr
+	}
+	response := make([]dto.PermissionResponse, 0, len(permissions))
+	for _, permission := range permissions {
+		response = append(response, dto.PermissionResponse{Name: permission.Name, Description: permission.Description.String,
+			Scope: permission.Scope.String})
+	}
+	return response, nil
+}
+
+// CreatePermission registers an operation name, banking-api endpoints pass it as the operation to /auth/verify
+func (roleService DefaultRoleService) CreatePermission(adminToken string, request dto.PermissionRequest) *exceptions.AppError {
+	if _, appErr := roleService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return appErr
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		return exceptions.NewValidationError("permission name is required")
+	}
+	if exists, appErr := roleService.permissionExists(name); appErr != nil || exists {
+		if appErr != nil {
+			return appErr
+		}
+		return exceptions.NewValidationError("permission " + name + " already exists")
+	}
+	permission, appErr := newPermission(name, request)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr = roleService.roleRepository.CreatePermission(permission); appErr != nil {
+		return appErr
+	}
+	return roleService.reload()
+}
+
+// UpdatePermission changes the description and scope, a changed scope applies to tokens issued from then on
+func (roleService DefaultRoleService) UpdatePermission(adminToken string, permissionName string,
+	request dto.PermissionRequest) *exceptions.AppError {
+	if _, appErr := roleService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return appErr
+	}
+	if exists, appErr := roleService.permissionExists(permissionName); appErr != nil || !exists {
+		if appErr != nil {
+			return appErr
+		}
+		return exceptions.NewNotFoundError("permission not found")
+	}
+	permission, appErr := newPermission(permissionName, request)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr = roleService.roleRepository.UpdatePermission(permission); appErr != nil {
+		return appErr
+	}
+	return roleService.reload()
+}
+
+func (roleService DefaultRoleService) DeletePermission(adminToken string, permissionName string) *exceptions.AppError {
+	if _, appErr := roleService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return appErr
+	}
+	if exists, appErr := roleService.permissionExists(permissionName); appErr != nil || !exists {
+		if appErr != nil {
+			return appErr
+		}
+		return exceptions.NewNotFoundError("permission not found")
+	}
+	if appErr := roleService.roleRepository.DeletePermission(permissionName); appErr != nil {
+		return appErr
+	}
+	return roleService.reload()
+}
+
+// GrantPermission adds an allow or deny rule to the role, the permission may be a wildcard such as Get*
+func (roleService DefaultRoleService) GrantPermission(adminToken string, roleName string, permissionName string,
+	effect string) *exceptions.AppError {
+	admin, appErr := roleService.authService.authoriseAdmin(adminToken)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr = roleService.checkGrant(roleName, permissionName); appErr != nil {
+		return appErr
+	}
+	definitions, appErr := roleService.roleRepository.FindRoleDefinitions()
+	if appErr != nil {
+		return appErr
+	}
+	if hasRule(definitions[roleName], permissionName, effect) {
+		return nil
+	}
+	if appErr = roleService.roleRepository.GrantPermission(roleName, permissionName, effect); appErr != nil {
+		return appErr
+	}
+	logger.Info("Permission " + permissionName + " " + effect + " added to role " + roleName + " by " + admin.UserName)
+	return roleService.reload()
+}
+
+func (roleService DefaultRoleService) RevokePermission(adminToken string, roleName string, permissionName string,
+	effect string) *exceptions.AppError {
+	admin, appErr := roleService.authService.authoriseAdmin(adminToken)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr = roleService.checkGrant(roleName, permissionName); appErr != nil {
+		return appErr
+	}
+	if appErr = roleService.roleRepository.RevokePermission(roleName, permissionName, effect); appErr != nil {
+		return appErr
+	}
+	logger.Info("Permission " + permissionName + " " + effect + " removed from role " + roleName + " by " + admin.UserName)
+	return roleService.reload()
+}
+
+// AddParentRole makes the role inherit the parent's permissions, edges that would create a cycle are refused
+func (roleService DefaultRoleService) AddParentRole(adminToken string, roleName string, parentRoleName string) *exceptions.AppError {
+	admin, appErr := roleService.authService.authoriseAdmin(adminToken)
+	if appErr != nil {
+		return appErr
+	}
+	definitions, appErr := roleService.roleRepository.FindRoleDefinitions()
+	if appErr != nil {
+		return appErr
+	}
+	definition, ok := definitions[roleName]
+	if !ok {
+		return exceptions.NewNotFoundError("role not found")
+	}
+	if _, ok = definitions[parentRoleName]; !ok {
+		return exceptions.NewNotFoundError("parent role not found")
+	}
+	for _, parent := range definition.Parents {
+		if parent == parentRoleName {
+			return nil
+		}
+	}
+	definition.Parents = append(definition.Parents, parentRoleName)
+	definitions[roleName] = definition
+	if _, appErr = domain.ResolveRoleHierarchy(definitions); appErr != nil {
+		return appErr
+	}
+	if appErr = roleService.roleRepository.AddParentRole(roleName, parentRoleName); appErr != nil {
+		return appErr
+	}
+	logger.Info("Role " + roleName + " now inherits " + parentRoleName + ", changed by " + admin.UserName)
+	return roleService.reload()
+}
+
+func (roleService DefaultRoleService) RemoveParentRole(adminToken string, roleName string, parentRoleName string) *exceptions.AppError {
+	admin, appErr := roleService.authService.authoriseAdmin(adminToken)
+	if appErr != nil {
+		return appErr
+	}
+	if !roleService.authService.rolesPermissions.IsRoleDefined(roleName) {
+		return exceptions.NewNotFoundError("role not found")
+	}
+	if appErr = roleService.roleRepository.RemoveParentRole(roleName, parentRoleName); appErr != nil {
+		return appErr
+	}
+	logger.Info("Role " + roleName + " no longer inherits " + parentRoleName + ", changed by " + admin.UserName)
+	return roleService.reload()
+}
+
+// Refresh reloads the roles on demand, for changes made directly in the database
+func (roleService DefaultRoleService) Refresh(adminToken string) *exceptions.AppError {
+	if _, appErr := roleService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return appErr
+	}
+	return roleService.reload()
+}
+
+func (roleService DefaultRoleService) checkGrant(roleName string, permissionName string) *exceptions.AppError {
+	if !roleService.authService.rolesPermissions.IsRoleDefined(roleName) {
+		return exceptions.NewNotFoundError("role not found")
+	}
+	// wildcards match registered permissions so they needn't be registered themselves
+	if strings.Contains(permissionName, "*") {
+		return nil
+	}
+	exists, appErr := roleService.permissionExists(permissionName)
+	if appErr != nil {
+		return appErr
+	}
+	if !exists {
+		return exceptions.NewNotFoundError("permission not found")
+	}
+	return nil
+}
+
+func (roleService DefaultRoleService) permissionExists(permissionName string) (bool, *exceptions.AppError) {
+	permissions, appErr := roleService.roleRepository.FindPermissions()
+	if appErr != nil {
+		return false, appErr
+	}
+	for _, permission := range permissions {
+		if permission.Name == permissionName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newPermission(name string, request dto.PermissionRequest) (domain.Permission, *exceptions.AppError) {
+	scope := strings.TrimSpace(request.Scope)
+	if scope != "" && !scopePattern.MatchString(scope) {
+		return domain.Permission{}, exceptions.NewValidationError("scope must be letters, digits or : . _ -")
+	}
+	return domain.Permission{Name: name,
+		Description: sql.NullString{String: request.Description, Valid: request.Description != ""},
+		Scope:       sql.NullString{String: scope, Valid: scope != ""}}, nil
+}
+
+func hasRule(definition domain.RoleDefinition, permissionName string, effect string) bool {
+	rules := definition.Allow
+	if effect == domain.PERMISSION_EFFECT_DENY {
+		rules = definition.Deny
+	}
+	for _, rule := range rules {
+		if rule == permissionName {
+			return true
+		}
+	}
+	return false
+}
+
+func (roleService DefaultRoleService) reload() *exceptions.AppError {
+	return roleService.authService.rolesPermissions.Reload(roleService.roleRepository)
+}
+
+func NewRoleService(authService DefaultAuthService, roleRepository domain.RoleRepository) DefaultRoleService {
+	return DefaultRoleService{authService: authService, roleRepository: roleRepository}
+}
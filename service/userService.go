@@ -0,0 +1,303 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"database/sql"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const SELF_REGISTERED_ROLE string = "user"
+
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{3,64}$`)
+
+type UserService interface {
+	CreateUser(adminToken string, request dto.CreateUserRequest) (*dto.UserResponse, []dto.PasswordRuleViolation, *exceptions.AppError)
+	GetUser(adminToken string, userName string) (*dto.UserResponse, *exceptions.AppError)
+	GetUsers(adminToken string) ([]dto.UserResponse, *exceptions.AppError)
+	UpdateUser(adminToken string, userName string, request dto.UpdateUserRequest) (*dto.UserResponse, *exceptions.AppError)
+	SetUserEnabled(adminToken string, userName string, enabled bool) *exceptions.AppError
+	DeleteUser(adminToken string, userName string) *exceptions.AppError
+	Register(request dto.CreateUserRequest) ([]dto.PasswordRuleViolation, *exceptions.AppError)
+	VerifyEmail(token string) *exceptions.AppError
+}
+
+type DefaultUserService struct {
+	authService           DefaultAuthService
+	userAccountRepository domain.UserAccountRepository
+	mailSender            domain.MailSender
+	selfRegistration      bool
+	// the link in verification emails, the token is appended as a query parameter
+	verificationUrl string
+}
+
+// CreateUser adds an active user, a password that breaks the policy is refused with the list of broken rules
+func (userService DefaultUserService) CreateUser(adminToken string, request dto.CreateUserRequest) (*dto.UserResponse, []dto.PasswordRuleViolation, *exceptions.AppError) {
+	if _, appErr := userService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return nil, nil, appErr
+	}
+	account := domain.UserAccount{
+		UserName:   request.UserName,
+		Email:      sql.NullString{String: request.Email, Valid: request.Email != ""},
+		Roles:      uniqueRoles(request.Roles),
+		CustomerId: nullCustomerId(request.CustomerId),
+		Status:     domain.USER_STATUS_ACTIVE,
+	}
+	violations, appErr := userService.createUser(account, request.Password)
+	if appErr != nil || len(violations) > 0 {
+		return nil, violations, appErr
+	}
+	return newUserResponse(account), nil, nil
+}
+
+func (userService DefaultUserService) GetUser(adminToken string, userName string) (*dto.UserResponse, *exceptions.AppError) {
+	if _, appErr := userService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return nil, appErr
+	}
+	account, appErr := userService.findUserAccount(userName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return newUserResponse(*account), nil
+}
+
+func (userService DefaultUserService) GetUsers(adminToken string) ([]dto.UserResponse, *exceptions.AppError) {
+	if _, appErr := userService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return nil, appErr
+	}
+	accounts, appErr := userService.userAccountRepository.FindUserAccounts()
+	if appErr != nil {
+		return nil, appErr
+	}
+	users := make([]dto.UserResponse, 0, len(accounts))
+	for _, account := range accounts {
+		users = append(users, *newUserResponse(account))
+	}
+	return users, nil
+}
+
+// UpdateUser assigns the roles and customer link, the user's sessions are ended when either changes so
+// no token keeps the old roles or accounts
+func (userService DefaultUserService) UpdateUser(adminToken string, userName string, request dto.UpdateUserRequest) (*dto.UserResponse, *exceptions.AppError) {
+	if _, appErr := userService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return nil, appErr
+	}
+	account, appErr := userService.findUserAccount(userName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	previousRoles := account.Roles
+	previousCustomerId := account.CustomerId
+	account.Email = sql.NullString{String: request.Email, Valid: request.Email != ""}
+	account.Roles = uniqueRoles(request.Roles)
+	account.CustomerId = nullCustomerId(request.CustomerId)
+	if appErr = userService.validateAccount(*account); appErr != nil {
+		return nil, appErr
+	}
+	if appErr = userService.userAccountRepository.UpdateUser(*account); appErr != nil {
+		return nil, appErr
+	}
+	if !sameRoles(previousRoles, account.Roles) || previousCustomerId != account.CustomerId {
+		if appErr = userService.authService.revokeUserSessions(userName); appErr != nil {
+			return nil, appErr
+		}
+	}
+	return newUserResponse(*account), nil
+}
+
+// SetUserEnabled disables or re-enables a user, disabling also ends every session the user has
+func (userService DefaultUserService) SetUserEnabled(adminToken string, userName string, enabled bool) *exceptions.AppError {
+	if _, appErr := userService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return appErr
+	}
+	if _, appErr := userService.findUserAccount(userName); appErr != nil {
+		return appErr
+	}
+	if enabled {
+		return userService.userAccountRepository.SetUserStatus(userName, domain.USER_STATUS_ACTIVE)
+	}
+	if appErr := userService.userAccountRepository.SetUserStatus(userName, domain.USER_STATUS_DISABLED); appErr != nil {
+		return appErr
+	}
+	return userService.authService.revokeUserSessions(userName)
+}
+
+func (userService DefaultUserService) DeleteUser(adminToken string, userName string) *exceptions.AppError {
+	if _, appErr := userService.authService.authoriseAdmin(adminToken); appErr != nil {
+		return appErr
+	}
+	if _, appErr := userService.findUserAccount(userName); appErr != nil {
+		return appErr
+	}
+	// revoke first, the revocation outlives the user so the access tokens already issued stop verifying
+	if appErr := userService.authService.revokeUserSessions(userName); appErr != nil {
+		return appErr
+	}
+	return userService.userAccountRepository.DeleteUser(userName)
+}
+
+// Register creates a customer login that stays pending until the email address is verified. Self
+// registered users are never linked to a customer, an admin links them once the customer is known
+func (userService DefaultUserService) Register(request dto.CreateUserRequest) ([]dto.PasswordRuleViolation, *exceptions.AppError) {
+	if !userService.selfRegistration {
+		return nil, exceptions.NewNotFoundError("self registration is not enabled")
+	}
+	if request.Email == "" {
+		return nil, exceptions.NewValidationError("email is required")
+	}
+	account := domain.UserAccount{
+		UserName: request.UserName,
+		Email:    sql.NullString{String: request.Email, Valid: true},
+		Roles:    []string{SELF_REGISTERED_ROLE},
+		Status:   domain.USER_STATUS_PENDING,
+	}
+	violations, appErr := userService.createUser(account, request.Password)
+	if appErr != nil || len(violations) > 0 {
+		return violations, appErr
+	}
+	token := domain.NewTokenId()
+	expiresAt := time.Now().Add(domain.EMAIL_VERIFICATION_DURATION).Unix()
+	if appErr = userService.userAccountRepository.SaveVerificationToken(domain.HashToken(token), account.UserName, expiresAt); appErr != nil {
+		return nil, appErr
+	}
+	body := "Confirm your email address to activate your account:\n\n" +
+		userService.verificationUrl + "?token=" + url.QueryEscape(token) + "\n\n" +
+		"The link expires in 24 hours."
+	if err := userService.mailSender.Send(request.Email, "Verify your email address", body); err != nil {
+		logger.Error("Error sending verification email : " + err.Error())
+		return nil, exceptions.NewDatabaseError("Error sending verification email")
+	}
+	return nil, nil
+}
+
+// VerifyEmail activates the pending user the verification token was sent to
+func (userService DefaultUserService) VerifyEmail(token string) *exceptions.AppError {
+	userName, appErr := userService.userAccountRepository.ConsumeVerificationToken(domain.HashToken(token))
+	if appErr != nil {
+		return appErr
+	}
+	account, appErr := userService.findUserAccount(userName)
+	if appErr != nil {
+		return appErr
+	}
+	// an admin may have disabled the user since they registered
+	if account.Status != domain.USER_STATUS_PENDING {
+		return exceptions.NewValidationError("invalid or expired verification token")
+	}
+	return userService.userAccountRepository.SetUserStatus(userName, domain.USER_STATUS_ACTIVE)
+}
+
+// createUser stores a new user, nothing is stored when the password breaks the policy and the broken
+// rules are returned instead. There is no password history yet so that rule is skipped
+func (userService DefaultUserService) createUser(account domain.UserAccount, password string) ([]dto.PasswordRuleViolation, *exceptions.AppError) {
+	if appErr := userService.validateAccount(account); appErr != nil {
+		return nil, appErr
+	}
+	violations, appErr := userService.authService.passwordViolations("", password)
+	if appErr != nil || len(violations) > 0 {
+		return violations, appErr
+	}
+	existing, appErr := userService.userAccountRepository.FindUserAccount(account.UserName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if existing != nil {
+		return nil, exceptions.NewValidationError("user name is already taken")
+	}
+	hash, err := userService.authService.passwordHasher.Hash(password)
+	if err != nil {
+		logger.Error("Error hashing password : " + err.Error())
+		return nil, exceptions.NewDatabaseError("Error hashing password")
+	}
+	return nil, userService.userAccountRepository.CreateUser(account, hash)
+}
+
+func (userService DefaultUserService) validateAccount(account domain.UserAccount) *exceptions.AppError {
+	if !userNamePattern.MatchString(account.UserName) {
+		return exceptions.NewValidationError("user name must be 3 to 64 letters, digits or . _ @ -")
+	}
+	if account.Email.Valid {
+		if _, err := mail.ParseAddress(account.Email.String); err != nil {
+			return exceptions.NewValidationError("invalid email address")
+		}
+	}
+	if len(account.Roles) == 0 {
+		return exceptions.NewValidationError("at least one role is required")
+	}
+	for _, role := range account.Roles {
+		if !userService.authService.rolesPermissions.IsRoleDefined(role) {
+			return exceptions.NewValidationError("unknown role " + role)
+		}
+	}
+	return nil
+}
+
+func (userService DefaultUserService) findUserAccount(userName string) (*domain.UserAccount, *exceptions.AppError) {
+	account, appErr := userService.userAccountRepository.FindUserAccount(userName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if account == nil {
+		return nil, exceptions.NewNotFoundError("user not found")
+	}
+	return account, nil
+}
+
+// uniqueRoles drops blank and repeated roles, keeping the order they were given in
+func uniqueRoles(roles []string) []string {
+	unique := make([]string, 0, len(roles))
+	for _, role := range roles {
+		role = strings.TrimSpace(role)
+		if role != "" && !containsString(unique, role) {
+			unique = append(unique, role)
+		}
+	}
+	return unique
+}
+
+// sameRoles compares role assignments, the order roles are listed in does not matter
+func sameRoles(left []string, right []string) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	for _, role := range left {
+		if !containsString(right, role) {
+			return false
+		}
+	}
+	return true
+}
+
+func nullCustomerId(customerId *int64) sql.NullInt64 {
+	if customerId == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *customerId, Valid: true}
+}
+
+func newUserResponse(account domain.UserAccount) *dto.UserResponse {
+	response := dto.UserResponse{
+		UserName:  account.UserName,
+		Email:     account.Email.String,
+		Roles:     account.Roles,
+		Status:    account.Status,
+		CreatedOn: account.CreatedDate.String,
+	}
+	if account.CustomerId.Valid {
+		customerId := account.CustomerId.Int64
+		response.CustomerId = &customerId
+	}
+	return &response
+}
+
+func NewUserAccountService(authService DefaultAuthService, userAccountRepository domain.UserAccountRepository,
+	mailSender domain.MailSender, selfRegistration bool, verificationUrl string) DefaultUserService {
+	return DefaultUserService{authService: authService, userAccountRepository: userAccountRepository,
+		mailSender: mailSender, selfRegistration: selfRegistration, verificationUrl: verificationUrl}
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/barnettt/banking-lib/logger"
+	"strconv"
+	"time"
+)
+
+const PUBLIC_KEY_CREDENTIAL_TYPE string = "public-key"
+
+type WebAuthnService interface {
+	BeginRegistration(accessToken string, request dto.WebAuthnRegistrationRequest) (*dto.CredentialCreationOptions, *exceptions.AppError)
+	FinishRegistration(accessToken string, credential dto.RegistrationCredential) (*dto.CredentialDescriptor, *exceptions.AppError)
+	BeginLogin(request dto.WebAuthnLoginRequest) (*dto.CredentialRequestOptions, *exceptions.AppError)
+	FinishLogin(credential dto.AssertionCredential) (*dto.LoginResponse, *exceptions.AppError)
+}
+
+type DefaultWebAuthnService struct {
+	authService        DefaultAuthService
+	webAuthnRepository domain.WebAuthnRepository
+	// the relying party id is the domain passkeys are scoped to, origins are the exact
+	// scheme://host[:port] values browsers may report for our pages
+	rpId    string
+	rpName  string
+	origins []string
+}
+
+// BeginRegistration starts adding a passkey to the account of an already logged in user. A passkey signs
+// the user in on its own, so a stolen access token is not enough, the user must prove who they are again
+// and only the challenge issued then can be answered to finish the registration
+func (webAuthnService DefaultWebAuthnService) BeginRegistration(accessToken string, request dto.WebAuthnRegistrationRequest) (*dto.CredentialCreationOptions, *exceptions.AppError) {
+	claims, appErr := webAuthnService.authService.authenticateFirstPartyUser(accessToken)
+	if appErr != nil {
+		return nil, appErr
+	}
+	appErr = webAuthnService.authService.reauthenticate(claims.UserName, request.Code, request.Password, request.ClientIp)
+	if appErr != nil {
+		return nil, appErr
+	}
+	challenge, appErr := webAuthnService.newChallenge(claims.UserName, domain.WEBAUTHN_CEREMONY_REGISTRATION)
+	if appErr != nil {
+		return nil, appErr
+	}
+	credentials, appErr := webAuthnService.webAuthnRepository.FindCredentials(claims.UserName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return &dto.CredentialCreationOptions{PublicKey: dto.PublicKeyCredentialCreationOptions{
+		Challenge:    challenge,
+		RelyingParty: dto.RelyingParty{Id: webAuthnService.rpId, Name: webAuthnService.rpName},
+		User: dto.WebAuthnUser{
+			Id:          userHandle(claims.UserName),
+			Name:        claims.UserName,
+			DisplayName: claims.UserName,
+		},
+		PubKeyCredParams: []dto.CredentialParameter{
+			{Type: PUBLIC_KEY_CREDENTIAL_TYPE, Alg: domain.COSE_ALG_ES256},
+			{Type: PUBLIC_KEY_CREDENTIAL_TYPE, Alg: domain.COSE_ALG_EDDSA},
+			{Type: PUBLIC_KEY_CREDENTIAL_TYPE, Alg: domain.COSE_ALG_RS256},
+		},
+		Timeout:            domain.WEBAUTHN_CHALLENGE_DURATION.Milliseconds(),
+		Attestation:        "none",
+		ExcludeCredentials: credentialDescriptors(credentials),
+		AuthenticatorSelection: dto.AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+	}}, nil
+}
+
+// FinishRegistration stores the public key of the new passkey once the response answers our challenge
+func (webAuthnService DefaultWebAuthnService) FinishRegistration(accessToken string, credential dto.RegistrationCredential) (*dto.CredentialDescriptor, *exceptions.AppError) {
+	claims, appErr := webAuthnService.authService.authenticateFirstPartyUser(accessToken)
+	if appErr != nil {
+		return nil, appErr
+	}
+	clientDataJSON, err := base64.RawURLEncoding.DecodeString(credential.Response.ClientDataJSON)
+	if err != nil {
+		return nil, exceptions.NewValidationError("invalid clientDataJSON encoding")
+	}
+	if _, appErr = webAuthnService.verifyClientData(clientDataJSON, domain.WEBAUTHN_CEREMONY_REGISTRATION, claims.UserName); appErr != nil {
+		return nil, appErr
+	}
+	attestationObject, err := base64.RawURLEncoding.DecodeString(credential.Response.AttestationObject)
+	if err != nil {
+		return nil, exceptions.NewValidationError("invalid attestationObject encoding")
+	}
+	authData, err := domain.ParseAttestationObject(attestationObject)
+	if err != nil {
+		logger.Error("Invalid webauthn attestation : " + err.Error())
+		return nil, exceptions.NewValidationError("invalid attestation")
+	}
+	if appErr = webAuthnService.verifyAuthenticatorData(authData); appErr != nil {
+		return nil, appErr
+	}
+	credentialId := base64.RawURLEncoding.EncodeToString(authData.CredentialId)
+	if credential.Id != credentialId {
+		return nil, exceptions.NewValidationError("credential id does not match the attestation")
+	}
+	appErr = webAuthnService.webAuthnRepository.SaveCredential(domain.WebAuthnCredential{
+		CredentialId: credentialId,
+		UserName:     claims.UserName,
+		PublicKey:    authData.PublicKey,
+		SignCount:    int64(authData.SignCount),
+	})
+	if appErr != nil {
+		return nil, appErr
+	}
+	return &dto.CredentialDescriptor{Type: PUBLIC_KEY_CREDENTIAL_TYPE, Id: credentialId}, nil
+}
+
+// BeginLogin issues an authentication challenge, when a user name is given only that user's passkeys are allowed.
+// An unknown user name gets an empty allow list rather than an error so accounts cannot be enumerated
+func (webAuthnService DefaultWebAuthnService) BeginLogin(request dto.WebAuthnLoginRequest) (*dto.CredentialRequestOptions, *exceptions.AppError) {
+	challenge, appErr := webAuthnService.newChallenge(request.UserName, domain.WEBAUTHN_CEREMONY_AUTHENTICATION)
+	if appErr != nil {
+		return nil, appErr
+	}
+	allowCredentials := make([]dto.CredentialDescriptor, 0)
+	if request.UserName != "" {
+		credentials, appErr := webAuthnService.webAuthnRepository.FindCredentials(request.UserName)
+		if appErr != nil {
+			return nil, appErr
+		}
+		allowCredentials = credentialDescriptors(credentials)
+	}
+	return &dto.CredentialRequestOptions{PublicKey: dto.PublicKeyCredentialRequestOptions{
+		Challenge:        challenge,
+		RpId:             webAuthnService.rpId,
+		AllowCredentials: allowCredentials,
+		UserVerification: "preferred",
+		Timeout:          domain.WEBAUTHN_CHALLENGE_DURATION.Milliseconds(),
+	}}, nil
+}
+
+// FinishLogin verifies the assertion against the stored public key and returns the same tokens as a password login,
+// an authenticator that did not verify the user gets the mfa challenge instead
+func (webAuthnService DefaultWebAuthnService) FinishLogin(credential dto.AssertionCredential) (*dto.LoginResponse, *exceptions.AppError) {
+	stored, appErr := webAuthnService.webAuthnRepository.FindCredential(credential.Id)
+	if appErr != nil {
+		return nil, appErr
+	}
+	clientDataJSON, err := base64.RawURLEncoding.DecodeString(credential.Response.ClientDataJSON)
+	if err != nil {
+		return nil, exceptions.NewValidationError("invalid clientDataJSON encoding")
+	}
+	challenge, appErr := webAuthnService.verifyClientData(clientDataJSON, domain.WEBAUTHN_CEREMONY_AUTHENTICATION, "")
+	if appErr != nil {
+		return nil, appErr
+	}
+	if challenge.UserName != "" && challenge.UserName != stored.UserName {
+		return nil, exceptions.NewUnauthorisedError("credential does not belong to the user")
+	}
+	rawAuthData, err := base64.RawURLEncoding.DecodeString(credential.Response.AuthenticatorData)
+	if err != nil {
+		return nil, exceptions.NewValidationError("invalid authenticatorData encoding")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(credential.Response.Signature)
+	if err != nil {
+		return nil, exceptions.NewValidationError("invalid signature encoding")
+	}
+	authData, err := domain.ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		logger.Error("Invalid webauthn authenticator data : " + err.Error())
+		return nil, exceptions.NewValidationError("invalid authenticator data")
+	}
+	if appErr = webAuthnService.verifyAuthenticatorData(authData); appErr != nil {
+		return nil, appErr
+	}
+	if err = domain.VerifyAssertionSignature(stored.PublicKey, rawAuthData, clientDataJSON, signature); err != nil {
+		return nil, exceptions.NewUnauthorisedError(err.Error())
+	}
+	// authenticators that keep a counter must increase it on every use, a counter that goes
+	// backwards means the private key has been copied to another authenticator
+	signCount := int64(authData.SignCount)
+	if (signCount != 0 || stored.SignCount != 0) && signCount <= stored.SignCount {
+		domain.RaiseAuditEvent(domain.AuditEvent{
+			Type:     domain.AUDIT_WEBAUTHN_CLONED_AUTHENTICATOR,
+			UserName: stored.UserName,
+			Detail: "credential " + stored.CredentialId + " sign count " + strconv.FormatInt(signCount, 10) +
+				" not greater than stored " + strconv.FormatInt(stored.SignCount, 10),
+		})
+		return nil, exceptions.NewUnauthorisedError("authenticator sign count did not increase")
+	}
+	if appErr = webAuthnService.webAuthnRepository.UpdateSignCount(stored.CredentialId, signCount); appErr != nil {
+		return nil, appErr
+	}
+	user, appErr := webAuthnService.authService.repository.FindUser(stored.UserName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if appErr = checkUserActive(user); appErr != nil {
+		return nil, appErr
+	}
+	if !authData.UserVerified() {
+		// without user verification the passkey only proves possession, like a password it
+		// then needs the second factor
+		return webAuthnService.authService.secondFactorLogin(*user)
+	}
+	login, appErr := webAuthnService.authService.newLogin(*user, "")
+	if appErr != nil {
+		return nil, appErr
+	}
+	return webAuthnService.authService.tokenService.GenerateToken(login)
+}
+
+func (webAuthnService DefaultWebAuthnService) newChallenge(userName string, ceremony string) (string, *exceptions.AppError) {
+	challenge := domain.NewTokenId()
+	appErr := webAuthnService.webAuthnRepository.SaveChallenge(domain.WebAuthnChallenge{
+		Challenge: challenge,
+		UserName:  userName,
+		Ceremony:  ceremony,
+		ExpiresAt: time.Now().Add(domain.WEBAUTHN_CHALLENGE_DURATION).Unix(),
+	})
+	if appErr != nil {
+		return "", appErr
+	}
+	return challenge, nil
+}
+
+// verifyClientData consumes the challenge the client data answers and checks it was issued for this
+// ceremony, and for registration to this user, and that the browser reports one of our origins
+func (webAuthnService DefaultWebAuthnService) verifyClientData(clientDataJSON []byte, ceremony string, userName string) (*domain.WebAuthnChallenge, *exceptions.AppError) {
+	clientData, err := domain.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, exceptions.NewValidationError("invalid clientDataJSON")
+	}
+	if clientData.Type != ceremony {
+		return nil, exceptions.NewValidationError("unexpected client data type " + clientData.Type)
+	}
+	if !containsString(webAuthnService.origins, clientData.Origin) {
+		return nil, exceptions.NewUnauthorisedError("unexpected origin " + clientData.Origin)
+	}
+	challenge, appErr := webAuthnService.webAuthnRepository.ConsumeChallenge(clientData.Challenge)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if challenge.HasExpired() || challenge.Ceremony != ceremony || (userName != "" && challenge.UserName != userName) {
+		return nil, exceptions.NewUnauthorisedError("invalid webauthn challenge")
+	}
+	return challenge, nil
+}
+
+func (webAuthnService DefaultWebAuthnService) verifyAuthenticatorData(authData *domain.AuthenticatorData) *exceptions.AppError {
+	if !authData.VerifyRpId(webAuthnService.rpId) {
+		return exceptions.NewUnauthorisedError("credential is not scoped to this relying party")
+	}
+	if !authData.UserPresent() {
+		return exceptions.NewUnauthorisedError("user presence was not confirmed")
+	}
+	return nil
+}
+
+// userHandle is the opaque WebAuthn user id, derived from the user name so it carries no personal data
+func userHandle(userName string) string {
+	hash := sha256.Sum256([]byte(userName))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func credentialDescriptors(credentials []domain.WebAuthnCredential) []dto.CredentialDescriptor {
+	descriptors := make([]dto.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		descriptors = append(descriptors, dto.CredentialDescriptor{Type: PUBLIC_KEY_CREDENTIAL_TYPE, Id: credential.CredentialId})
+	}
+	return descriptors
+}
+
+func NewWebAuthnService(authService DefaultAuthService, webAuthnRepository domain.WebAuthnRepository, rpId string,
+	rpName string, origins []string) DefaultWebAuthnService {
+	return DefaultWebAuthnService{authService: authService, webAuthnRepository: webAuthnRepository, rpId: rpId,
+		rpName: rpName, origins: origins}
+}
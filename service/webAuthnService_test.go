@@ -0,0 +1,317 @@
+package service
+
+import (
+	"banking-auth/domain"
+	"banking-auth/dto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/barnettt/banking-lib/exceptions"
+	"github.com/fxamacker/cbor/v2"
+	"net/http"
+	"testing"
+)
+
+const (
+	testRpId   string = "localhost"
+	testOrigin string = "https://localhost"
+	testUser   string = "2001"
+)
+
+const (
+	flagUserPresent  byte = 0x01
+	flagUserVerified byte = 0x04
+	flagAttestedData byte = 0x40
+)
+
+// softwareAuthenticator plays the part of a security key with a single ES256 credential
+type softwareAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	signCount    uint32
+	origin       string
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialId := make([]byte, 16)
+	if _, err = rand.Read(credentialId); err != nil {
+		t.Fatal(err)
+	}
+	return &softwareAuthenticator{key: key, credentialId: credentialId, origin: testOrigin}
+}
+
+func (authenticator *softwareAuthenticator) id() string {
+	return base64.RawURLEncoding.EncodeToString(authenticator.credentialId)
+}
+
+func (authenticator *softwareAuthenticator) clientData(t *testing.T, ceremony string, challenge string) []byte {
+	clientData, err := json.Marshal(domain.CollectedClientData{Type: ceremony, Challenge: challenge, Origin: authenticator.origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientData
+}
+
+func (authenticator *softwareAuthenticator) authenticatorData(t *testing.T, flags byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(testRpId))
+	data := append([]byte{}, rpIdHash[:]...)
+	data = append(data, flags)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:37], authenticator.signCount)
+	if flags&flagAttestedData == 0 {
+		return data
+	}
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,
+		3:  domain.COSE_ALG_ES256,
+		-1: 1,
+		-2: authenticator.key.X.FillBytes(make([]byte, 32)),
+		-3: authenticator.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 16)...)
+	idLength := make([]byte, 2)
+	binary.BigEndian.PutUint16(idLength, uint16(len(authenticator.credentialId)))
+	data = append(data, idLength...)
+	data = append(data, authenticator.credentialId...)
+	return append(data, coseKey...)
+}
+
+func (authenticator *softwareAuthenticator) create(t *testing.T, options *dto.CredentialCreationOptions, flags byte) dto.RegistrationCredential {
+	attestationObject, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authenticator.authenticatorData(t, flags|flagAttestedData),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientData := authenticator.clientData(t, domain.WEBAUTHN_CEREMONY_REGISTRATION, options.PublicKey.Challenge)
+	return dto.RegistrationCredential{
+		Id:    authenticator.id(),
+		RawId: authenticator.id(),
+		Type:  PUBLIC_KEY_CREDENTIAL_TYPE,
+		Response: dto.AttestationResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientData),
+			AttestationObject: base64.RawURLEncoding.EncodeToString(attestationObject),
+		},
+	}
+}
+
+func (authenticator *softwareAuthenticator) get(t *testing.T, options *dto.CredentialRequestOptions, flags byte) dto.AssertionCredential {
+	authData := authenticator.authenticatorData(t, flags)
+	clientData := authenticator.clientData(t, domain.WEBAUTHN_CEREMONY_AUTHENTICATION, options.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, authenticator.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dto.AssertionCredential{
+		Id:    authenticator.id(),
+		RawId: authenticator.id(),
+		Type:  PUBLIC_KEY_CREDENTIAL_TYPE,
+		Response: dto.AssertionResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientData),
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			Signature:         base64.RawURLEncoding.EncodeToString(signature),
+		},
+	}
+}
+
+// reauthentication is the password of the seeded user, which registration asks for again
+var reauthentication = dto.WebAuthnRegistrationRequest{Password: testPassword}
+
+type webAuthnFixture struct {
+	service     DefaultWebAuthnService
+	auth        authFixture
+	storage     domain.Storage
+	accessToken string
+}
+
+func newWebAuthnFixture(t *testing.T) webAuthnFixture {
+	fixture := newAuthFixture(t)
+	return webAuthnFixture{
+		service:     NewWebAuthnService(fixture.service, fixture.storage.WebAuthn, testRpId, "test", []string{testOrigin}),
+		auth:        fixture,
+		storage:     fixture.storage,
+		accessToken: fixture.login(t, testUser).Token,
+	}
+}
+
+func (fixture webAuthnFixture) register(t *testing.T, authenticator *softwareAuthenticator) {
+	options, appErr := fixture.service.BeginRegistration(fixture.accessToken, reauthentication)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	credential := authenticator.create(t, options, flagUserPresent|flagUserVerified)
+	if _, appErr = fixture.service.FinishRegistration(fixture.accessToken, credential); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+}
+
+func (fixture webAuthnFixture) login(t *testing.T, authenticator *softwareAuthenticator, flags byte) (*dto.LoginResponse, dto.AssertionCredential, *exceptions.AppError) {
+	options, appErr := fixture.service.BeginLogin(dto.WebAuthnLoginRequest{UserName: testUser})
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	authenticator.signCount++
+	assertion := authenticator.get(t, options, flags)
+	response, appErr := fixture.service.FinishLogin(assertion)
+	return response, assertion, appErr
+}
+
+func TestWebAuthnRoundTrip(t *testing.T) {
+	fixture := newWebAuthnFixture(t)
+	authenticator := newSoftwareAuthenticator(t)
+	fixture.register(t, authenticator)
+
+	response, _, appErr := fixture.login(t, authenticator, flagUserPresent|flagUserVerified)
+	if appErr != nil {
+		t.Fatalf("login failed: %s", appErr.Message)
+	}
+	if response.Token == "" || response.RefreshToken == "" || response.MfaRequired {
+		t.Fatalf("expected tokens, got %+v", response)
+	}
+	stored, appErr := fixture.storage.WebAuthn.FindCredential(authenticator.id())
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if stored.SignCount != int64(authenticator.signCount) {
+		t.Errorf("sign count %d was not stored, got %d", authenticator.signCount, stored.SignCount)
+	}
+}
+
+func TestWebAuthnRejectsBadOrigin(t *testing.T) {
+	fixture := newWebAuthnFixture(t)
+	authenticator := newSoftwareAuthenticator(t)
+	authenticator.origin = "https://evil.example"
+
+	options, appErr := fixture.service.BeginRegistration(fixture.accessToken, reauthentication)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	credential := authenticator.create(t, options, flagUserPresent|flagUserVerified)
+	if _, appErr = fixture.service.FinishRegistration(fixture.accessToken, credential); appErr == nil {
+		t.Fatal("registration from a foreign origin was accepted")
+	}
+
+	authenticator.origin = testOrigin
+	fixture.register(t, authenticator)
+	authenticator.origin = "https://evil.example"
+	if _, _, appErr = fixture.login(t, authenticator, flagUserPresent|flagUserVerified); appErr == nil {
+		t.Fatal("assertion from a foreign origin was accepted")
+	}
+}
+
+func TestWebAuthnRejectsReplayedChallenge(t *testing.T) {
+	fixture := newWebAuthnFixture(t)
+	authenticator := newSoftwareAuthenticator(t)
+
+	options, appErr := fixture.service.BeginRegistration(fixture.accessToken, reauthentication)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	credential := authenticator.create(t, options, flagUserPresent|flagUserVerified)
+	if _, appErr = fixture.service.FinishRegistration(fixture.accessToken, credential); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if _, appErr = fixture.service.FinishRegistration(fixture.accessToken, credential); appErr == nil {
+		t.Fatal("replayed registration was accepted")
+	}
+
+	_, assertion, appErr := fixture.login(t, authenticator, flagUserPresent|flagUserVerified)
+	if appErr != nil {
+		t.Fatalf("login failed: %s", appErr.Message)
+	}
+	if _, appErr = fixture.service.FinishLogin(assertion); appErr == nil {
+		t.Fatal("replayed assertion was accepted")
+	}
+}
+
+func TestWebAuthnRejectsSignCountRegression(t *testing.T) {
+	fixture := newWebAuthnFixture(t)
+	authenticator := newSoftwareAuthenticator(t)
+	authenticator.signCount = 10
+	fixture.register(t, authenticator)
+
+	if _, _, appErr := fixture.login(t, authenticator, flagUserPresent|flagUserVerified); appErr != nil {
+		t.Fatalf("login failed: %s", appErr.Message)
+	}
+	// a clone of the key that has been used less often than the original
+	authenticator.signCount = 5
+	_, _, appErr := fixture.login(t, authenticator, flagUserPresent|flagUserVerified)
+	if appErr == nil {
+		t.Fatal("assertion with a lower sign count was accepted")
+	}
+	if appErr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d %s", appErr.Code, appErr.Message)
+	}
+}
+
+func TestWebAuthnWithoutUserVerification(t *testing.T) {
+	fixture := newWebAuthnFixture(t)
+	authenticator := newSoftwareAuthenticator(t)
+	fixture.register(t, authenticator)
+
+	_, _, appErr := fixture.login(t, authenticator, flagUserPresent)
+	if appErr == nil {
+		t.Fatal("assertion without user verification logged in a user with no second factor")
+	}
+	if appErr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d %s", appErr.Code, appErr.Message)
+	}
+
+	if appErr := fixture.storage.Mfa.SaveTotp(domain.TotpEnrollment{UserName: testUser, Secret: "JBSWY3DPEHPK3PXP"}); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	if appErr := fixture.storage.Mfa.ConfirmTotp(testUser); appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	response, _, appErr := fixture.login(t, authenticator, flagUserPresent)
+	if appErr != nil {
+		t.Fatalf("login failed: %s", appErr.Message)
+	}
+	if !response.MfaRequired || response.MfaToken == "" || response.Token != "" {
+		t.Fatalf("expected an mfa challenge, got %+v", response)
+	}
+}
+
+func TestWebAuthnRegistrationRequiresReauthentication(t *testing.T) {
+	fixture := newWebAuthnFixture(t)
+	if _, appErr := fixture.service.BeginRegistration(fixture.accessToken, dto.WebAuthnRegistrationRequest{}); appErr == nil {
+		t.Fatal("registration started without the password or a one-time code")
+	}
+	wrongPassword := dto.WebAuthnRegistrationRequest{Password: "wrong"}
+	if _, appErr := fixture.service.BeginRegistration(fixture.accessToken, wrongPassword); appErr == nil {
+		t.Fatal("registration started with the wrong password")
+	}
+}
+
+func TestWebAuthnRegistrationRefusesDelegatedToken(t *testing.T) {
+	fixture := newWebAuthnFixture(t)
+	authenticator := newSoftwareAuthenticator(t)
+	delegatedToken := fixture.auth.delegatedLogin(t, testUser).Token
+
+	if _, appErr := fixture.service.BeginRegistration(delegatedToken, reauthentication); appErr == nil {
+		t.Fatal("a client application started a passkey registration for the user")
+	}
+	options, appErr := fixture.service.BeginRegistration(fixture.accessToken, reauthentication)
+	if appErr != nil {
+		t.Fatal(appErr.Message)
+	}
+	credential := authenticator.create(t, options, flagUserPresent|flagUserVerified)
+	if _, appErr = fixture.service.FinishRegistration(delegatedToken, credential); appErr == nil {
+		t.Fatal("a client application registered a passkey for the user")
+	}
+}